@@ -19,6 +19,7 @@ package dlshared
 import(
 	"time"
 	"bytes"
+	"context"
 	"strings"
 	"math/rand"
 	"encoding/json"
@@ -327,19 +328,29 @@ func (self *ApplePushNotificationSvc) listenForRequests() {
 	for msg := range self.requestChannel { self.processMsg(msg) }
 }
 
+// The schema for the configuration path. This implements the ConfigurationSchemaProvider interface.
+func (self *ApplePushNotificationSvc) ConfigurationSchema() *ConfigurationSchema {
+
+	schema := NewConfigurationSchema(self.configPath)
+	schema.Key("gateway", StringConfigurationType).Require()
+	schema.Key("feedback", StringConfigurationType).Require()
+	schema.Key("certificateFile", StringConfigurationType).Require()
+	schema.Key("keyFile", StringConfigurationType).Require()
+	schema.Key("socketTimeoutInMs", IntConfigurationType).AtLeast(0)
+	schema.Key("msgCacheElementCount", IntConfigurationType).AtLeast(1)
+
+	return schema
+}
+
 func (self *ApplePushNotificationSvc) Start(kernel *Kernel) error {
 
-	gateway := strings.TrimSpace(kernel.Configuration.StringWithPath(self.configPath, "gateway", ""))
-	if len(gateway) == 0 { return NewStackError("Did not set the apn.gateway config") }
+	// All of the missing values are returned at once (the kernel also validates the schema before start).
+	if err := kernel.Configuration.Validate(self.ConfigurationSchema()); err != nil { return err }
 
+	gateway := strings.TrimSpace(kernel.Configuration.StringWithPath(self.configPath, "gateway", ""))
 	feedback := strings.TrimSpace(kernel.Configuration.StringWithPath(self.configPath, "feedback", ""))
-	if len(feedback) == 0 { return NewStackError("Did not set the apn.feedback config") }
-
 	certificateFile := strings.TrimSpace(kernel.Configuration.StringWithPath(self.configPath, "certificateFile", ""))
-	if len(certificateFile) == 0 { return NewStackError("Did not set the apn.certificateFile config") }
-
 	keyFile := strings.TrimSpace(kernel.Configuration.StringWithPath(self.configPath, "keyFile", ""))
-	if len(keyFile) == 0 { return NewStackError("Did not set the apn.keyFile config") }
 
 	socketTimeoutInMs := int64(kernel.Configuration.IntWithPath(self.configPath, "socketTimeoutInMs", 3000))
 
@@ -358,7 +369,7 @@ func (self *ApplePushNotificationSvc) Start(kernel *Kernel) error {
 														certificateFile,
 														keyFile)
 
-	if err = self.gatewayProcessor.Start(); err != nil { return NewStackError("Unable to start gateway - err: %v", err) }
+	if err = self.gatewayProcessor.Start(kernel); err != nil { return NewStackError("Unable to start gateway - err: %v", err) }
 
 	self.feedbackProcessor = NewTlsTcpSocketProcessor(	feedback,
 														socketTimeoutInMs,
@@ -371,7 +382,7 @@ func (self *ApplePushNotificationSvc) Start(kernel *Kernel) error {
 														certificateFile,
 														keyFile)
 
-	if err = self.feedbackProcessor.Start(); err != nil { return NewStackError("Unable to start feedback - err: %v", err) }
+	if err = self.feedbackProcessor.Start(kernel); err != nil { return NewStackError("Unable to start feedback - err: %v", err) }
 
 	go self.processGatwayReads()
 
@@ -382,6 +393,13 @@ func (self *ApplePushNotificationSvc) Start(kernel *Kernel) error {
 	return nil
 }
 
+// The service is healthy if the gateway socket is connected. Apple closes the feedback connection when
+// it is done sending so it is not checked. This implements the HealthChecker interface.
+func (self *ApplePushNotificationSvc) CheckHealth(ctx context.Context) (string, error) {
+	if self.gatewayProcessor == nil { return nadaStr, NewStackError("Apn service not started") }
+	return self.gatewayProcessor.CheckHealth(ctx)
+}
+
 func (self *ApplePushNotificationSvc) Stop(kernel *Kernel) error {
 
 	if self.feedbackProcessor != nil { self.feedbackProcessor.Stop() }
@@ -18,9 +18,11 @@ package dlshared
 
 import (
 	"io"
+	"os"
 	"fmt"
 	"bytes"
 	"errors"
+	"compress/gzip"
 	"compress/zlib"
 )
 
@@ -56,4 +58,55 @@ func UncompressBytes(val []byte) ([]byte, error) {
 	return out.Bytes(), nil
 }
 
+// Compress the byte slice using gzip.
+func GzipBytes(val []byte) ([]byte, error) {
 
+	var b bytes.Buffer
+	writer := gzip.NewWriter(&b)
+
+	if _, err := writer.Write(val); err != nil { return nil, err }
+
+	if err := writer.Close(); err != nil { return nil, err }
+
+	return b.Bytes(), nil
+}
+
+// Uncompress the byte slice using gzip.
+func GunzipBytes(val []byte) ([]byte, error) {
+
+	reader, err := gzip.NewReader(bytes.NewBuffer(val))
+	if err != nil { return nil, err }
+
+	var out bytes.Buffer
+	if _, err = io.Copy(&out, reader); err != nil { return nil, err }
+
+	if err := reader.Close(); err != nil { return nil, err }
+
+	return out.Bytes(), nil
+}
+
+// Compress a file using gzip. The file is written to a temporary file that is renamed when
+// the compression is complete, so the gzip file is never partially written. The source file
+// is not removed.
+func GzipFile(fileName, gzipFileName string) error {
+
+	source, err := os.Open(fileName)
+	if err != nil { return err }
+	defer source.Close()
+
+	tmpFileName := gzipFileName + ".tmp"
+
+	target, err := os.OpenFile(tmpFileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0640)
+	if err != nil { return err }
+
+	writer := gzip.NewWriter(target)
+
+	_, err = io.Copy(writer, source)
+	if err == nil { err = writer.Close() }
+	if closeErr := target.Close(); err == nil { err = closeErr }
+	if err == nil { err = os.Rename(tmpFileName, gzipFileName) }
+
+	if err != nil { os.Remove(tmpFileName) }
+
+	return err
+}
@@ -17,8 +17,11 @@
 package dlshared
 
 import (
+	"os"
 	"bytes"
 	"testing"
+	"io/ioutil"
+	"path/filepath"
 )
 
 func TestCompressUncompressBytes(t *testing.T) {
@@ -53,3 +56,30 @@ func TestCompressUncompressBytes(t *testing.T) {
 	}
 }
 
+func TestGzipBytesAndFile(t *testing.T) {
+
+	testBytes := bytes.Repeat([]byte("this is a random test of this and that as a test that is random that is another"), 10001)
+
+	compressedBytes, err := GzipBytes(testBytes)
+	if err != nil || len(compressedBytes) == 0 || len(compressedBytes) >= len(testBytes) { t.Errorf("TestGzipBytesAndFile is broken - unable to compress bytes: %v", err); return }
+
+	if uncompressedBytes, err := GunzipBytes(compressedBytes); err != nil || !bytes.Equal(uncompressedBytes, testBytes) { t.Errorf("TestGzipBytesAndFile is broken - unable to uncompress bytes: %v", err) }
+
+	if _, err := GunzipBytes(testBytes); err == nil { t.Errorf("TestGzipBytesAndFile is broken - expected an invalid header error") }
+
+	dir, err := ioutil.TempDir("", "dlshared_test_gzip")
+	if err != nil { t.Errorf("TestGzipBytesAndFile is broken: %v", err); return }
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "test.log")
+	ioutil.WriteFile(fileName, testBytes, 0640)
+
+	if err := GzipFile(fileName, fileName + ".gz"); err != nil { t.Errorf("TestGzipBytesAndFile is broken - unable to compress file: %v", err); return }
+
+	gzipBytes, _ := ioutil.ReadFile(fileName + ".gz")
+	if uncompressedBytes, err := GunzipBytes(gzipBytes); err != nil || !bytes.Equal(uncompressedBytes, testBytes) { t.Errorf("TestGzipBytesAndFile is broken - unable to uncompress file: %v", err) }
+
+	if _, err := os.Stat(fileName + ".gz.tmp"); !os.IsNotExist(err) { t.Errorf("TestGzipBytesAndFile is broken - the temporary file was not renamed") }
+
+	if err := GzipFile(filepath.Join(dir, "missing.log"), filepath.Join(dir, "missing.log.gz")); err == nil { t.Errorf("TestGzipBytesAndFile is broken - expected a missing file error") }
+}
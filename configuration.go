@@ -19,7 +19,7 @@ package dlshared
 import (
 	"os"
 	"fmt"
-	"github.com/daviddengcn/go-ljson-conf"
+	"sync"
 )
 
 type Configuration struct {
@@ -29,25 +29,96 @@ type Configuration struct {
 	Environment string
 	Hostname string
 	FileName string
-	data *ljconf.Conf
+	FileNames []string // The base file and the overlay files that were loaded (in order).
+	data configurationData // Replaced when the configuration is reloaded (see Reload).
+	dataLock sync.RWMutex
+	reloadLock sync.Mutex
+	fileStamps []configurationFileStamp
+	layers []*configurationLayer // The documents from the files (or map) - replaced when the files are reloaded.
+	dynamicLayers []*configurationLayer // Merged over the files (see SetLayer).
+	listeners []*configurationListener
+	listenerLock sync.Mutex
+	keys sync.Map // The keys that were read and the defaults passed to the accessors (see Dump).
 }
 
 const confPathKeyPattern = "%s.%s"
 
-func NewConfiguration(fileName string) (*Configuration, error) {
+// Load the configuration file and the overlay files. The overlays are merged into the base file in
+// order (see mergeConfigurationDocument). All of the files must exist. The format of each file is
+// selected by the extension: .json/.ljson, .yaml/.yml or .toml (see RegisterConfigurationFormat).
+func NewConfiguration(fileName string, overlayFileNames ...string) (*Configuration, error) {
 
-	conf := &Configuration{ FileName : fileName }
+	fileNames := append([]string{ fileName }, overlayFileNames...)
 
-	var err error
-	if conf.data, err = ljconf.Load(fileName); err != nil { return nil, NewStackError("Unable to load configuration file - error: %v", err) }
+	// The files are checked before they are loaded, so a change while loading is found by FilesModified.
+	stamps, err := configurationFileStamps(fileNames)
+	if err != nil { return nil, err }
 
-	conf.PidFile = conf.data.String("pidFile", "")
+	layers, err := loadConfigurationFiles(fileNames)
+	if err != nil { return nil, err }
+
+	conf, err := newConfiguration(fileName, layers)
+	if err != nil { return nil, err }
+
+	conf.FileNames = fileNames
+	conf.fileStamps = stamps
 
 	if len(conf.PidFile) == 0 { return nil, NewStackError("Configuration file error - pidFile not set") }
 
-	conf.Environment = conf.data.String("environment", "")
+	return conf, nil
+}
+
+// Load the configuration file and the environment overlays that are present. For "configuration.json"
+// in the "prod" environment, the overlays are "configuration.prod.json" and then "configuration.local.json"
+// (in the same directory). The environment is set in the base file (or the DLSHARED_ENVIRONMENT variable).
+// This is used by StartKernel.
+func NewEnvironmentConfiguration(fileName string) (*Configuration, error) {
+
+	environment, err := configurationFileEnvironment(fileName)
+	if err != nil { return nil, err }
+
+	overlayFileNames, err := environmentOverlayFileNames(fileName, environment)
+	if err != nil { return nil, err }
+
+	return NewConfiguration(fileName, overlayFileNames...)
+}
+
+// Returns the raw environment value in the base file (or the override). The configuration is not built,
+// because the overlays can set the required values and replace the references.
+func configurationFileEnvironment(fileName string) (string, error) {
+
+	if environment, found := os.LookupEnv(EnvOverrideName("environment")); found { return environment, nil }
+
+	doc, err := NewFileConfigurationSource(fileName).Load()
+	if err != nil { return nadaStr, err }
 
-	conf.Version = conf.data.String("version", "")
+	environment, _ := doc["environment"].(string)
+
+	return environment, nil
+}
+
+// Create a configuration from an in-memory map (e.g., for tests). The map is in the same format as the
+// configuration file. The version and environment must be set, but the pidFile is optional.
+func NewConfigurationFromMap(values map[string]interface{}) (*Configuration, error) {
+
+	layer, err := newMapConfigurationLayer(values)
+	if err != nil { return nil, err }
+
+	return newConfiguration(nadaStr, []*configurationLayer{ layer })
+}
+
+func newConfiguration(fileName string, layers []*configurationLayer) (*Configuration, error) {
+
+	data, err := mergeConfigurationLayers(layers)
+	if err != nil { return nil, err }
+
+	conf := &Configuration{ FileName : fileName, data : data, layers: layers }
+
+	conf.PidFile = conf.String("pidFile", "")
+
+	conf.Environment = conf.String("environment", "")
+
+	conf.Version = conf.String("version", "")
 
 	conf.Pid = os.Getpid()
 
@@ -60,33 +131,79 @@ func NewConfiguration(fileName string) (*Configuration, error) {
 	return conf, nil
 }
 
-func (self *Configuration) String(key string, def string) string { return self.data.String(key, def) }
+// The accessors check the environment variable overrides first (see EnvOverrideName). The values from every
+// source are converted the same way (see configValueToInt): a string like " 9999" is an int, but a number
+// with a fraction is not - the default is returned. Note: this is a change, Int used to truncate a number with
+// a fraction (e.g., 4000.5 was 4000).
+
+func (self *Configuration) String(key string, def string) string {
+	self.recordKey(key, def)
+	if value, found := self.envOverride(key); found { return value }
+	return self.currentData().String(key, def)
+}
 
 func (self *Configuration) StringWithPath(path, key string, def string) string { return self.String(fmt.Sprintf(confPathKeyPattern, path, key), def) }
 
-func (self *Configuration) Int(key string, def int) int { return self.data.Int(key, def) }
+func (self *Configuration) Int(key string, def int) int {
+	self.recordKey(key, def)
+	if value, found := self.envOverride(key); found { return envInt(value, def) }
+	return self.currentData().Int(key, def)
+}
 
 func (self *Configuration) IntWithPath(path, key string, def int) int { return self.Int(fmt.Sprintf(confPathKeyPattern, path, key), def) }
 
-func (self *Configuration) Bool(key string, def bool) bool { return self.data.Bool(key, def) }
+func (self *Configuration) Bool(key string, def bool) bool {
+	self.recordKey(key, def)
+	if value, found := self.envOverride(key); found { return envBool(value, def) }
+	return self.currentData().Bool(key, def)
+}
 
 func (self *Configuration) BoolWithPath(path, key string, def bool) bool { return self.Bool(fmt.Sprintf(confPathKeyPattern, path, key), def) }
 
-func (self *Configuration) Float(key string, def float64) float64 { return self.data.Float(key, def) }
+func (self *Configuration) Float(key string, def float64) float64 {
+	self.recordKey(key, def)
+	if value, found := self.envOverride(key); found { return envFloat(value, def) }
+	return self.currentData().Float(key, def)
+}
 
 func (self *Configuration) FloatWithPath(path, key string, def float64) float64 { return self.Float(fmt.Sprintf(confPathKeyPattern, path, key), def) }
 
-func (self *Configuration) StrList(key string, def [] string) []string { return self.data.StringList(key, def) }
+func (self *Configuration) StrList(key string, def [] string) []string {
+	self.recordKey(key, def)
+	if value, found := self.envOverride(key); found { return envStringList(value) }
+	return self.currentData().StringList(key, def)
+}
 
-func (self *Configuration) IntList(key string, def []int) []int { return self.data.IntList(key, def) }
+func (self *Configuration) IntList(key string, def []int) []int {
+	self.recordKey(key, def)
+	if value, found := self.envOverride(key); found { return envIntList(value) }
+	return self.currentData().IntList(key, def)
+}
 
-func (self *Configuration) List(key string, def []interface{}) []interface{} { return self.data.List(key, def) }
+func (self *Configuration) List(key string, def []interface{}) []interface{} {
+	self.recordKey(key, def)
+	if value, found := self.envOverride(key); found { return envList(value) }
+	return self.currentData().List(key, def)
+}
 
 func (self *Configuration) ListWithPath(path, key string, def []interface{}) []interface{} { return self.List(fmt.Sprintf(confPathKeyPattern, path, key), def) }
 
-func (self *Configuration) Interface(key string, def interface{}) interface{} { return self.data.Interface(key, def) }
+// If the value is a json document, the overrides for the keys in the document are applied to a copy.
+func (self *Configuration) Interface(key string, def interface{}) interface{} {
+	self.recordKey(key, def)
+	data := self.currentData()
+	if value, found := envOverrideValue(data, key); found { return envInterface(value) }
+	return applyEnvOverrides(data, key, data.Interface(key, def))
+}
 
 func (self *Configuration) InterfaceWithPath(path, key string, def interface{}) interface{} { return self.Interface(fmt.Sprintf(confPathKeyPattern, path, key), def) }
 
+// Returns the merged configuration document with the environment variable overrides applied. The
+// document is a copy, changes are not applied to the configuration.
+func (self *Configuration) Document() map[string]interface{} {
+	data := self.currentData()
+	return applyEnvOverrides(data, nadaStr, data.Document()).(map[string]interface{})
+}
+
 func (self *Configuration) EnvironmentIs(expected string) bool { return self.Environment == expected }
 
@@ -0,0 +1,281 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"fmt"
+	"math"
+	"time"
+	"reflect"
+	"strings"
+	"strconv"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	byteSizeType = reflect.TypeOf(ByteSize(0))
+	timeType = reflect.TypeOf(time.Time{})
+	stringType = reflect.TypeOf(nadaStr)
+	float64Type = reflect.TypeOf(float64(0))
+	boolType = reflect.TypeOf(false)
+)
+
+// A number of bytes that can be set from a string with a unit (e.g., "10MB" or "512 KB"). The units are
+// powers of 1024 - B, KB, MB, GB and TB ("KiB", "MiB", etc. are the same and the unit is not case sensitive).
+// A number without a unit is in bytes.
+type ByteSize int64
+
+const (
+	Byte ByteSize = 1
+	KB = Byte << 10
+	MB = KB << 10
+	GB = MB << 10
+	TB = GB << 10
+)
+
+var byteSizeUnits = map[string]ByteSize { "": Byte, "b": Byte, "k": KB, "kb": KB, "kib": KB, "m": MB, "mb": MB, "mib": MB, "g": GB, "gb": GB, "gib": GB, "t": TB, "tb": TB, "tib": TB }
+
+// Parse a byte size (e.g., "10MB", "1.5GB" or "1024").
+func ParseByteSize(value string) (ByteSize, error) {
+
+	trimmed := strings.TrimSpace(value)
+
+	i := 0
+	for ; i < len(trimmed) && (trimmed[i] == '.' || (trimmed[i] >= '0' && trimmed[i] <= '9')); i++ { }
+
+	unit, found := byteSizeUnits[strings.ToLower(strings.TrimSpace(trimmed[i:]))]
+	if i == 0 || !found { return 0, fmt.Errorf("expected a byte size (e.g., \"10MB\") - received: %q", value) }
+
+	size, err := strconv.ParseFloat(trimmed[:i], 64)
+	if err != nil { return 0, fmt.Errorf("expected a byte size (e.g., \"10MB\") - received: %q", value) }
+
+	// The max int64 is rounded up to 2^63 as a float64, so it is out of range.
+	if size * float64(unit) >= math.MaxInt64 { return 0, fmt.Errorf("byte size out of range - received: %q", value) }
+
+	return ByteSize(size * float64(unit)), nil
+}
+
+// Returns the size with the largest unit that is a whole number (e.g., "10MB" or "1536KB").
+func (self ByteSize) String() string {
+	for _, unit := range []struct { size ByteSize; name string }{ { TB, "TB" }, { GB, "GB" }, { MB, "MB" }, { KB, "KB" } } {
+		if self != 0 && self % unit.size == 0 { return fmt.Sprintf("%d%s", self / unit.size, unit.name) }
+	}
+	return fmt.Sprintf("%dB", int64(self))
+}
+
+// Convert a configuration value (as decoded from json) to the type of the target and set it. Strings
+// are parsed for the numeric, bool, duration, byte size, time and slice types so values and defaults can
+// be set as strings (e.g., "30s", "10MB" or "a,b,c"). Durations set as numbers are in milliseconds and
+// byte sizes are in bytes. Structs are not supported, the caller must walk the struct fields.
+func setConfigValue(target reflect.Value, value interface{}) error {
+
+	targetType := target.Type()
+
+	switch targetType {
+		case durationType: {
+			duration, err := configValueToDuration(value)
+			if err != nil { return err }
+			target.SetInt(int64(duration))
+			return nil
+		}
+
+		case byteSizeType: {
+			size, err := configValueToByteSize(value)
+			if err != nil { return err }
+			target.SetInt(int64(size))
+			return nil
+		}
+
+		case timeType: {
+			str, ok := value.(string)
+			if !ok { return configValueTypeError(targetType, value) }
+			parsed, err := time.Parse(time.RFC3339, str)
+			if err != nil { return fmt.Errorf("expected an RFC3339 time - received: %q", str) }
+			target.Set(reflect.ValueOf(parsed))
+			return nil
+		}
+	}
+
+	switch targetType.Kind() {
+
+		case reflect.String: {
+			str, err := configValueToString(value)
+			if err != nil { return err }
+			target.SetString(str)
+		}
+
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64: {
+			i, err := configValueToInt(value)
+			if err != nil { return err }
+			if target.OverflowInt(i) { return fmt.Errorf("value out of range for %s - received: %d", targetType, i) }
+			target.SetInt(i)
+		}
+
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64: {
+			i, err := configValueToInt(value)
+			if err != nil { return err }
+			if i < 0 || target.OverflowUint(uint64(i)) { return fmt.Errorf("value out of range for %s - received: %d", targetType, i) }
+			target.SetUint(uint64(i))
+		}
+
+		case reflect.Float32, reflect.Float64: {
+			f, err := configValueToFloat(value)
+			if err != nil { return err }
+			if target.OverflowFloat(f) { return fmt.Errorf("value out of range for %s - received: %v", targetType, f) }
+			target.SetFloat(f)
+		}
+
+		case reflect.Bool: {
+			b, err := configValueToBool(value)
+			if err != nil { return err }
+			target.SetBool(b)
+		}
+
+		case reflect.Slice: {
+			var values []interface{}
+			switch v := value.(type) {
+				case []interface{}: values = v
+				case string: {
+					// A comma separated list (e.g., a default value).
+					for _, item := range strings.Split(v, commaStr) {
+						if item = strings.TrimSpace(item); len(item) > 0 { values = append(values, item) }
+					}
+				}
+				default: return configValueTypeError(targetType, value)
+			}
+
+			slice := reflect.MakeSlice(targetType, len(values), len(values))
+			for i := range values {
+				if err := setConfigValue(slice.Index(i), values[i]); err != nil { return fmt.Errorf("element [%d] - %v", i, err) }
+			}
+			target.Set(slice)
+		}
+
+		case reflect.Map: {
+			doc, ok := value.(map[string]interface{})
+			if !ok || targetType.Key().Kind() != reflect.String { return configValueTypeError(targetType, value) }
+
+			m := reflect.MakeMap(targetType)
+			for key, item := range doc {
+				element := reflect.New(targetType.Elem()).Elem()
+				if err := setConfigValue(element, item); err != nil { return fmt.Errorf("key %q - %v", key, err) }
+				m.SetMapIndex(reflect.ValueOf(key).Convert(targetType.Key()), element)
+			}
+			target.Set(m)
+		}
+
+		case reflect.Ptr: {
+			element := reflect.New(targetType.Elem())
+			if err := setConfigValue(element.Elem(), value); err != nil { return err }
+			target.Set(element)
+		}
+
+		case reflect.Interface: {
+			if value != nil && !reflect.TypeOf(value).AssignableTo(targetType) { return configValueTypeError(targetType, value) }
+			if value != nil { target.Set(reflect.ValueOf(value)) }
+		}
+
+		default: return fmt.Errorf("unsupported type: %s", targetType)
+	}
+
+	return nil
+}
+
+// Returns the duration. Strings are parsed with time.ParseDuration (e.g., "1m30s") and numbers are milliseconds.
+func configValueToDuration(value interface{}) (time.Duration, error) {
+	switch v := value.(type) {
+		case float64: return time.Duration(v * float64(time.Millisecond)), nil
+		case string: {
+			duration, err := time.ParseDuration(strings.TrimSpace(v))
+			if err != nil { return 0, fmt.Errorf("expected a duration (e.g., \"30s\") - received: %q", v) }
+			return duration, nil
+		}
+	}
+	return 0, configValueTypeError(durationType, value)
+}
+
+// Returns the byte size. Strings are parsed with ParseByteSize (e.g., "10MB") and numbers are bytes.
+func configValueToByteSize(value interface{}) (ByteSize, error) {
+	switch v := value.(type) {
+		case float64: {
+			if v != math.Trunc(v) || v < 0 { return 0, fmt.Errorf("expected a byte size - received: %v", v) }
+			return ByteSize(v), nil
+		}
+		case string: {
+			size, err := ParseByteSize(v)
+			if err != nil { return 0, err }
+			return size, nil
+		}
+	}
+	return 0, configValueTypeError(byteSizeType, value)
+}
+
+// The scalar conversions are used by the accessors, the environment variable overrides, the schema validation
+// and Unmarshal, so a value is read the same way from every source and format. The strings are trimmed and
+// parsed, the numbers and bools are formatted as strings, an int must be a whole number and a document or
+// list is never a scalar.
+
+func configValueToString(value interface{}) (string, error) {
+	switch v := value.(type) {
+		case string: return v, nil
+		case float64, bool: return fmt.Sprint(v), nil
+	}
+	return nadaStr, configValueTypeError(stringType, value)
+}
+
+func configValueToFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+		case float64: return v, nil
+		case string: {
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil { return 0, fmt.Errorf("expected a number - received: %q", v) }
+			return f, nil
+		}
+	}
+	return 0, configValueTypeError(float64Type, value)
+}
+
+func configValueToBool(value interface{}) (bool, error) {
+	switch v := value.(type) {
+		case bool: return v, nil
+		case string: {
+			b, err := strconv.ParseBool(strings.TrimSpace(v))
+			if err != nil { return false, fmt.Errorf("expected a bool - received: %q", v) }
+			return b, nil
+		}
+	}
+	return false, configValueTypeError(boolType, value)
+}
+
+func configValueToInt(value interface{}) (int64, error) {
+	switch v := value.(type) {
+		case float64: {
+			if v != math.Trunc(v) { return 0, fmt.Errorf("expected an integer - received: %v", v) }
+			return int64(v), nil
+		}
+		case string: {
+			i, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil { return 0, fmt.Errorf("expected an integer - received: %q", v) }
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("expected an integer - received: %T", value)
+}
+
+func configValueTypeError(targetType reflect.Type, value interface{}) error {
+	return fmt.Errorf("unable to convert %T to %s", value, targetType)
+}
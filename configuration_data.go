@@ -0,0 +1,187 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"strings"
+	"encoding/json"
+)
+
+// The configuration data. The keys are json paths (e.g., "server.http.port"). The source is where
+// the value for the key came from (see ConfigurationValue).
+type configurationData interface {
+	String(key string, def string) string
+	Int(key string, def int) int
+	Bool(key string, def bool) bool
+	Float(key string, def float64) float64
+	StringList(key string, def []string) []string
+	IntList(key string, def []int) []int
+	List(key string, def []interface{}) []interface{}
+	Interface(key string, def interface{}) interface{}
+	Document() map[string]interface{}
+	source(key string) string
+	secret(key string) bool
+	envOverride(name string) (string, bool)
+	hasEnvOverrides() bool
+}
+
+// The configuration data loaded from the files or from an in-memory map. The map values are converted to
+// the same types the json decoder produces (e.g., all numbers are float64) so components see the same values
+// they would from a file. The sources are the keys set by the overlay files, all other keys are from the default source.
+// The secrets are the keys that had references (see resolveConfigurationReferences). The env overrides are the environment
+// variable overrides by name and the env secrets are the overrides that had references (see loadEnvOverrides). The accessors convert
+// the values the same way for every source and format (e.g., Int returns 9999 for "9999" - see configValueToInt).
+type mapConfigurationData struct {
+	values map[string]interface{}
+	defaultSource string
+	sources map[string]string
+	secrets map[string]bool
+	envOverrides map[string]string
+	envSecrets map[string]bool
+}
+
+// The map is converted to the json types with the json encoder.
+func newMapConfigurationLayer(values map[string]interface{}) (*configurationLayer, error) {
+
+	rawJson, err := json.Marshal(values)
+	if err != nil { return nil, NewStackError("Unable to convert configuration map - error: %v", err) }
+
+	layer := &configurationLayer{ name: MapSource }
+	if err := json.Unmarshal(rawJson, &layer.doc); err != nil { return nil, NewStackError("Unable to convert configuration map - error: %v", err) }
+
+	if layer.doc == nil { layer.doc = make(map[string]interface{}) }
+
+	return layer, nil
+}
+
+// Returns the value at the json path or false if it is not found.
+func (self *mapConfigurationData) get(key string) (interface{}, bool) {
+
+	var value interface{} = self.values
+
+	for _, name := range strings.Split(key, ".") {
+		doc, ok := value.(map[string]interface{})
+		if !ok { return nil, false }
+		if value, ok = doc[name]; !ok { return nil, false }
+	}
+
+	return value, true
+}
+
+func (self *mapConfigurationData) String(key string, def string) string {
+	value, _ := self.get(key)
+	if str, ok := configurationString(value); ok { return str }
+	return def
+}
+
+func (self *mapConfigurationData) Int(key string, def int) int {
+	value, _ := self.get(key)
+	if i, ok := configurationInt(value); ok { return i }
+	return def
+}
+
+func (self *mapConfigurationData) Bool(key string, def bool) bool {
+	value, _ := self.get(key)
+	if b, ok := configurationBool(value); ok { return b }
+	return def
+}
+
+func (self *mapConfigurationData) Float(key string, def float64) float64 {
+	value, _ := self.get(key)
+	if f, ok := configurationFloat(value); ok { return f }
+	return def
+}
+
+func (self *mapConfigurationData) List(key string, def []interface{}) []interface{} {
+	value, found := self.get(key)
+	if !found { return def }
+	if list, ok := value.([]interface{}); ok { return list }
+	return def
+}
+
+func (self *mapConfigurationData) StringList(key string, def []string) []string {
+	list := self.List(key, nil)
+	if list == nil { return def }
+
+	values := make([]string, 0, len(list))
+	for _, value := range list { if str, ok := configurationString(value); ok { values = append(values, str) } }
+	return values
+}
+
+func (self *mapConfigurationData) IntList(key string, def []int) []int {
+	list := self.List(key, nil)
+	if list == nil { return def }
+
+	values := make([]int, 0, len(list))
+	for _, value := range list { if i, ok := configurationInt(value); ok { values = append(values, i) } }
+	return values
+}
+
+func (self *mapConfigurationData) Interface(key string, def interface{}) interface{} {
+	value, found := self.get(key)
+	if !found { return def }
+	return value
+}
+
+// Returns a copy of the document.
+func (self *mapConfigurationData) Document() map[string]interface{} { return copyConfigurationValue(self.values).(map[string]interface{}) }
+
+// Returns the source of the key or of the closest document that contains the key.
+func (self *mapConfigurationData) source(key string) string {
+	for path := key; len(path) > 0; {
+		if source, found := self.sources[path]; found { return source }
+		index := strings.LastIndex(path, ".")
+		if index < 0 { break }
+		path = path[:index]
+	}
+	return self.defaultSource
+}
+
+// The conversions used by the accessors (see configValueToInt). The value is not found if it cannot be converted.
+
+func configurationString(value interface{}) (string, bool) {
+	str, err := configValueToString(value)
+	return str, err == nil
+}
+
+func configurationInt(value interface{}) (int, bool) {
+	i, err := configValueToInt(value)
+	return int(i), err == nil && int64(int(i)) == i
+}
+
+func configurationBool(value interface{}) (bool, bool) {
+	b, err := configValueToBool(value)
+	return b, err == nil
+}
+
+func configurationFloat(value interface{}) (float64, bool) {
+	f, err := configValueToFloat(value)
+	return f, err == nil
+}
+
+// Returns true if the value or the environment variable override had a reference to a secret.
+func (self *mapConfigurationData) secret(key string) bool {
+	return self.secrets[key] || (len(self.envSecrets) > 0 && self.envSecrets[EnvOverrideName(key)])
+}
+
+// Returns the environment variable override (the references are resolved).
+func (self *mapConfigurationData) envOverride(name string) (string, bool) {
+	value, found := self.envOverrides[name]
+	return value, found
+}
+
+func (self *mapConfigurationData) hasEnvOverrides() bool { return len(self.envOverrides) > 0 }
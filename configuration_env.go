@@ -0,0 +1,254 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"os"
+	"fmt"
+	"sort"
+	"bytes"
+	"strings"
+	"encoding/json"
+)
+
+// Any configuration value can be overridden with an environment variable. The variable name is the
+// key path in upper case with the dots replaced by two underscores and the "DLSHARED_" prefix. Any
+// other character that is not a letter or a number is replaced by an underscore. For example:
+//
+//    mongoDb.configDb.mongoUrl    DLSHARED_MONGODB__CONFIGDB__MONGOURL
+//    server.http.port             DLSHARED_SERVER__HTTP__PORT
+//
+// The lists can be set as json arrays or comma separated values and json documents can be set as json.
+// If an override cannot be converted to the type requested (e.g., an Int that is not a number), the
+// default is returned - the same as a value in the file. Overrides are applied to the keys in documents
+// returned by Interface (e.g., a dlconfig struct), but they cannot add keys that are not in the file. The
+// overrides can have references to secrets (e.g., ${file:/run/secrets/password}). The overrides are read when
+// the configuration is loaded (or reloaded) - see loadEnvOverrides.
+const (
+	envOverridePrefix = "DLSHARED_"
+	envOverrideSeparator = "__"
+
+	envSourcePrefix = "env:"
+	fileSourcePrefix = "file:"
+	MapSource = "map"
+	DefaultSource = "default"
+)
+
+// A value read from the configuration. The source is where the value came from: "env:<variable>",
+// "file:<file name>", "map" (see NewConfigurationFromMap) or "default" if the key is not set and the
+// default passed to the accessor was returned.
+type ConfigurationValue struct {
+	Key string `json:"key"`
+	Value interface{} `json:"value"`
+	Source string `json:"source"`
+}
+
+// Returns the environment variable name for a configuration key.
+func EnvOverrideName(key string) string {
+
+	var buffer bytes.Buffer
+	buffer.WriteString(envOverridePrefix)
+
+	for i, name := range strings.Split(key, ".") {
+		if i > 0 { buffer.WriteString(envOverrideSeparator) }
+		for _, r := range strings.ToUpper(name) {
+			if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') { buffer.WriteRune(r) } else { buffer.WriteRune('_') }
+		}
+	}
+
+	return buffer.String()
+}
+
+// Returns the current values of the keys that were read from the configuration (sorted by key) and the
+// source of each value. The values include the secrets - use RedactedDump or DumpString to print the values.
+func (self *Configuration) Dump() []ConfigurationValue {
+
+	data := self.currentData()
+
+	var values []ConfigurationValue
+
+	self.keys.Range(func(key, def interface{}) bool {
+		values = append(values, self.dumpValue(data, key.(string), def))
+		return true
+	})
+
+	sort.Sort(configurationValuesByKey(values))
+
+	return values
+}
+
+// Returns the value and source of the key. If the key is not set, the default is returned.
+func (self *Configuration) dumpValue(data configurationData, key string, def interface{}) ConfigurationValue {
+
+	if value, found := envOverrideValue(data, key); found { return ConfigurationValue{ Key: key, Value: value, Source: envSourcePrefix + EnvOverrideName(key) } }
+
+	if value := data.Interface(key, nil); value != nil { return ConfigurationValue{ Key: key, Value: copyConfigurationValue(applyEnvOverrides(data, key, value)), Source: data.source(key) } }
+
+	return ConfigurationValue{ Key: key, Value: def, Source: DefaultSource }
+}
+
+// Returns the redacted dump as one line per value. This is logged with the kernel diagnostics.
+func (self *Configuration) DumpString() string {
+	var buffer bytes.Buffer
+	for _, value := range self.RedactedDump() { buffer.WriteString(fmt.Sprintf("\t%s = %v (%s)\n", value.Key, value.Value, value.Source)) }
+	return buffer.String()
+}
+
+type configurationValuesByKey []ConfigurationValue
+
+func (self configurationValuesByKey) Len() int { return len(self) }
+func (self configurationValuesByKey) Swap(i, j int) { self[i], self[j] = self[j], self[i] }
+func (self configurationValuesByKey) Less(i, j int) bool { return self[i].Key < self[j].Key }
+
+// Record a key that was read by an accessor (the keys in the documents are not recorded). Only the first
+// default is kept. The accessors are called on hot paths, so the value and source are not looked up until
+// the values are dumped (see Dump).
+func (self *Configuration) recordKey(key string, def interface{}) {
+	if _, seen := self.keys.Load(key); !seen { self.keys.LoadOrStore(key, def) }
+}
+
+// Read the environment variable overrides (the variables with the DLSHARED_ prefix) and resolve the references
+// in them. The overrides with references are secrets. All of the references that cannot be resolved are
+// returned in one error (see resolveConfigurationReferences).
+func loadEnvOverrides(data *mapConfigurationData) error {
+
+	resolver := &configurationReferenceResolver{ secrets: make(map[string]bool) }
+
+	data.envOverrides = make(map[string]string)
+
+	for _, variable := range os.Environ() {
+		if !strings.HasPrefix(variable, envOverridePrefix) { continue }
+
+		nameValue := strings.SplitN(variable, "=", 2)
+		if len(nameValue) != 2 { continue }
+
+		data.envOverrides[nameValue[0]] = resolver.resolve(nameValue[0], nameValue[1]).(string)
+	}
+
+	data.envSecrets = resolver.secrets
+
+	return NewAggregateError("Unable to resolve environment variable override references", resolver.errs)
+}
+
+// Returns the environment variable override for the key.
+func (self *Configuration) envOverride(key string) (string, bool) { return envOverrideValue(self.currentData(), key) }
+
+func envOverrideValue(data configurationData, key string) (string, bool) {
+	if !data.hasEnvOverrides() { return nadaStr, false }
+	return data.envOverride(EnvOverrideName(key))
+}
+
+// Apply the overrides to the keys in a json document. The document is copied if a key is overridden.
+func applyEnvOverrides(data configurationData, key string, value interface{}) interface{} {
+	if !data.hasEnvOverrides() { return value }
+	overridden, _ := applyDocumentEnvOverrides(data, key, value)
+	return overridden
+}
+
+// Returns the value with the overrides applied and true if a key was overridden.
+func applyDocumentEnvOverrides(data configurationData, key string, value interface{}) (interface{}, bool) {
+
+	doc, ok := value.(map[string]interface{})
+	if !ok { return value, false }
+
+	var copied map[string]interface{}
+
+	for name, item := range doc {
+		itemKey := name
+		if len(key) > 0 { itemKey = fmt.Sprintf(confPathKeyPattern, key, name) }
+
+		var overridden interface{}
+		var changed bool
+
+		if envValue, found := envOverrideValue(data, itemKey); found {
+			overridden, changed = envInterface(envValue), true
+		} else {
+			overridden, changed = applyDocumentEnvOverrides(data, itemKey, item)
+		}
+
+		if !changed { continue }
+
+		if copied == nil {
+			copied = make(map[string]interface{}, len(doc))
+			for k, v := range doc { copied[k] = v }
+		}
+
+		copied[name] = overridden
+	}
+
+	if copied == nil { return doc, false }
+
+	return copied, true
+}
+
+// The overrides are converted the same way as the file values (see configValueToInt).
+
+func envInt(value string, def int) int {
+	if i, ok := configurationInt(value); ok { return i }
+	return def
+}
+
+func envBool(value string, def bool) bool {
+	if b, ok := configurationBool(value); ok { return b }
+	return def
+}
+
+func envFloat(value string, def float64) float64 {
+	if f, ok := configurationFloat(value); ok { return f }
+	return def
+}
+
+// Returns the list from a json array or comma separated values.
+func envList(value string) []interface{} {
+
+	if list, ok := envInterface(value).([]interface{}); ok { return list }
+
+	list := make([]interface{}, 0)
+	for _, item := range strings.Split(value, commaStr) {
+		if item = strings.TrimSpace(item); len(item) > 0 { list = append(list, item) }
+	}
+
+	return list
+}
+
+func envStringList(value string) []string {
+	list := envList(value)
+	values := make([]string, 0, len(list))
+	for _, item := range list { if str, ok := configurationString(item); ok { values = append(values, str) } }
+	return values
+}
+
+// The values that are not integers are skipped (the same as the file values).
+func envIntList(value string) []int {
+	list := envList(value)
+	values := make([]int, 0, len(list))
+	for _, item := range list { if i, ok := configurationInt(item); ok { values = append(values, i) } }
+	return values
+}
+
+// Returns the decoded value if the override is a json document or array, otherwise the string is returned.
+func envInterface(value string) interface{} {
+
+	trimmed := strings.TrimSpace(value)
+
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		var decoded interface{}
+		if err := json.Unmarshal([]byte(trimmed), &decoded); err == nil { return decoded }
+	}
+
+	return value
+}
@@ -0,0 +1,103 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"os"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestConfigurationEnvOverrides(t *testing.T) {
+
+	if name := EnvOverrideName("mongoDb.configDb.mongoUrl"); name != "DLSHARED_MONGODB__CONFIGDB__MONGOURL" { t.Errorf("EnvOverrideName is broken - received: %s", name) }
+	if name := EnvOverrideName("kernel.components.my-component"); name != "DLSHARED_KERNEL__COMPONENTS__MY_COMPONENT" { t.Errorf("EnvOverrideName is broken - received: %s", name) }
+
+	overrides := map[string]string {
+		"DLSHARED_SERVER__HTTP__PORT": "8080",
+		"DLSHARED_SERVER__HTTP__ENABLED": "false",
+		"DLSHARED_SERVER__HTTP__HOSTS": "a.example.com, b.example.com",
+		"DLSHARED_SERVER__RATIO": "0.25",
+		"DLSHARED_PORTS": "[ 4, 5 ]",
+		"DLSHARED_ENVIRONMENT": "staging",
+		"DLSHARED_SERVER__HTTP__BADPORT": "abc",
+	}
+
+	for name, value := range overrides { os.Setenv(name, value); defer os.Unsetenv(name) }
+
+	configuration, err := NewConfigurationFromMap(map[string]interface{} {
+		"version": "1.0.0",
+		"environment": "test",
+		"server": map[string]interface{} {
+			"ratio": 0.5,
+			"http": map[string]interface{} { "port": 9999, "bindAddress": "127.0.0.1", "enabled": true, "badPort": 1 },
+		},
+		"ports": []int{ 1, 2, 3 },
+	})
+
+	if err != nil { t.Errorf("TestConfigurationEnvOverrides is broken: %v", err); return }
+
+	if !configuration.EnvironmentIs("staging") { t.Errorf("TestConfigurationEnvOverrides is broken - environment: %s", configuration.Environment) }
+
+	if port := configuration.IntWithPath("server.http", "port", 0); port != 8080 { t.Errorf("TestConfigurationEnvOverrides is broken - port: %d", port) }
+	if configuration.Bool("server.http.enabled", true) { t.Errorf("TestConfigurationEnvOverrides is broken - enabled") }
+	if ratio := configuration.Float("server.ratio", 0); ratio != 0.25 { t.Errorf("TestConfigurationEnvOverrides is broken - ratio: %v", ratio) }
+	if hosts := configuration.StrList("server.http.hosts", nil); len(hosts) != 2 || hosts[1] != "b.example.com" { t.Errorf("TestConfigurationEnvOverrides is broken - hosts: %v", hosts) }
+	if ports := configuration.IntList("ports", nil); len(ports) != 2 || ports[0] != 4 { t.Errorf("TestConfigurationEnvOverrides is broken - ports: %v", ports) }
+	if port := configuration.Int("server.http.badPort", 7); port != 7 { t.Errorf("TestConfigurationEnvOverrides is broken - invalid override: %d", port) }
+	if address := configuration.String("server.http.bindAddress", ""); address != "127.0.0.1" { t.Errorf("TestConfigurationEnvOverrides is broken - bindAddress: %s", address) }
+
+	// The overrides are applied to documents and the data is not modified.
+	doc := configuration.Interface("server.http", nil).(map[string]interface{})
+	if doc["port"] != "8080" || doc["bindAddress"] != "127.0.0.1" { t.Errorf("TestConfigurationEnvOverrides is broken - document: %v", doc) }
+	if configuration.data.Int("server.http.port", 0) != 9999 { t.Errorf("TestConfigurationEnvOverrides is broken - data modified") }
+
+	// The dlconfig fields.
+	component := &struct { Port int `dlconfig:"http.port"`; Enabled bool `dlconfig:"http.enabled"` }{}
+	kernel := newKernelWithConfiguration("configEnv", configuration, Logger{})
+	if err := kernel.configureFromPath("configEnv", "server", component); err != nil || component.Port != 8080 || component.Enabled { t.Errorf("TestConfigurationEnvOverrides is broken - dlconfig: %v - %+v", err, component) }
+
+	configuration.String("missing", "def")
+
+	sources := make(map[string]string)
+	for _, value := range configuration.Dump() { sources[value.Key] = value.Source }
+
+	if sources["server.http.port"] != "env:DLSHARED_SERVER__HTTP__PORT" || sources["server.http.bindAddress"] != MapSource || sources["missing"] != DefaultSource {
+		t.Errorf("TestConfigurationEnvOverrides is broken - sources: %v", sources)
+	}
+
+	if dump := configuration.DumpString(); !strings.Contains(dump, "\tserver.http.port = 8080 (env:DLSHARED_SERVER__HTTP__PORT)\n") { t.Errorf("TestConfigurationEnvOverrides is broken - dump: %s", dump) }
+
+	// The overrides are read when the configuration is loaded and the current values are dumped (the keys
+	// are only recorded the first time they are read).
+	os.Setenv(EnvOverrideName("missing"), "set")
+	defer os.Unsetenv(EnvOverrideName("missing"))
+
+	// Only the keys read by the accessors are recorded (not the keys in the documents).
+	documentConfiguration, _ := NewConfigurationFromMap(map[string]interface{} { "version": "1.0.0", "environment": "test", "server": map[string]interface{} { "ratio": 0.5 } })
+	if ratio := documentConfiguration.Document()["server"].(map[string]interface{})["ratio"]; fmt.Sprint(ratio) != "0.25" { t.Errorf("TestConfigurationEnvOverrides is broken - document ratio: %v", ratio) }
+	for _, value := range documentConfiguration.Dump() {
+		if value.Key == "server.ratio" { t.Errorf("TestConfigurationEnvOverrides is broken - document key recorded: %v", value) }
+	}
+
+	if missing := configuration.String("missing", "def"); missing != "def" { t.Errorf("TestConfigurationEnvOverrides is broken - override read before the configuration is loaded: %s", missing) }
+
+	if err := configuration.SetLayer("reload", map[string]interface{} {}); err != nil { t.Errorf("TestConfigurationEnvOverrides is broken - set layer: %v", err) }
+
+	if dump := configuration.DumpString(); !strings.Contains(dump, "\tmissing = set (env:DLSHARED_MISSING)\n") { t.Errorf("TestConfigurationEnvOverrides is broken - current dump: %s", dump) }
+}
@@ -0,0 +1,165 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"fmt"
+	"strings"
+	"path/filepath"
+)
+
+const localOverlayName = "local"
+
+// A document loaded from a source. The documents are copied when the layers are merged, so the layers
+// can be merged again when a dynamic layer changes (see Configuration.SetLayer).
+type configurationLayer struct {
+	name string
+	doc map[string]interface{}
+	dynamic bool // The references are not resolved (see SetLayer).
+}
+
+// Load the configuration files. The format of each file is selected by the file extension (see
+// RegisterConfigurationFormat).
+func loadConfigurationFiles(fileNames []string) ([]*configurationLayer, error) {
+
+	sources := make([]ConfigurationSource, len(fileNames))
+	for i, fileName := range fileNames { sources[i] = NewFileConfigurationSource(fileName) }
+
+	return loadConfigurationLayers(sources)
+}
+
+func loadConfigurationLayers(sources []ConfigurationSource) ([]*configurationLayer, error) {
+
+	layers := make([]*configurationLayer, 0, len(sources))
+
+	for _, source := range sources {
+		doc, err := source.Load()
+		if err != nil { return nil, err }
+		layers = append(layers, &configurationLayer{ name: source.Name(), doc: doc })
+	}
+
+	return layers, nil
+}
+
+// Merge the layers in order. The first layer is the base document.
+func mergeConfigurationLayers(layers []*configurationLayer) (*mapConfigurationData, error) {
+
+	data := &mapConfigurationData{ values: make(map[string]interface{}), sources: make(map[string]string) }
+
+	resolved := false
+
+	for i, layer := range layers {
+
+		// The references are resolved after the files are merged, so an overlay can replace a reference.
+		if layer.dynamic && !resolved {
+			if err := resolveConfigurationReferences(data); err != nil { return nil, err }
+			resolved = true
+		}
+
+		doc := copyConfigurationValue(layer.doc).(map[string]interface{})
+
+		if i == 0 {
+			data.values = doc
+			data.defaultSource = layer.name
+			continue
+		}
+
+		mergeConfigurationDocument(data.values, doc, nadaStr, layer.name, data.sources)
+	}
+
+	if !resolved {
+		if err := resolveConfigurationReferences(data); err != nil { return nil, err }
+	}
+
+	if err := loadEnvOverrides(data); err != nil { return nil, err }
+
+	return data, nil
+}
+
+// Merge the overlay into the document. The json documents are merged and all other values (including lists)
+// replace the value in the document. A null value removes the key. The source of each key that is set is
+// stored in the sources map (the key is the path).
+func mergeConfigurationDocument(doc, overlay map[string]interface{}, path, source string, sources map[string]string) {
+
+	for key, value := range overlay {
+
+		keyPath := key
+		if len(path) > 0 { keyPath = fmt.Sprintf(confPathKeyPattern, path, key) }
+
+		if overlayDoc, ok := value.(map[string]interface{}); ok {
+			if existingDoc, ok := doc[key].(map[string]interface{}); ok {
+				mergeConfigurationDocument(existingDoc, overlayDoc, keyPath, source, sources)
+				continue
+			}
+		}
+
+		// The sources of the replaced keys are removed.
+		for sourcePath := range sources {
+			if strings.HasPrefix(sourcePath, keyPath + ".") { delete(sources, sourcePath) }
+		}
+
+		if value == nil {
+			delete(doc, key)
+			delete(sources, keyPath)
+			continue
+		}
+
+		doc[key] = value
+		sources[keyPath] = source
+	}
+}
+
+// Returns the environment and local overlay files that exist for the configuration file.
+func environmentOverlayFileNames(fileName, environment string) ([]string, error) {
+
+	extension := filepath.Ext(fileName)
+	baseName := strings.TrimSuffix(fileName, extension)
+
+	var overlayFileNames []string
+
+	for i, name := range []string{ environment, localOverlayName } {
+
+		// The local overlay is not loaded twice if the environment is "local".
+		if len(name) == 0 || (i > 0 && name == environment) { continue }
+
+		overlayFileName := fmt.Sprintf("%s.%s%s", baseName, name, extension)
+
+		found, err := FileOrDirExists(overlayFileName)
+		if err != nil { return nil, NewStackError("Unable to check configuration overlay file: %s - error: %v", overlayFileName, err) }
+
+		if found { overlayFileNames = append(overlayFileNames, overlayFileName) }
+	}
+
+	return overlayFileNames, nil
+}
+
+// Returns a deep copy of a json value.
+func copyConfigurationValue(value interface{}) interface{} {
+	switch v := value.(type) {
+		case map[string]interface{}: {
+			doc := make(map[string]interface{}, len(v))
+			for key, item := range v { doc[key] = copyConfigurationValue(item) }
+			return doc
+		}
+		case []interface{}: {
+			list := make([]interface{}, len(v))
+			for i := range v { list[i] = copyConfigurationValue(v[i]) }
+			return list
+		}
+	}
+	return value
+}
@@ -0,0 +1,170 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"os"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+)
+
+func TestConfigurationOverlays(t *testing.T) {
+
+	dir, err := ioutil.TempDir(nadaStr, "dlshared_test_configuration")
+	if err != nil { t.Errorf("TestConfigurationOverlays is broken: %v", err); return }
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "configuration.json")
+
+	for name, content := range map[string]string {
+		"configuration.json": `{ "version": "1.0.0", "environment": "staging", "pidFile": "/tmp/test.pid",
+			"server": { "http": { "port": 9999, "bindAddress": "127.0.0.1" } },
+			"hosts": [ "a", "b", "c" ],
+			"removed": "value" }`,
+		"configuration.staging.json": `{ "server": { "http": { "port": 8080 } }, "hosts": [ "d" ], "removed": null }`,
+		"configuration.local.json": `{ "server": { "http": { "bindAddress": "0.0.0.0" } } }`,
+		"configuration.prod.json": `{ "server": { "http": { "port": 80 } } }`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil { t.Errorf("TestConfigurationOverlays is broken: %v", err); return }
+	}
+
+	configuration, err := NewEnvironmentConfiguration(fileName)
+	if err != nil { t.Errorf("TestConfigurationOverlays is broken: %v", err); return }
+
+	if len(configuration.FileNames) != 3 || configuration.FileNames[1] != filepath.Join(dir, "configuration.staging.json") { t.Errorf("TestConfigurationOverlays is broken - files: %v", configuration.FileNames) }
+
+	if port := configuration.Int("server.http.port", 0); port != 8080 { t.Errorf("TestConfigurationOverlays is broken - port: %d", port) }
+	if address := configuration.String("server.http.bindAddress", ""); address != "0.0.0.0" { t.Errorf("TestConfigurationOverlays is broken - bindAddress: %s", address) }
+	if hosts := configuration.StrList("hosts", nil); len(hosts) != 1 || hosts[0] != "d" { t.Errorf("TestConfigurationOverlays is broken - lists must be replaced: %v", hosts) }
+	if removed := configuration.String("removed", "def"); removed != "def" { t.Errorf("TestConfigurationOverlays is broken - null must remove the key: %s", removed) }
+	if configuration.Version != "1.0.0" { t.Errorf("TestConfigurationOverlays is broken - version: %s", configuration.Version) }
+
+	sources := make(map[string]string)
+	for _, value := range configuration.Dump() { sources[value.Key] = value.Source }
+
+	if sources["server.http.port"] != "file:" + filepath.Join(dir, "configuration.staging.json") ||
+		sources["server.http.bindAddress"] != "file:" + filepath.Join(dir, "configuration.local.json") ||
+		sources["version"] != "file:" + fileName {
+		t.Errorf("TestConfigurationOverlays is broken - sources: %v", sources)
+	}
+
+	// The document is a copy.
+	doc := configuration.Document()
+	doc["server"].(map[string]interface{})["http"].(map[string]interface{})["port"] = 1.0
+	if port := configuration.Document()["server"].(map[string]interface{})["http"].(map[string]interface{})["port"]; port != 8080.0 { t.Errorf("TestConfigurationOverlays is broken - document: %v", port) }
+
+	// The explicit overlays.
+	configuration, err = NewConfiguration(fileName, filepath.Join(dir, "configuration.prod.json"))
+	if err != nil || configuration.Int("server.http.port", 0) != 80 || configuration.String("server.http.bindAddress", "") != "127.0.0.1" { t.Errorf("TestConfigurationOverlays is broken - explicit overlay: %v", err) }
+
+	if _, err = NewConfiguration(fileName, filepath.Join(dir, "configuration.missing.json")); err == nil { t.Errorf("TestConfigurationOverlays is broken - missing overlay") }
+}
+
+func TestConfigurationFileValues(t *testing.T) {
+
+	dir, err := ioutil.TempDir(nadaStr, "dlshared_test_configuration")
+	if err != nil { t.Errorf("TestConfigurationFileValues is broken: %v", err); return }
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "configuration.json")
+
+	for name, content := range map[string]string {
+		"configuration.json": `{ "version": "1.0.0", "environment": "local", "pidFile": "/tmp/test.pid", "port": "9999", "count": 10, "enabled": "true", "ratio": "0.5", "hosts": [ "a", 1 ] }`,
+		"configuration.local.json": `{ "port": 8080 }`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil { t.Errorf("TestConfigurationFileValues is broken: %v", err); return }
+	}
+
+	configuration, err := NewEnvironmentConfiguration(fileName)
+	if err != nil { t.Errorf("TestConfigurationFileValues is broken: %v", err); return }
+
+	// The local overlay is only loaded once.
+	if len(configuration.FileNames) != 2 || configuration.Int("port", 0) != 8080 { t.Errorf("TestConfigurationFileValues is broken - files: %v", configuration.FileNames) }
+
+	// The file values are converted the same way as the map values.
+	if count := configuration.String("count", "def"); count != "10" { t.Errorf("TestConfigurationFileValues is broken - count: %s", count) }
+	if enabled := configuration.Bool("enabled", false); !enabled { t.Errorf("TestConfigurationFileValues is broken - enabled: %v", enabled) }
+	if ratio := configuration.Float("ratio", 1); ratio != 0.5 { t.Errorf("TestConfigurationFileValues is broken - ratio: %v", ratio) }
+	if hosts := configuration.StrList("hosts", nil); len(hosts) != 2 || hosts[0] != "a" || hosts[1] != "1" { t.Errorf("TestConfigurationFileValues is broken - hosts: %v", hosts) }
+}
+
+func TestConfigurationQuotedNumbers(t *testing.T) {
+
+	dir, err := ioutil.TempDir(nadaStr, "dlshared_test_configuration")
+	if err != nil { t.Errorf("TestConfigurationQuotedNumbers is broken: %v", err); return }
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "configuration.json")
+
+	content := `{ "version": "1.0.0", "environment": "test", "pidFile": "/tmp/test.pid", "server": { "port": "9999", "timeout": " 4000 ", "ratio": "4000.5", "size": "4000.0" } }`
+	if err := ioutil.WriteFile(fileName, []byte(content), 0644); err != nil { t.Errorf("TestConfigurationQuotedNumbers is broken: %v", err); return }
+
+	fileConfiguration, err := NewConfiguration(fileName)
+	if err != nil { t.Errorf("TestConfigurationQuotedNumbers is broken: %v", err); return }
+
+	mapConfiguration, err := NewConfigurationFromMap(map[string]interface{} {
+		"version": "1.0.0",
+		"environment": "test",
+		"server": map[string]interface{} { "port": "9999", "timeout": " 4000 ", "ratio": "4000.5", "size": "4000.0" },
+	})
+	if err != nil { t.Errorf("TestConfigurationQuotedNumbers is broken: %v", err); return }
+
+	for name, configuration := range map[string]*Configuration { "file": fileConfiguration, "map": mapConfiguration } {
+
+		if port := configuration.Int("server.port", 0); port != 9999 { t.Errorf("TestConfigurationQuotedNumbers is broken - %s port: %d", name, port) }
+		if timeout := configuration.Int("server.timeout", 0); timeout != 4000 { t.Errorf("TestConfigurationQuotedNumbers is broken - %s timeout: %d", name, timeout) }
+		if ratio := configuration.Float("server.ratio", 0); ratio != 4000.5 { t.Errorf("TestConfigurationQuotedNumbers is broken - %s ratio: %v", name, ratio) }
+
+		// An int must be a whole number - the default is returned.
+		if size := configuration.Int("server.size", 1); size != 1 { t.Errorf("TestConfigurationQuotedNumbers is broken - %s size: %d", name, size) }
+		if ratio := configuration.Int("server.ratio", 1); ratio != 1 { t.Errorf("TestConfigurationQuotedNumbers is broken - %s int ratio: %d", name, ratio) }
+
+		var server struct {
+			Port int `dlconfig:"port"`
+			Timeout int `dlconfig:"timeout"`
+			Ratio float64 `dlconfig:"ratio"`
+		}
+
+		if err := configuration.Unmarshal("server", &server); err != nil || server.Port != 9999 || server.Timeout != 4000 || server.Ratio != 4000.5 { t.Errorf("TestConfigurationQuotedNumbers is broken - %s unmarshal: %v - %+v", name, err, server) }
+
+		var size struct { Size int `dlconfig:"size"` }
+		if err := configuration.Unmarshal("server", &size); err == nil { t.Errorf("TestConfigurationQuotedNumbers is broken - %s unmarshal size: %+v", name, size) }
+	}
+}
+
+func TestEnvironmentConfigurationOverlays(t *testing.T) {
+
+	dir, err := ioutil.TempDir(nadaStr, "dlshared_test_configuration")
+	if err != nil { t.Errorf("TestEnvironmentConfigurationOverlays is broken: %v", err); return }
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "configuration.json")
+
+	// The pid file is only set in the overlay and the overlay replaces the reference.
+	for name, content := range map[string]string {
+		"configuration.json": `{ "version": "1.0.0", "environment": "prod", "password": "${env:DLSHARED_TEST_UNSET}" }`,
+		"configuration.prod.json": `{ "pidFile": "/tmp/test.pid", "password": "secret" }`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil { t.Errorf("TestEnvironmentConfigurationOverlays is broken: %v", err); return }
+	}
+
+	configuration, err := NewEnvironmentConfiguration(fileName)
+	if err != nil { t.Errorf("TestEnvironmentConfigurationOverlays is broken: %v", err); return }
+
+	if configuration.PidFile != "/tmp/test.pid" || configuration.String("password", "") != "secret" || len(configuration.FileNames) != 2 { t.Errorf("TestEnvironmentConfigurationOverlays is broken - files: %v", configuration.FileNames) }
+}
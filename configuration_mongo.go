@@ -0,0 +1,317 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"context"
+	"reflect"
+	"strings"
+	"labix.org/v2/mgo/bson"
+)
+
+const mongoSourcePrefix = "mongo:"
+
+// The settings stored in a mongo collection are merged over the configuration files (see
+// Configuration.SetLayer). Each document in the collection is one key:
+//
+//    { "_id": "server.http.port", "value": 8080, "updatedBy": "ops", "updated": ISODate(...) }
+//
+// The service polls the collection and the configuration listeners are notified when a setting changes,
+// so a setting can be changed on all of the servers in a cluster without a deploy. Use Set and Unset to
+// change a setting - each change is written to the audit collection with the user that made it. The
+// configuration is:
+//
+//    "configurationSettings": {
+//        "mongoComponentId": "MongoConfigDb",
+//        "dbName": "config",
+//        "collectionName": "settings",
+//        "auditCollectionName": "settings.audit",
+//        "auditTimeoutInSec": 31536000,
+//        "pollIntervalInMs": 5000
+//    }
+//
+// The settings are loaded when the service starts (the service depends on the mongo component). The
+// dlconfig fields are set and the configuration schemas are validated before any component is started,
+// so they only see the configuration files - read a setting with the accessors (or add a change listener)
+// to see the stored value. A setting that cannot be converted to a json value (e.g., an ObjectId) is logged
+// and skipped. The references (e.g., "${env:HOME}") in the settings are not resolved. The version,
+// environment and pid file are not changed by a setting.
+type MongoConfigurationSvc struct {
+	Logger
+	configPath string
+	kernel *Kernel
+	settingsDs *MongoDataSource
+	auditDs *MongoDataSource
+	worker *Worker
+	pollLock sync.Mutex
+	lock sync.Mutex
+	settings map[string]interface{} // The settings that were loaded (the key is the path).
+}
+
+type mongoConfigurationSetting struct {
+	Key string `bson:"_id"`
+	Value interface{} `bson:"value"`
+	UpdatedBy string `bson:"updatedBy"`
+	Updated *time.Time `bson:"updated"`
+}
+
+func NewMongoConfigurationSvc(configPath string) *MongoConfigurationSvc { return &MongoConfigurationSvc{ configPath: configPath } }
+
+// The schema for the configuration path. This implements the ConfigurationSchemaProvider interface.
+func (self *MongoConfigurationSvc) ConfigurationSchema() *ConfigurationSchema {
+
+	schema := NewConfigurationSchema(self.configPath)
+	schema.Key("mongoComponentId", StringConfigurationType).Require()
+	schema.Key("dbName", StringConfigurationType).Require()
+	schema.Key("collectionName", StringConfigurationType).Require()
+	schema.Key("auditCollectionName", StringConfigurationType).Require()
+	schema.Key("auditTimeoutInSec", IntConfigurationType).AtLeast(0)
+	schema.Key("pollIntervalInMs", IntConfigurationType).AtLeast(1)
+
+	return schema
+}
+
+// The mongo component must be started first. This implements the DependentComponent interface.
+func (self *MongoConfigurationSvc) DependsOn(kernel *Kernel) []string {
+	mongoComponentId := kernel.Configuration.StringWithPath(self.configPath, "mongoComponentId", nadaStr)
+	if len(mongoComponentId) == 0 { return nil }
+	return []string{ mongoComponentId }
+}
+
+func (self *MongoConfigurationSvc) Start(kernel *Kernel) error {
+
+	self.kernel = kernel
+	self.Logger = kernel.Logger
+
+	if err := kernel.Configuration.Validate(self.ConfigurationSchema()); err != nil { return err }
+
+	mongo, err := mongoComponent(kernel, kernel.Configuration.StringWithPath(self.configPath, "mongoComponentId", nadaStr))
+	if err != nil { return err }
+
+	dbName := kernel.Configuration.StringWithPath(self.configPath, "dbName", nadaStr)
+
+	self.settingsDs = &MongoDataSource{ DbName: dbName, CollectionName: kernel.Configuration.StringWithPath(self.configPath, "collectionName", nadaStr), Mongo: mongo }
+	self.auditDs = &MongoDataSource{ DbName: dbName, CollectionName: kernel.Configuration.StringWithPath(self.configPath, "auditCollectionName", nadaStr), Mongo: mongo }
+
+	if auditTimeoutInSec := kernel.Configuration.IntWithPath(self.configPath, "auditTimeoutInSec", 31536000); auditTimeoutInSec > 0 {
+		if err := self.auditDs.EnsureTtlIndex("time", auditTimeoutInSec); err != nil { return err }
+	}
+
+	if err := self.auditDs.EnsureIndex([]string{ "key", "time" }); err != nil { return err }
+
+	if err := self.poll(); err != nil { return err }
+
+	pollInterval := time.Duration(kernel.Configuration.IntWithPath(self.configPath, "pollIntervalInMs", 5000)) * time.Millisecond
+
+	self.worker = kernel.StartWorker("MongoConfigurationSvc.pollSettings", func(ctx context.Context) error { return self.pollSettings(ctx, pollInterval) })
+
+	return nil
+}
+
+func (self *MongoConfigurationSvc) Stop(kernel *Kernel) error {
+	if self.worker != nil { self.worker.Stop() }
+	return nil
+}
+
+// Store a setting and write the change to the audit collection. The setting is applied on this server
+// before the method returns. The other servers apply it when they poll.
+func (self *MongoConfigurationSvc) Set(key string, value interface{}, user string) error {
+
+	if len(key) == 0 || len(user) == 0 { return NewStackError("Unable to set configuration setting - the key and user must be set - key: %s", key) }
+
+	oldValue, err := self.storedValue(key)
+	if err != nil { return err }
+
+	if err := self.settingsDs.UpsertSafe(&bson.M{ "_id": key }, &bson.M{ "$set": &bson.M{ "value": value, "updatedBy": user, "updated": self.settingsDs.Now() } }); err != nil {
+		return NewStackError("Unable to set configuration setting: %s - error: %v", key, err)
+	}
+
+	return self.changed(key, oldValue, value, user)
+}
+
+// Remove a setting (the value in the configuration files is used) and write the change to the audit collection.
+func (self *MongoConfigurationSvc) Unset(key string, user string) error {
+
+	if len(key) == 0 || len(user) == 0 { return NewStackError("Unable to unset configuration setting - the key and user must be set - key: %s", key) }
+
+	oldValue, err := self.storedValue(key)
+	if err != nil { return err }
+
+	if err := self.settingsDs.DeleteById(key); err != nil { return NewStackError("Unable to unset configuration setting: %s - error: %v", key, err) }
+
+	return self.changed(key, oldValue, nil, user)
+}
+
+// Returns a copy of the settings that were loaded (the key is the path).
+func (self *MongoConfigurationSvc) Settings() map[string]interface{} {
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	settings := make(map[string]interface{}, len(self.settings))
+	for key, value := range self.settings { settings[key] = copyConfigurationValue(value) }
+
+	return settings
+}
+
+func (self *MongoConfigurationSvc) changed(key string, oldValue, newValue interface{}, user string) error {
+
+	if err := self.auditDs.InsertSafe(&bson.M{
+		"_id": self.auditDs.NewObjectId(),
+		"key": key,
+		"oldValue": oldValue,
+		"newValue": newValue,
+		"user": user,
+		"hostname": self.kernel.Configuration.Hostname,
+		"time": self.auditDs.Now(),
+	}); err != nil {
+		return NewStackError("Unable to insert configuration setting audit - key: %s - error: %v", key, err)
+	}
+
+	self.Logf(Info, "Configuration setting changed - key: %s - user: %s", key, user)
+
+	return self.poll()
+}
+
+func (self *MongoConfigurationSvc) storedValue(key string) (interface{}, error) {
+
+	setting := &mongoConfigurationSetting{}
+
+	if err := self.settingsDs.FindById(key, setting); err != nil {
+		if self.settingsDs.NotFoundErr(err) { return nil, nil }
+		return nil, NewStackError("Unable to load configuration setting: %s - error: %v", key, err)
+	}
+
+	return setting.Value, nil
+}
+
+// Run by the kernel as a worker (restarted on a panic).
+func (self *MongoConfigurationSvc) pollSettings(ctx context.Context, pollInterval time.Duration) error {
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+			case <- ticker.C: if err := self.poll(); err != nil { self.Logf(Error, "Unable to load configuration settings - err: %v", err) }
+			case <- ctx.Done(): return nil
+		}
+	}
+}
+
+// Load the settings and replace the configuration layer if they changed.
+func (self *MongoConfigurationSvc) poll() error {
+
+	var stored []*mongoConfigurationSetting
+	if err := self.settingsDs.Collection().Find(nil).All(&stored); err != nil { return NewStackError("Unable to load configuration settings - error: %v", err) }
+
+	settings, errs := mongoConfigurationSettings(stored)
+	for _, err := range errs { self.Logf(Warn, "Skipping configuration setting - err: %v", err) }
+
+	self.pollLock.Lock()
+	defer self.pollLock.Unlock()
+
+	self.lock.Lock()
+	current := self.settings
+	self.lock.Unlock()
+
+	if current != nil && reflect.DeepEqual(settings, current) { return nil }
+
+	// If a listener fails, the layer is set again on the next poll (the listeners are only called once,
+	// because the configuration has not changed).
+	if err := self.kernel.Configuration.SetLayer(self.layerName(), mongoConfigurationDocument(settings)); err != nil { return err }
+
+	self.lock.Lock()
+	self.settings = settings
+	self.lock.Unlock()
+
+	return nil
+}
+
+func (self *MongoConfigurationSvc) layerName() string {
+	return fmt.Sprintf("%s%s.%s", mongoSourcePrefix, self.settingsDs.DbName, self.settingsDs.CollectionName)
+}
+
+// Returns the settings that can be converted to json values (the key is the path) and an error for each
+// setting that is skipped.
+func mongoConfigurationSettings(stored []*mongoConfigurationSetting) (map[string]interface{}, []error) {
+
+	settings := make(map[string]interface{}, len(stored))
+
+	var errs []error
+
+	for _, setting := range stored {
+
+		if len(setting.Key) == 0 || strings.HasPrefix(setting.Key, ".") || strings.HasSuffix(setting.Key, ".") || strings.Contains(setting.Key, "..") {
+			errs = append(errs, NewStackError("Invalid configuration setting key: %q", setting.Key))
+			continue
+		}
+
+		value, err := normalizeConfigurationValue(mongoConfigurationValue(setting.Value))
+		if err != nil { errs = append(errs, NewStackError("Invalid configuration setting: %s - err: %v", setting.Key, err)); continue }
+
+		settings[setting.Key] = value
+	}
+
+	return settings, errs
+}
+
+// Returns the document for the settings. The keys are paths (e.g., "server.http.port"). If a key is a
+// path in another setting (e.g., "server.http" and "server.http.port"), the longer path is set last.
+func mongoConfigurationDocument(settings map[string]interface{}) map[string]interface{} {
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings { keys = append(keys, key) }
+	sort.Strings(keys)
+
+	doc := make(map[string]interface{})
+
+	for _, key := range keys {
+		names := strings.Split(key, ".")
+		current := doc
+		for _, name := range names[:len(names) - 1] {
+			next, ok := current[name].(map[string]interface{})
+			if !ok { next = make(map[string]interface{}); current[name] = next }
+			current = next
+		}
+		current[names[len(names) - 1]] = copyConfigurationValue(settings[key])
+	}
+
+	return doc
+}
+
+// Convert the bson documents to json documents.
+func mongoConfigurationValue(value interface{}) interface{} {
+	switch v := value.(type) {
+		case bson.M: return mongoConfigurationValue(map[string]interface{}(v))
+		case map[string]interface{}: {
+			doc := make(map[string]interface{}, len(v))
+			for key, item := range v { doc[key] = mongoConfigurationValue(item) }
+			return doc
+		}
+		case []interface{}: {
+			list := make([]interface{}, len(v))
+			for i := range v { list[i] = mongoConfigurationValue(v[i]) }
+			return list
+		}
+	}
+	return value
+}
@@ -0,0 +1,83 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"reflect"
+	"testing"
+	"labix.org/v2/mgo/bson"
+)
+
+func TestMongoConfigurationDocument(t *testing.T) {
+
+	settings := map[string]interface{} {
+		"server.http.port": 8080,
+		"server.http": bson.M{ "bindAddress": "0.0.0.0", "port": 9999 },
+		"hosts": []interface{} { "a", bson.M{ "name": "b" } },
+	}
+
+	for key, value := range settings { settings[key] = mongoConfigurationValue(value) }
+
+	expected := map[string]interface{} {
+		"server": map[string]interface{} { "http": map[string]interface{} { "bindAddress": "0.0.0.0", "port": 8080 } },
+		"hosts": []interface{} { "a", map[string]interface{} { "name": "b" } },
+	}
+
+	// The longer path is set last.
+	if doc := mongoConfigurationDocument(settings); !reflect.DeepEqual(doc, expected) { t.Errorf("TestMongoConfigurationDocument is broken - document: %v", doc) }
+
+	// The settings that cannot be converted are skipped.
+	stored := []*mongoConfigurationSetting {
+		{ Key: "server.http.port", Value: 8080 },
+		{ Key: "server.id", Value: bson.NewObjectId() },
+		{ Key: "server.key", Value: []byte("key") },
+		{ Key: "server..name", Value: "name" },
+		{ Key: "server.home", Value: "${env:HOME}" },
+	}
+
+	loaded, errs := mongoConfigurationSettings(stored)
+
+	if !reflect.DeepEqual(loaded, map[string]interface{} { "server.http.port": 8080.0, "server.home": "${env:HOME}" }) || len(errs) != 3 { t.Errorf("TestMongoConfigurationDocument is broken - settings: %v - errs: %v", loaded, errs) }
+}
+
+// Test the mongo configuration settings.
+func TestMongoConfigurationSvc(t *testing.T) {
+
+	svc := NewMongoConfigurationSvc("configurationSettings")
+
+	kernel, err := baseTestStartKernel("mongoConfigurationTest", func(kernel *Kernel) { kernel.AddComponent("MongoConfigurationSvc", svc) })
+
+	if err != nil { t.Errorf("TestMongoConfigurationSvc start kernel is broken: %v", err); return }
+
+	defer kernel.Stop()
+
+	var changes []*ConfigurationChange
+	kernel.Configuration.AddChangeListener("gcm.postUrl", func(change *ConfigurationChange) error { changes = append(changes, change); return nil })
+
+	if err := svc.Set("gcm.postUrl", "http://localhost/gcm", "test"); err != nil { t.Errorf("TestMongoConfigurationSvc set is broken: %v", err); return }
+
+	if postUrl := kernel.Configuration.String("gcm.postUrl", ""); postUrl != "http://localhost/gcm" { t.Errorf("TestMongoConfigurationSvc is broken - postUrl: %s", postUrl) }
+	if len(changes) != 1 { t.Errorf("TestMongoConfigurationSvc is broken - changes: %+v", changes) }
+	if settings := svc.Settings(); settings["gcm.postUrl"] != "http://localhost/gcm" { t.Errorf("TestMongoConfigurationSvc is broken - settings: %v", settings) }
+
+	if count, err := svc.auditDs.Count(&bson.M{ "key": "gcm.postUrl", "user": "test" }); err != nil || count != 1 { t.Errorf("TestMongoConfigurationSvc audit is broken - count: %d - err: %v", count, err) }
+
+	if err := svc.Unset("gcm.postUrl", "test"); err != nil { t.Errorf("TestMongoConfigurationSvc unset is broken: %v", err); return }
+
+	if postUrl := kernel.Configuration.String("gcm.postUrl", ""); postUrl == "http://localhost/gcm" { t.Errorf("TestMongoConfigurationSvc is broken - unset postUrl: %s", postUrl) }
+	if len(changes) != 2 { t.Errorf("TestMongoConfigurationSvc is broken - changes: %+v", changes) }
+}
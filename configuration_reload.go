@@ -0,0 +1,224 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"reflect"
+	"strings"
+)
+
+// A change to the value at a path. The values are the same as the values returned by Interface (with the
+// environment variable overrides applied). The old or new value is nil if the path was not set.
+type ConfigurationChange struct {
+	Path string
+	OldValue interface{}
+	NewValue interface{}
+}
+
+// The listener is called synchronously after the new configuration is in place, so the accessors return
+// the new values. The listeners should not block. The errors are returned by Reload.
+type ConfigurationChangeListener func(change *ConfigurationChange) error
+
+type configurationListener struct {
+	path string
+	listener ConfigurationChangeListener
+}
+
+// The modification time and size of a configuration file when it was loaded.
+type configurationFileStamp struct {
+	fileName string
+	modTime time.Time
+	size int64
+}
+
+// Register a listener that is called when the value at the path changes (including the values in a
+// document). If the path is empty, the listener is called when anything changes. The listeners are
+// called in the order they are added. The function returned removes the listener (e.g., when the
+// component is stopped) and can be called more than once. This method will panic if the listener is nil.
+func (self *Configuration) AddChangeListener(path string, listener ConfigurationChangeListener) func() {
+	if listener == nil { panic(fmt.Sprintf("Configuration.AddChangeListener called with a nil listener for path: %s", path)) }
+	self.listenerLock.Lock()
+	defer self.listenerLock.Unlock()
+
+	added := &configurationListener{ path: path, listener: listener }
+	self.listeners = append(self.listeners, added)
+
+	return func() { self.removeChangeListener(added) }
+}
+
+// The listeners are copied, because replaceData calls them without holding the lock.
+func (self *Configuration) removeChangeListener(removed *configurationListener) {
+	self.listenerLock.Lock()
+	defer self.listenerLock.Unlock()
+
+	listeners := make([]*configurationListener, 0, len(self.listeners))
+	for _, listener := range self.listeners { if listener != removed { listeners = append(listeners, listener) } }
+	self.listeners = listeners
+}
+
+// Load the configuration files again and notify the listeners of the changes. If a file cannot be loaded,
+// the current configuration is kept. The version, environment and pid file are not changed. The listener
+// errors (and panics) are returned in one error - the other listeners are still called.
+func (self *Configuration) Reload() error {
+
+	if len(self.FileNames) == 0 { return NewStackError("Unable to reload configuration - not loaded from a file") }
+
+	self.reloadLock.Lock()
+	defer self.reloadLock.Unlock()
+
+	stamps, err := configurationFileStamps(self.FileNames)
+	if err != nil { return err }
+
+	// The stamps are updated even if the files cannot be loaded, so an invalid file is not loaded again
+	// until it is changed.
+	self.fileStamps = stamps
+
+	layers, err := loadConfigurationFiles(self.FileNames)
+	if err != nil { return err }
+
+	data, err := mergeConfigurationLayers(append(append([]*configurationLayer{}, layers...), self.dynamicLayers...))
+	if err != nil { return err }
+
+	self.layers = layers
+
+	return self.replaceData(data)
+}
+
+// Merge a dynamic layer over the files (e.g., the settings stored in mongo - see MongoConfigurationSvc).
+// The name is the source of the values in the dump. The layers are merged in the order they are first
+// set and they are kept when the files are reloaded. A nil document removes the layer. The references
+// (e.g., "${env:HOME}") in a layer are not resolved, because the layer may be written by users that
+// cannot read the local files or environment. The listeners are notified of the changes (see Reload).
+func (self *Configuration) SetLayer(name string, doc map[string]interface{}) error {
+
+	self.reloadLock.Lock()
+	defer self.reloadLock.Unlock()
+
+	var layer *configurationLayer
+
+	if doc != nil {
+		normalized, err := normalizeConfigurationDocument(doc)
+		if err != nil { return err }
+		layer = &configurationLayer{ name: name, doc: normalized, dynamic: true }
+	}
+
+	dynamicLayers := make([]*configurationLayer, 0, len(self.dynamicLayers) + 1)
+
+	found := false
+	for _, current := range self.dynamicLayers {
+		if current.name != name { dynamicLayers = append(dynamicLayers, current); continue }
+		found = true
+		if layer != nil { dynamicLayers = append(dynamicLayers, layer) }
+	}
+
+	if !found && layer != nil { dynamicLayers = append(dynamicLayers, layer) }
+
+	layers := append(append(make([]*configurationLayer, 0, len(self.layers) + len(dynamicLayers)), self.layers...), dynamicLayers...)
+
+	data, err := mergeConfigurationLayers(layers)
+	if err != nil { return err }
+
+	self.dynamicLayers = dynamicLayers
+
+	return self.replaceData(data)
+}
+
+// Returns true if one of the configuration files was modified (or removed) since it was loaded.
+func (self *Configuration) FilesModified() bool {
+
+	self.reloadLock.Lock()
+	loaded := self.fileStamps
+	self.reloadLock.Unlock()
+
+	if len(loaded) == 0 { return false }
+
+	current, err := configurationFileStamps(self.FileNames)
+	if err != nil { return true }
+
+	return !reflect.DeepEqual(loaded, current)
+}
+
+// Swap in the new data and call the listeners. The caller must hold the reload lock.
+func (self *Configuration) replaceData(data configurationData) error {
+
+	oldDoc := self.Document()
+
+	self.dataLock.Lock()
+	self.data = data
+	self.dataLock.Unlock()
+
+	newDoc := self.Document()
+
+	self.listenerLock.Lock()
+	listeners := self.listeners
+	self.listenerLock.Unlock()
+
+	var errs []error
+
+	for _, listener := range listeners {
+		oldValue, newValue := configurationDocumentValue(oldDoc, listener.path), configurationDocumentValue(newDoc, listener.path)
+		if reflect.DeepEqual(oldValue, newValue) { continue }
+		if err := callConfigurationListener(listener, &ConfigurationChange{ Path: listener.path, OldValue: oldValue, NewValue: newValue }); err != nil { errs = append(errs, err) }
+	}
+
+	return NewAggregateError("Configuration listeners failed", errs)
+}
+
+func callConfigurationListener(listener *configurationListener, change *ConfigurationChange) (err error) {
+	defer func() {
+		if r := recover(); r != nil { err = NewStackError("Configuration listener panicked - path: %s - problem: %v", listener.path, r) }
+	}()
+	return listener.listener(change)
+}
+
+func (self *Configuration) currentData() configurationData {
+	self.dataLock.RLock()
+	defer self.dataLock.RUnlock()
+	return self.data
+}
+
+// Returns the value at the path in the document or nil if it is not set.
+func configurationDocumentValue(doc map[string]interface{}, path string) interface{} {
+
+	if len(path) == 0 { return doc }
+
+	var value interface{} = doc
+
+	for _, name := range strings.Split(path, ".") {
+		current, ok := value.(map[string]interface{})
+		if !ok { return nil }
+		if value, ok = current[name]; !ok { return nil }
+	}
+
+	return value
+}
+
+func configurationFileStamps(fileNames []string) ([]configurationFileStamp, error) {
+
+	stamps := make([]configurationFileStamp, 0, len(fileNames))
+
+	for _, fileName := range fileNames {
+		info, err := os.Stat(fileName)
+		if err != nil { return nil, NewStackError("Unable to stat configuration file: %s - error: %v", fileName, err) }
+		stamps = append(stamps, configurationFileStamp{ fileName: fileName, modTime: info.ModTime(), size: info.Size() })
+	}
+
+	return stamps, nil
+}
@@ -0,0 +1,184 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"os"
+	"time"
+	"strings"
+	"testing"
+	"sync/atomic"
+	"io/ioutil"
+	"path/filepath"
+)
+
+func writeTestConfigurationFile(t *testing.T, fileName, content string, modTime time.Time) {
+	if err := ioutil.WriteFile(fileName, []byte(content), 0644); err != nil { t.Fatalf("Unable to write configuration file: %v", err) }
+	if err := os.Chtimes(fileName, modTime, modTime); err != nil { t.Fatalf("Unable to set configuration file time: %v", err) }
+}
+
+func TestConfigurationReload(t *testing.T) {
+
+	dir, err := ioutil.TempDir(nadaStr, "dlshared_test_configuration")
+	if err != nil { t.Errorf("TestConfigurationReload is broken: %v", err); return }
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "configuration.json")
+	modTime := time.Now().Add(-time.Hour)
+
+	writeTestConfigurationFile(t, fileName, `{ "version": "1.0.0", "environment": "test", "pidFile": "/tmp/test.pid", "server": { "http": { "port": 9999, "staticFileDir": "a" } } }`, modTime)
+
+	configuration, err := NewConfiguration(fileName)
+	if err != nil { t.Errorf("TestConfigurationReload is broken: %v", err); return }
+
+	var changes []*ConfigurationChange
+	configuration.AddChangeListener("server.http.port", func(change *ConfigurationChange) error { changes = append(changes, change); return nil })
+	configuration.AddChangeListener("server", func(change *ConfigurationChange) error { changes = append(changes, change); return nil })
+	configuration.AddChangeListener("missing", func(change *ConfigurationChange) error { changes = append(changes, change); return nil })
+
+	if configuration.FilesModified() { t.Errorf("TestConfigurationReload is broken - files not modified") }
+
+	writeTestConfigurationFile(t, fileName, `{ "version": "2.0.0", "environment": "test", "pidFile": "/tmp/test.pid", "server": { "http": { "port": 8080, "staticFileDir": "a" } } }`, modTime.Add(time.Minute))
+
+	if !configuration.FilesModified() { t.Errorf("TestConfigurationReload is broken - files modified") }
+
+	if err := configuration.Reload(); err != nil { t.Errorf("TestConfigurationReload is broken: %v", err); return }
+
+	if configuration.FilesModified() { t.Errorf("TestConfigurationReload is broken - files reloaded") }
+
+	if port := configuration.Int("server.http.port", 0); port != 8080 { t.Errorf("TestConfigurationReload is broken - port: %d", port) }
+	if configuration.Version != "1.0.0" { t.Errorf("TestConfigurationReload is broken - the version must not change: %s", configuration.Version) }
+
+	if len(changes) != 2 || changes[0].Path != "server.http.port" || changes[0].OldValue != 9999.0 || changes[0].NewValue != 8080.0 || changes[1].Path != "server" {
+		t.Errorf("TestConfigurationReload is broken - changes: %+v", changes)
+	}
+
+	// An invalid file keeps the current configuration and the listener errors are returned.
+	changes = nil
+	writeTestConfigurationFile(t, fileName, `{ "server": `, modTime.Add(2 * time.Minute))
+
+	if err := configuration.Reload(); err == nil || configuration.Int("server.http.port", 0) != 8080 || len(changes) != 0 { t.Errorf("TestConfigurationReload is broken - invalid file: %v", err) }
+
+	configuration.AddChangeListener("server.http.staticFileDir", func(change *ConfigurationChange) error { panic("boom") })
+
+	writeTestConfigurationFile(t, fileName, `{ "server": { "http": { "port": 8080, "staticFileDir": "b" } } }`, modTime.Add(3 * time.Minute))
+
+	if err := configuration.Reload(); err == nil || !strings.Contains(err.Error(), "boom") || len(changes) != 1 { t.Errorf("TestConfigurationReload is broken - listener panic: %v - %d", err, len(changes)) }
+
+	// Configurations that are not loaded from a file cannot be reloaded.
+	mapConfiguration, _ := NewConfigurationFromMap(map[string]interface{} { "version": "1.0.0", "environment": "test" })
+	if err := mapConfiguration.Reload(); err == nil { t.Errorf("TestConfigurationReload is broken - map configuration reloaded") }
+}
+
+func TestConfigurationLayers(t *testing.T) {
+
+	dir, err := ioutil.TempDir(nadaStr, "dlshared_test_configuration")
+	if err != nil { t.Errorf("TestConfigurationLayers is broken: %v", err); return }
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "configuration.json")
+	modTime := time.Now().Add(-time.Hour)
+
+	writeTestConfigurationFile(t, fileName, `{ "version": "1.0.0", "environment": "test", "pidFile": "/tmp/test.pid", "server": { "http": { "port": 9999, "bindAddress": "127.0.0.1" } } }`, modTime)
+
+	configuration, err := NewConfiguration(fileName)
+	if err != nil { t.Errorf("TestConfigurationLayers is broken: %v", err); return }
+
+	var changes []*ConfigurationChange
+	removeListener := configuration.AddChangeListener("server.http.port", func(change *ConfigurationChange) error { changes = append(changes, change); return nil })
+
+	if err := configuration.SetLayer("mongo:test.settings", map[string]interface{} { "server": map[string]interface{} { "http": map[string]interface{} { "port": 8080 } } }); err != nil { t.Errorf("TestConfigurationLayers is broken: %v", err); return }
+
+	if port := configuration.Int("server.http.port", 0); port != 8080 || configuration.String("server.http.bindAddress", "") != "127.0.0.1" { t.Errorf("TestConfigurationLayers is broken - port: %d", port) }
+	if len(changes) != 1 || changes[0].NewValue != 8080.0 { t.Errorf("TestConfigurationLayers is broken - changes: %+v", changes) }
+
+	sources := make(map[string]string)
+	for _, value := range configuration.Dump() { sources[value.Key] = value.Source }
+	if sources["server.http.port"] != "mongo:test.settings" || sources["server.http.bindAddress"] != "file:" + fileName { t.Errorf("TestConfigurationLayers is broken - sources: %v", sources) }
+
+	// The layers are merged in the order they are first set.
+	configuration.SetLayer("other", map[string]interface{} { "server": map[string]interface{} { "http": map[string]interface{} { "port": 7070 } } })
+	configuration.SetLayer("mongo:test.settings", map[string]interface{} { "server": map[string]interface{} { "http": map[string]interface{} { "port": 6060 } } })
+	if port := configuration.Int("server.http.port", 0); port != 7070 { t.Errorf("TestConfigurationLayers is broken - layer order: %d", port) }
+
+	configuration.SetLayer("other", nil)
+	if port := configuration.Int("server.http.port", 0); port != 6060 { t.Errorf("TestConfigurationLayers is broken - layer removed: %d", port) }
+
+	// The references in a layer are not resolved.
+	if err := configuration.SetLayer("other", map[string]interface{} { "home": "${env:HOME}", "missing": "${env:DLSHARED_TEST_MISSING}" }); err != nil { t.Errorf("TestConfigurationLayers is broken - references: %v", err) }
+	if home := configuration.String("home", ""); home != "${env:HOME}" { t.Errorf("TestConfigurationLayers is broken - reference resolved: %s", home) }
+
+	configuration.SetLayer("other", nil)
+
+	// The layers are kept when the files are reloaded.
+	writeTestConfigurationFile(t, fileName, `{ "server": { "http": { "port": 9999, "bindAddress": "0.0.0.0" } } }`, modTime.Add(time.Minute))
+
+	if err := configuration.Reload(); err != nil || configuration.Int("server.http.port", 0) != 6060 || configuration.String("server.http.bindAddress", "") != "0.0.0.0" { t.Errorf("TestConfigurationLayers is broken - reload: %v", err) }
+
+	// A removed listener is not called.
+	count := len(changes)
+	removeListener()
+	removeListener()
+
+	configuration.SetLayer("mongo:test.settings", nil)
+
+	if configuration.Int("server.http.port", 0) != 9999 || len(changes) != count || len(configuration.listeners) != 0 { t.Errorf("TestConfigurationLayers is broken - removed listener: %+v", changes) }
+}
+
+func TestKernelConfigurationWatcher(t *testing.T) {
+
+	dir, err := ioutil.TempDir(nadaStr, "dlshared_test_configuration")
+	if err != nil { t.Errorf("TestKernelConfigurationWatcher is broken: %v", err); return }
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "configuration.json")
+	modTime := time.Now().Add(-time.Hour)
+
+	writeTestConfigurationFile(t, fileName, `{ "version": "1.0.0", "environment": "test", "pidFile": "/tmp/test.pid", "logger": { "level": "info" },
+		"kernel": { "configurationWatchIntervalInMs": 10 } }`, modTime)
+
+	configuration, err := NewConfiguration(fileName)
+	if err != nil { t.Errorf("TestKernelConfigurationWatcher is broken: %v", err); return }
+
+	appenders, err := configureLogger("kernelConfigurationWatcher", configuration)
+	if err != nil { t.Errorf("TestKernelConfigurationWatcher is broken: %v", err); return }
+
+	filter := appenders[0].(*LevelFilterAppender)
+	if filter.Level() != Info { t.Errorf("TestKernelConfigurationWatcher is broken - level: %v", filter.Level()) }
+
+	kernel := newKernelWithConfiguration("kernelConfigurationWatcher", configuration, Logger{ Prefix: "kernelConfigurationWatcher", Appenders: []Appender{ &memoryAppender{} } })
+
+	reloadable := &testConfigurationReloadable{}
+	kernel.AddComponent("reloadable", reloadable)
+
+	if err := kernel.Start(); err != nil { t.Errorf("TestKernelConfigurationWatcher is broken: %v", err); return }
+	defer kernel.Stop()
+
+	writeTestConfigurationFile(t, fileName, `{ "logger": { "level": "error" }, "kernel": { "configurationWatchIntervalInMs": 10 } }`, modTime.Add(time.Minute))
+
+	for i := 0; i < 200 && filter.Level() != Error; i++ { time.Sleep(5 * time.Millisecond) }
+
+	if filter.Level() != Error { t.Errorf("TestKernelConfigurationWatcher is broken - level not changed: %v", filter.Level()) }
+
+	for i := 0; i < 200 && atomic.LoadInt32(&reloadable.reloads) == 0; i++ { time.Sleep(5 * time.Millisecond) }
+
+	if reloads := atomic.LoadInt32(&reloadable.reloads); reloads != 1 { t.Errorf("TestKernelConfigurationWatcher is broken - components reloaded: %d", reloads) }
+}
+
+type testConfigurationReloadable struct { reloads int32 }
+
+func (self *testConfigurationReloadable) Reload(kernel *Kernel) error { atomic.AddInt32(&self.reloads, 1); return nil }
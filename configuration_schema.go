@@ -0,0 +1,247 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// The value types are checked the same way the accessors convert the values (e.g., an int can be set as a
+// json number or as a string like " 4000", but not as "4000.0" - see configValueToInt).
+type ConfigurationType string
+
+const (
+	StringConfigurationType = ConfigurationType("string")
+	IntConfigurationType = ConfigurationType("int")
+	FloatConfigurationType = ConfigurationType("float")
+	BoolConfigurationType = ConfigurationType("bool")
+	StringListConfigurationType = ConfigurationType("stringList")
+	IntListConfigurationType = ConfigurationType("intList")
+	DocumentConfigurationType = ConfigurationType("document")
+	DocumentListConfigurationType = ConfigurationType("documentList") // A list of documents (see ConfigurationKey.Item).
+)
+
+// Components implement this interface to declare the configuration they read. The kernel validates
+// the schemas of all of the components before any component is started and returns all of the
+// violations in one error. Return nil if the component does not read the configuration. The schemas
+// of prototypes and lazy singletons are not validated (the instances do not exist when the kernel starts).
+type ConfigurationSchemaProvider interface {
+	ConfigurationSchema() *ConfigurationSchema
+}
+
+// The keys under a configuration path. Create the schema with NewConfigurationSchema and add the keys
+// with the Key method.
+type ConfigurationSchema struct {
+	Path string
+	Keys []*ConfigurationKey
+}
+
+// A key in a schema. The name is relative to the schema path (or to the document in a document list).
+// A required string must not be empty. The range is checked for int and float values and for the items
+// in an int list. If values are set, a string must be one of the values.
+type ConfigurationKey struct {
+	Name string
+	Type ConfigurationType
+	Required bool
+	Min float64
+	Max float64
+	HasMin bool
+	HasMax bool
+	Values []string
+	Items []*ConfigurationKey // The keys in each document of a document list.
+}
+
+// A problem with a configuration value. The key is the full path (e.g., "cron.scheduled.scheduledFunctions[1].audit").
+type ConfigurationViolation struct {
+	Key string
+	Problem string
+}
+
+func (self *ConfigurationViolation) Error() string { return fmt.Sprintf("Invalid configuration - key: %s - %s", self.Key, self.Problem) }
+
+func NewConfigurationSchema(path string) *ConfigurationSchema { return &ConfigurationSchema{ Path: path } }
+
+// Add a key to the schema and return it, so the constraints can be chained.
+func (self *ConfigurationSchema) Key(name string, keyType ConfigurationType) *ConfigurationKey {
+	key := &ConfigurationKey{ Name: name, Type: keyType }
+	self.Keys = append(self.Keys, key)
+	return key
+}
+
+// Add a key to the documents in a document list.
+func (self *ConfigurationKey) Item(name string, keyType ConfigurationType) *ConfigurationKey {
+	key := &ConfigurationKey{ Name: name, Type: keyType }
+	self.Items = append(self.Items, key)
+	return key
+}
+
+func (self *ConfigurationKey) Require() *ConfigurationKey { self.Required = true; return self }
+
+func (self *ConfigurationKey) AtLeast(min float64) *ConfigurationKey { self.Min, self.HasMin = min, true; return self }
+
+func (self *ConfigurationKey) AtMost(max float64) *ConfigurationKey { self.Max, self.HasMax = max, true; return self }
+
+func (self *ConfigurationKey) Range(min, max float64) *ConfigurationKey { return self.AtLeast(min).AtMost(max) }
+
+func (self *ConfigurationKey) OneOf(values ...string) *ConfigurationKey { self.Values = values; return self }
+
+// Validate the schemas. This does not stop at the first problem, all of the violations are returned
+// in an AggregateError. The environment variable overrides are applied before the values are checked.
+func (self *Configuration) Validate(schemas ...*ConfigurationSchema) error {
+
+	doc := self.Document()
+
+	var errs []error
+
+	for _, schema := range schemas {
+		if schema == nil { continue }
+		for _, key := range schema.Keys {
+			fullKey := key.Name
+			if len(schema.Path) > 0 { fullKey = fmt.Sprintf(confPathKeyPattern, schema.Path, key.Name) }
+
+			_, fromEnv := self.envOverride(fullKey)
+
+			errs = validateConfigurationKey(errs, key, fullKey, configurationDocumentValue(doc, fullKey), fromEnv)
+		}
+	}
+
+	return NewAggregateError("Invalid configuration", errs)
+}
+
+func validateConfigurationKey(errs []error, key *ConfigurationKey, fullKey string, value interface{}, fromEnv bool) []error {
+
+	violation := func(format string, args ...interface{}) []error {
+		return append(errs, &ConfigurationViolation{ Key: fullKey, Problem: fmt.Sprintf(format, args...) })
+	}
+
+	if value == nil {
+		if key.Required { return violation("required %s is not set", key.Type) }
+		return errs
+	}
+
+	// The list overrides can be comma separated values.
+	if str, ok := value.(string); ok && fromEnv && (key.Type == StringListConfigurationType || key.Type == IntListConfigurationType) { value = envList(str) }
+
+	switch key.Type {
+		case StringConfigurationType: {
+			switch value.(type) {
+				case map[string]interface{}, []interface{}: return violation("expected a string - found: %v", value)
+			}
+
+			str, ok := configurationString(value)
+			if !ok { return violation("expected a string - found: %v", value) }
+
+			if key.Required && len(strings.TrimSpace(str)) == 0 { return violation("required string is empty") }
+
+			if len(key.Values) > 0 && !configurationValueIn(str, key.Values) { return violation("value: %s - expected one of: %s", str, strings.Join(key.Values, ", ")) }
+		}
+
+		case IntConfigurationType: {
+			number, ok := configurationSchemaNumber(value, true)
+			if !ok || number != math.Trunc(number) { return violation("expected an int - found: %v", value) }
+			return validateConfigurationRange(errs, key, fullKey, number)
+		}
+
+		case FloatConfigurationType: {
+			number, ok := configurationSchemaNumber(value, false)
+			if !ok { return violation("expected a float - found: %v", value) }
+			return validateConfigurationRange(errs, key, fullKey, number)
+		}
+
+		case BoolConfigurationType: {
+			if _, ok := configurationBool(value); !ok { return violation("expected a bool - found: %v", value) }
+		}
+
+		case StringListConfigurationType, IntListConfigurationType, DocumentListConfigurationType: {
+			list, ok := value.([]interface{})
+			if !ok { return violation("expected a list - found: %v", value) }
+
+			if key.Required && len(list) == 0 { return violation("required list is empty") }
+
+			for i, item := range list {
+				itemKey := fmt.Sprintf("%s[%d]", fullKey, i)
+
+				switch key.Type {
+					case IntListConfigurationType: {
+						number, ok := configurationSchemaNumber(item, true)
+						if !ok || number != math.Trunc(number) { errs = append(errs, &ConfigurationViolation{ Key: itemKey, Problem: fmt.Sprintf("expected an int - found: %v", item) }); continue }
+						errs = validateConfigurationRange(errs, key, itemKey, number)
+					}
+
+					case DocumentListConfigurationType: {
+						itemDoc, ok := item.(map[string]interface{})
+						if !ok { errs = append(errs, &ConfigurationViolation{ Key: itemKey, Problem: fmt.Sprintf("expected a document - found: %v", item) }); continue }
+
+						for _, itemSchemaKey := range key.Items {
+							errs = validateConfigurationKey(errs, itemSchemaKey, fmt.Sprintf(confPathKeyPattern, itemKey, itemSchemaKey.Name), configurationDocumentValue(itemDoc, itemSchemaKey.Name), false)
+						}
+					}
+				}
+			}
+		}
+
+		case DocumentConfigurationType: if _, ok := value.(map[string]interface{}); !ok { return violation("expected a document - found: %v", value) }
+
+		default: return violation("unknown schema type: %s", key.Type)
+	}
+
+	return errs
+}
+
+func validateConfigurationRange(errs []error, key *ConfigurationKey, fullKey string, number float64) []error {
+	if key.HasMin && number < key.Min { return append(errs, &ConfigurationViolation{ Key: fullKey, Problem: fmt.Sprintf("value: %v - must be at least: %v", number, key.Min) }) }
+	if key.HasMax && number > key.Max { return append(errs, &ConfigurationViolation{ Key: fullKey, Problem: fmt.Sprintf("value: %v - must be at most: %v", number, key.Max) }) }
+	return errs
+}
+
+// Returns the number if the accessor (Int or Float) returns the value (see configValueToInt). The fraction
+// is returned for the ints, so it can be reported.
+func configurationSchemaNumber(value interface{}, integer bool) (float64, bool) {
+
+	number, ok := configurationFloat(value)
+	if !ok || !integer || number != math.Trunc(number) { return number, ok }
+
+	_, ok = configurationInt(value)
+	return number, ok
+}
+
+func configurationValueIn(value string, values []string) bool {
+	for _, v := range values { if v == value { return true } }
+	return false
+}
+
+// Returns the schemas declared by the components that implement the ConfigurationSchemaProvider interface
+// (sorted by component id, so the violations are always in the same order).
+func (self *Kernel) configurationSchemas() []*ConfigurationSchema {
+
+	componentIds := make([]string, 0, len(self.Components))
+	for componentId := range self.Components { componentIds = append(componentIds, componentId) }
+	sort.Strings(componentIds)
+
+	var schemas []*ConfigurationSchema
+
+	for _, componentId := range componentIds {
+		component := self.Components[componentId]
+		if component.onDemand() { continue }
+		if provider, ok := component.singleton.(ConfigurationSchemaProvider); ok { schemas = append(schemas, provider.ConfigurationSchema()) }
+	}
+
+	return schemas
+}
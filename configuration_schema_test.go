@@ -0,0 +1,174 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"os"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+)
+
+type testSchemaComponent struct {
+	testKernelOrderComponent
+	schema *ConfigurationSchema
+}
+
+func (self *testSchemaComponent) ConfigurationSchema() *ConfigurationSchema { return self.schema }
+
+func configurationViolationKeys(err error) map[string]bool {
+	keys := make(map[string]bool)
+	if aggregateErr, ok := err.(*AggregateError); ok {
+		for _, violation := range aggregateErr.Errors {
+			if v, ok := violation.(*ConfigurationViolation); ok { keys[v.Key] = true }
+		}
+	}
+	return keys
+}
+
+func TestConfigurationSchema(t *testing.T) {
+
+	os.Setenv("DLSHARED_SERVER__PORT", "abc")
+	defer os.Unsetenv("DLSHARED_SERVER__PORT")
+
+	os.Setenv("DLSHARED_SERVER__PORTS", "80, 443")
+	defer os.Unsetenv("DLSHARED_SERVER__PORTS")
+
+	configuration, err := NewConfigurationFromMap(map[string]interface{} {
+		"version": "1.0.0",
+		"environment": "test",
+		"server": map[string]interface{} { "port": 8080, "ratio": 1.5, "name": " ", "enabled": "true", "ports": []int{ 1 } },
+		"mongoDb": map[string]interface{} { "testDb": map[string]interface{} { "mode": "montonic", "type": "standalone", "dialTimeoutInMs": -1, "socketTimeoutInMs": "3000" } },
+		"cron": map[string]interface{} { "scheduledFunctions": []interface{} {
+			map[string]interface{} { "jobId": "a", "componentId": "b", "methodName": "Run", "schedule": "* * * * * *", "enabled": true },
+			map[string]interface{} { "componentId": "b", "methodName": "Run", "schedule": "* * * * * *", "audit": "yes" },
+			"invalid",
+		}},
+	})
+
+	if err != nil { t.Errorf("TestConfigurationSchema is broken: %v", err); return }
+
+	server := NewConfigurationSchema("server")
+	server.Key("port", IntConfigurationType).Require()
+	server.Key("ratio", FloatConfigurationType).Range(0, 1)
+	server.Key("name", StringConfigurationType).Require()
+	server.Key("enabled", BoolConfigurationType)
+	server.Key("ports", IntListConfigurationType).AtLeast(100)
+	server.Key("missing", DocumentConfigurationType)
+
+	err = configuration.Validate(server, NewMongoFromConfigPath("MongoTestDb", "mongoDb.testDb").ConfigurationSchema(), NewCronSvc("cron").ConfigurationSchema(), nil)
+
+	expected := []string {
+		"server.port",
+		"server.ratio",
+		"server.name",
+		"server.ports[0]",
+		"mongoDb.testDb.mongoUrl",
+		"mongoDb.testDb.mode",
+		"mongoDb.testDb.dialTimeoutInMs",
+		"mongoDb.testDb.syncTimeoutInMs",
+		"mongoDb.testDb.cursorTimeoutInMs",
+		"cron.mongoComponentId",
+		"cron.scheduledFunctions[1].jobId",
+		"cron.scheduledFunctions[1].audit",
+		"cron.scheduledFunctions[2]",
+	}
+
+	keys := configurationViolationKeys(err)
+
+	for _, key := range expected {
+		if !keys[key] { t.Errorf("TestConfigurationSchema is broken - expected a violation for: %s - error: %v", key, err) }
+	}
+
+	for _, key := range []string { "server.enabled", "server.ports[1]", "server.missing", "mongoDb.testDb.type", "mongoDb.testDb.socketTimeoutInMs", "cron.scheduledFunctions[0].audit" } {
+		if keys[key] { t.Errorf("TestConfigurationSchema is broken - unexpected violation for: %s", key) }
+	}
+
+	if err := configuration.Validate(NewConfigurationSchema("server")); err != nil { t.Errorf("TestConfigurationSchema is broken - empty schema: %v", err) }
+}
+
+func TestConfigurationSchemaConversions(t *testing.T) {
+
+	os.Setenv("DLSHARED_SERVER__TIMEOUT", " 4000 ")
+	defer os.Unsetenv("DLSHARED_SERVER__TIMEOUT")
+
+	schema := NewConfigurationSchema("server")
+	schema.Key("port", IntConfigurationType)
+	schema.Key("size", IntConfigurationType)
+	schema.Key("enabled", BoolConfigurationType)
+	schema.Key("name", StringConfigurationType)
+	schema.Key("count", IntConfigurationType)
+	schema.Key("timeout", IntConfigurationType)
+
+	// A value is only valid if the accessor returns it.
+	configuration, err := NewConfigurationFromMap(map[string]interface{} {
+		"version": "1.0.0",
+		"environment": "test",
+		"server": map[string]interface{} { "port": " 4000", "size": "4000.0", "enabled": "true ", "name": 5, "count": "10", "timeout": 1 },
+	})
+
+	if err != nil { t.Errorf("TestConfigurationSchemaConversions is broken: %v", err); return }
+
+	keys := configurationViolationKeys(configuration.Validate(schema))
+
+	for key, expected := range map[string]bool { "server.port": false, "server.size": true, "server.enabled": false, "server.name": false, "server.count": false, "server.timeout": false } {
+		if keys[key] != expected { t.Errorf("TestConfigurationSchemaConversions is broken - map key: %s - violations: %v", key, keys) }
+	}
+
+	// The file values are converted the same way.
+	dir, err := ioutil.TempDir(nadaStr, "dlshared_test_configuration")
+	if err != nil { t.Errorf("TestConfigurationSchemaConversions is broken: %v", err); return }
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "configuration.json")
+
+	content := `{ "version": "1.0.0", "environment": "test", "pidFile": "/tmp/test.pid", "server": { "port": 4000, "enabled": "true", "name": 5, "count": "10" } }`
+	if err := ioutil.WriteFile(fileName, []byte(content), 0644); err != nil { t.Errorf("TestConfigurationSchemaConversions is broken: %v", err); return }
+
+	if configuration, err = NewConfiguration(fileName); err != nil { t.Errorf("TestConfigurationSchemaConversions is broken: %v", err); return }
+
+	keys = configurationViolationKeys(configuration.Validate(schema))
+
+	for key, expected := range map[string]bool { "server.port": false, "server.enabled": false, "server.name": false, "server.count": false, "server.timeout": false } {
+		if keys[key] != expected { t.Errorf("TestConfigurationSchemaConversions is broken - file key: %s - violations: %v", key, keys) }
+	}
+}
+
+func TestKernelConfigurationSchema(t *testing.T) {
+
+	var calls []string
+
+	schema := NewConfigurationSchema("apn")
+	schema.Key("timeoutInMs", IntConfigurationType).Require()
+
+	_, err := StartTestKernel("kernelConfigurationSchema", map[string]interface{} { "apn": map[string]interface{} { "gateway": "localhost:2195" } }, func(kernel *Kernel) {
+		kernel.AddComponent("Schema", &testSchemaComponent{ testKernelOrderComponent: testKernelOrderComponent{ id: "Schema", calls: &calls }, schema: schema })
+		kernel.AddComponent("Apn", NewApplePushNotificationSvc("apn", nil, nil))
+	}, nil)
+
+	if err == nil { t.Errorf("TestKernelConfigurationSchema is broken - expected an error"); return }
+
+	keys := configurationViolationKeys(err.(*AggregateError).Errors[0])
+
+	for _, key := range []string { "apn.timeoutInMs", "apn.feedback", "apn.certificateFile", "apn.keyFile" } {
+		if !keys[key] { t.Errorf("TestKernelConfigurationSchema is broken - expected a violation for: %s - error: %v", key, err) }
+	}
+
+	if keys["apn.gateway"] { t.Errorf("TestKernelConfigurationSchema is broken - unexpected violation for: apn.gateway") }
+
+	if len(calls) != 0 { t.Errorf("TestKernelConfigurationSchema is broken - components started: %v", calls) }
+}
@@ -0,0 +1,174 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"strings"
+	"io/ioutil"
+	"path/filepath"
+	"gopkg.in/yaml.v2"
+	"github.com/BurntSushi/toml"
+	"github.com/daviddengcn/ljson"
+)
+
+// A source of configuration documents. The documents from the sources are merged in order (see
+// mergeConfigurationDocument). The name is the source of the values in the dump (e.g., "file:app.yaml").
+type ConfigurationSource interface {
+	Name() string
+	Load() (map[string]interface{}, error)
+}
+
+// Parses the content of a configuration file. The document can contain any of the values the json, yaml
+// and toml decoders produce - the values are converted to the json types before they are used (see
+// normalizeConfigurationValue), so the accessors behave the same for every format.
+type ConfigurationFormat func(content []byte) (map[string]interface{}, error)
+
+var configurationFormats = map[string]ConfigurationFormat {
+	".json": parseLjsonConfiguration,
+	".ljson": parseLjsonConfiguration,
+	".yaml": parseYamlConfiguration,
+	".yml": parseYamlConfiguration,
+	".toml": parseTomlConfiguration,
+}
+
+var configurationFormatsLock sync.RWMutex
+
+// Register the format for a file extension (e.g., ".hcl"). This replaces the format if the extension is
+// already registered. The files with an extension that is not registered are parsed as loose json. This
+// method will panic if the extension is empty or the format is nil.
+func RegisterConfigurationFormat(extension string, format ConfigurationFormat) {
+
+	if len(extension) == 0 || format == nil { panic(fmt.Sprintf("RegisterConfigurationFormat called with an empty extension or nil format - extension: %s", extension)) }
+
+	configurationFormatsLock.Lock()
+	defer configurationFormatsLock.Unlock()
+
+	configurationFormats[strings.ToLower(extension)] = format
+}
+
+func configurationFormat(fileName string) ConfigurationFormat {
+
+	configurationFormatsLock.RLock()
+	defer configurationFormatsLock.RUnlock()
+
+	if format, found := configurationFormats[strings.ToLower(filepath.Ext(fileName))]; found { return format }
+
+	return parseLjsonConfiguration
+}
+
+type fileConfigurationSource struct { fileName string }
+
+// Returns the source for a configuration file. The format is selected by the file extension (see
+// RegisterConfigurationFormat).
+func NewFileConfigurationSource(fileName string) ConfigurationSource { return &fileConfigurationSource{ fileName: fileName } }
+
+func (self *fileConfigurationSource) Name() string { return fileSourcePrefix + self.fileName }
+
+func (self *fileConfigurationSource) Load() (map[string]interface{}, error) {
+
+	content, err := ioutil.ReadFile(self.fileName)
+	if err != nil { return nil, NewStackError("Unable to load configuration file: %s - error: %v", self.fileName, err) }
+
+	doc, err := configurationFormat(self.fileName)(content)
+	if err != nil { return nil, NewStackError("Unable to parse configuration file: %s - error: %v", self.fileName, err) }
+
+	return normalizeConfigurationDocument(doc)
+}
+
+// The loose json format (comments and unquoted keys are allowed).
+func parseLjsonConfiguration(content []byte) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	err := ljson.Unmarshal(content, &doc)
+	return doc, err
+}
+
+func parseYamlConfiguration(content []byte) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	err := yaml.Unmarshal(content, &doc)
+	return doc, err
+}
+
+func parseTomlConfiguration(content []byte) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	err := toml.Unmarshal(content, &doc)
+	return doc, err
+}
+
+func normalizeConfigurationDocument(doc map[string]interface{}) (map[string]interface{}, error) {
+
+	if doc == nil { return make(map[string]interface{}), nil }
+
+	normalized, err := normalizeConfigurationValue(doc)
+	if err != nil { return nil, err }
+
+	return normalized.(map[string]interface{}), nil
+}
+
+// Convert a decoded value to the json types: the documents are map[string]interface{} (yaml decodes
+// map[interface{}]interface{}), the lists are []interface{}, all numbers are float64 and the times (toml)
+// are RFC 3339 strings.
+func normalizeConfigurationValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+		case nil, string, bool, float64: return v, nil
+		case int: return float64(v), nil
+		case int64: return float64(v), nil
+		case uint64: return float64(v), nil
+		case float32: return float64(v), nil
+		case time.Time: return v.Format(time.RFC3339Nano), nil
+		case map[string]interface{}: {
+			doc := make(map[string]interface{}, len(v))
+			for key, item := range v {
+				normalized, err := normalizeConfigurationValue(item)
+				if err != nil { return nil, err }
+				doc[key] = normalized
+			}
+			return doc, nil
+		}
+		case map[interface{}]interface{}: {
+			doc := make(map[string]interface{}, len(v))
+			for key, item := range v {
+				normalized, err := normalizeConfigurationValue(item)
+				if err != nil { return nil, err }
+				doc[fmt.Sprint(key)] = normalized
+			}
+			return doc, nil
+		}
+		case []interface{}: {
+			list := make([]interface{}, len(v))
+			for i := range v {
+				normalized, err := normalizeConfigurationValue(v[i])
+				if err != nil { return nil, err }
+				list[i] = normalized
+			}
+			return list, nil
+		}
+		case []map[string]interface{}: {
+			list := make([]interface{}, len(v))
+			for i := range v {
+				normalized, err := normalizeConfigurationValue(v[i])
+				if err != nil { return nil, err }
+				list[i] = normalized
+			}
+			return list, nil
+		}
+	}
+
+	return nil, NewStackError("Unsupported configuration value type: %T - value: %v", value, value)
+}
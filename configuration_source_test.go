@@ -0,0 +1,138 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+)
+
+var testConfigurationFormats = map[string]string {
+	"configuration.json": `{
+		// The loose json format allows comments.
+		"version": "1.0.0", "environment": "test", "pidFile": "/tmp/test.pid",
+		"server": { "http": { "port": 8080, "enabled": true, "ratio": 0.5, "hosts": [ "a", "b" ], "ports": [ 80, 443 ] } },
+		"jobs": [ { "jobId": "a", "maxRunTimeInSec": 2 }, { "jobId": "b", "maxRunTimeInSec": 3 } ]
+	}`,
+
+	"configuration.yaml": `
+# The yaml comments are allowed.
+version: "1.0.0"
+environment: test
+pidFile: /tmp/test.pid
+server:
+  http:
+    port: 8080
+    enabled: true
+    ratio: 0.5
+    hosts: [ a, b ]
+    ports:
+      - 80
+      - 443
+jobs:
+  - jobId: a
+    maxRunTimeInSec: 2
+  - jobId: b
+    maxRunTimeInSec: 3
+`,
+
+	"configuration.toml": `
+# The toml comments are allowed.
+version = "1.0.0"
+environment = "test"
+pidFile = "/tmp/test.pid"
+
+[server.http]
+port = 8080
+enabled = true
+ratio = 0.5
+hosts = [ "a", "b" ]
+ports = [ 80, 443 ]
+
+[[jobs]]
+jobId = "a"
+maxRunTimeInSec = 2
+
+[[jobs]]
+jobId = "b"
+maxRunTimeInSec = 3
+`,
+
+	"overlay.yml": `
+server:
+  http:
+    port: 9090
+`,
+}
+
+func TestConfigurationFormats(t *testing.T) {
+
+	dir, err := ioutil.TempDir(nadaStr, "dlshared_test_configuration")
+	if err != nil { t.Errorf("TestConfigurationFormats is broken: %v", err); return }
+	defer os.RemoveAll(dir)
+
+	for name, content := range testConfigurationFormats {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil { t.Errorf("TestConfigurationFormats is broken: %v", err); return }
+	}
+
+	jsonConfiguration, err := NewConfiguration(filepath.Join(dir, "configuration.json"))
+	if err != nil { t.Errorf("TestConfigurationFormats is broken: %v", err); return }
+
+	for _, name := range []string { "configuration.json", "configuration.yaml", "configuration.toml" } {
+
+		configuration, err := NewConfiguration(filepath.Join(dir, name))
+		if err != nil { t.Errorf("TestConfigurationFormats is broken - file: %s - %v", name, err); continue }
+
+		if !reflect.DeepEqual(configuration.Document(), jsonConfiguration.Document()) { t.Errorf("TestConfigurationFormats is broken - file: %s - document: %v", name, configuration.Document()) }
+
+		if configuration.Version != "1.0.0" || configuration.PidFile != "/tmp/test.pid" { t.Errorf("TestConfigurationFormats is broken - file: %s - version: %s", name, configuration.Version) }
+		if port := configuration.IntWithPath("server.http", "port", 0); port != 8080 { t.Errorf("TestConfigurationFormats is broken - file: %s - port: %d", name, port) }
+		if !configuration.Bool("server.http.enabled", false) { t.Errorf("TestConfigurationFormats is broken - file: %s - enabled", name) }
+		if ratio := configuration.Float("server.http.ratio", 0); ratio != 0.5 { t.Errorf("TestConfigurationFormats is broken - file: %s - ratio: %v", name, ratio) }
+		if hosts := configuration.StrList("server.http.hosts", nil); !reflect.DeepEqual(hosts, []string{ "a", "b" }) { t.Errorf("TestConfigurationFormats is broken - file: %s - hosts: %v", name, hosts) }
+		if ports := configuration.IntList("server.http.ports", nil); !reflect.DeepEqual(ports, []int{ 80, 443 }) { t.Errorf("TestConfigurationFormats is broken - file: %s - ports: %v", name, ports) }
+
+		if jobs := configuration.List("jobs", nil); len(jobs) != 2 || jobs[1].(map[string]interface{})["maxRunTimeInSec"] != 3.0 { t.Errorf("TestConfigurationFormats is broken - file: %s - jobs: %v", name, jobs) }
+
+		component := &struct { Port int `dlconfig:"http.port"`; Hosts []string `dlconfig:"http.hosts"` }{}
+		kernel := newKernelWithConfiguration("configurationFormats", configuration, Logger{})
+		if err := kernel.configureFromPath("configurationFormats", "server", component); err != nil || component.Port != 8080 || len(component.Hosts) != 2 {
+			t.Errorf("TestConfigurationFormats is broken - file: %s - dlconfig: %v - %+v", name, err, component)
+		}
+	}
+
+	// The overlays can be in a different format.
+	configuration, err := NewConfiguration(filepath.Join(dir, "configuration.toml"), filepath.Join(dir, "overlay.yml"))
+	if err != nil || configuration.Int("server.http.port", 0) != 9090 || len(configuration.List("jobs", nil)) != 2 { t.Errorf("TestConfigurationFormats is broken - overlay: %v", err) }
+
+	// An invalid file is an error, not a panic.
+	ioutil.WriteFile(filepath.Join(dir, "invalid.toml"), []byte("version = \n"), 0644)
+	if _, err := NewConfiguration(filepath.Join(dir, "invalid.toml")); err == nil { t.Errorf("TestConfigurationFormats is broken - invalid toml") }
+
+	// A custom format.
+	RegisterConfigurationFormat(".test", func(content []byte) (map[string]interface{}, error) {
+		return map[string]interface{} { "version": string(content), "environment": "test", "pidFile": "/tmp/test.pid", "count": 4 }, nil
+	})
+
+	ioutil.WriteFile(filepath.Join(dir, "configuration.test"), []byte("2.0.0"), 0644)
+
+	configuration, err = NewConfiguration(filepath.Join(dir, "configuration.test"))
+	if err != nil || configuration.Version != "2.0.0" || configuration.Int("count", 0) != 4 { t.Errorf("TestConfigurationFormats is broken - custom format: %v", err) }
+}
@@ -52,3 +52,32 @@ func TestLoadConfiguration(t *testing.T) {
 	if !found || nestedSlice == nil { t.Errorf("Configuration cron.scheduled.scheduledFunctions is missing"); return }
 }
 
+
+func TestConfigurationFromMap(t *testing.T) {
+
+	if _, err := NewConfigurationFromMap(map[string]interface{} { "version": "1.0.0" }); err == nil { t.Errorf("NewConfigurationFromMap is broken - expected an environment error") }
+
+	configuration, err := NewConfigurationFromMap(map[string]interface{} {
+		"version": "1.0.0",
+		"environment": "test",
+		"server": map[string]interface{} { "http": map[string]interface{} { "port": 9999, "bindAddress": "127.0.0.1", "enabled": true } },
+		"ports": []int{ 1, 2, 3 },
+	})
+
+	if err != nil { t.Errorf("NewConfigurationFromMap is broken: %v", err); return }
+
+	if configuration.Version != "1.0.0" || !configuration.EnvironmentIs("test") { t.Errorf("NewConfigurationFromMap is broken - version: %s - environment: %s", configuration.Version, configuration.Environment) }
+
+	if intVal := configuration.IntWithPath("server.http", "port", 0); intVal != 9999 { t.Errorf("Configuration map server.http.port is broken - expected 9999 - received: %d", intVal) }
+
+	if strVal := configuration.String("server.http.bindAddress", ""); strVal != "127.0.0.1" { t.Errorf("Configuration map server.http.bindAddress is broken - received: %s", strVal) }
+
+	if !configuration.BoolWithPath("server.http", "enabled", false) { t.Errorf("Configuration map server.http.enabled is broken") }
+
+	if intList := configuration.IntList("ports", nil); len(intList) != 3 || intList[2] != 3 { t.Errorf("Configuration map ports is broken - received: %v", intList) }
+
+	if strVal := configuration.String("server.http.missing", "def"); strVal != "def" { t.Errorf("Configuration map default is broken - received: %s", strVal) }
+
+	// The numbers must be the same type as the json decoder produces.
+	if _, ok := configuration.InterfaceWithPath("server.http", "port", nil).(float64); !ok { t.Errorf("Configuration map is broken - numbers must be float64") }
+}
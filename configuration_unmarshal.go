@@ -0,0 +1,65 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Decode the configuration at the path into the target, which must be a pointer. If the target is a struct,
+// the dlconfig tags are relative to the path (see configTagName for the tag format and supported types):
+//
+//    type JobConfig struct {
+//        Id string `dlconfig:"jobId,required"`
+//        Timeout time.Duration `dlconfig:"timeout,default=30s"`
+//        MaxResponseSize ByteSize `dlconfig:"maxResponseSize,default=10MB"`
+//    }
+//
+//    type JobsConfig struct {
+//        Enabled bool `dlconfig:"enabled,default=true"`
+//        Jobs []*JobConfig `dlconfig:"jobs,required"`
+//    }
+//
+//    config := &JobsConfig{}
+//    err := kernel.Configuration.Unmarshal("myComponent", config)
+//
+// Otherwise, the value at the path is converted to the target (e.g., a *[]*JobConfig) and the target is
+// not changed if the path is not set. The values are read with the accessors, so the environment variable
+// overrides are applied. The errors for all of the fields are returned in one error - each error contains
+// the field (e.g., "Jobs[1].Timeout") and the path (e.g., "myComponent.jobs[1].timeout").
+func (self *Configuration) Unmarshal(path string, target interface{}) error {
+
+	targetValue := reflect.ValueOf(target)
+	if targetValue.Kind() != reflect.Ptr || targetValue.IsNil() { return NewStackError("Unable to unmarshal configuration: %s - reason: target must be a non-nil pointer", path) }
+
+	name := fmt.Sprintf("Unable to unmarshal configuration: %s", path)
+
+	binder := &configBinder{ name: name, lookup: self.configLookup }
+
+	var errs []error
+
+	if element := targetValue.Elem(); isConfigStruct(element.Type()) {
+		errs = binder.bindStruct(path, path, nadaStr, element)
+	} else if value := self.configLookup(path); value != nil {
+		errs = binder.bindValue(path, nadaStr, element, value)
+	}
+
+	return NewAggregateError(name, errs)
+}
+
+func (self *Configuration) configLookup(path string) interface{} { return self.Interface(path, nil) }
@@ -0,0 +1,143 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"os"
+	"time"
+	"strings"
+	"testing"
+)
+
+type testUnmarshalJob struct {
+	Id string `dlconfig:"jobId,required"`
+	Timeout time.Duration `dlconfig:"timeout,default=30s"`
+	MaxSize ByteSize `dlconfig:"limits.maxSize,default=1MB"`
+	Enabled bool `dlconfig:"enabled,default=true"`
+	Hosts []string `dlconfig:"hosts"`
+}
+
+type testUnmarshalConfig struct {
+	Name string `dlconfig:"name,required"`
+	BufferSize ByteSize `dlconfig:"bufferSize"`
+	Interval time.Duration `dlconfig:"interval,default=1m"`
+	Jobs []*testUnmarshalJob `dlconfig:"jobs,required"`
+	Defaults testUnmarshalJob `dlconfig:"defaults"`
+	Named map[string]testUnmarshalJob `dlconfig:"named"`
+}
+
+func TestConfigurationUnmarshal(t *testing.T) {
+
+	values := map[string]interface{} {
+		"version": "1.0.0",
+		"environment": "test",
+		"unmarshal": map[string]interface{} {
+			"name": "test",
+			"bufferSize": "10MB",
+			"jobs": []interface{} {
+				map[string]interface{} { "jobId": "a", "timeout": "5s", "limits": map[string]interface{} { "maxSize": "512 KB" }, "hosts": []interface{} { "h1", "h2" } },
+				map[string]interface{} { "jobId": "b", "enabled": false, "limits": map[string]interface{} { "maxSize": 2048 } },
+			},
+			"defaults": map[string]interface{} { "jobId": "default" },
+			"named": map[string]interface{} { "c": map[string]interface{} { "jobId": "c", "timeout": 1500 } },
+		},
+	}
+
+	configuration, err := NewConfigurationFromMap(values)
+	if err != nil { t.Errorf("TestConfigurationUnmarshal is broken: %v", err); return }
+
+	config := &testUnmarshalConfig{}
+	if err := configuration.Unmarshal("unmarshal", config); err != nil { t.Errorf("TestConfigurationUnmarshal is broken: %v", err); return }
+
+	if config.Name != "test" || config.BufferSize != 10 * MB || config.Interval != time.Minute { t.Errorf("TestConfigurationUnmarshal is broken - config: %+v", config) }
+	if len(config.Jobs) != 2 { t.Errorf("TestConfigurationUnmarshal is broken - jobs: %+v", config.Jobs); return }
+
+	if job := config.Jobs[0]; job.Id != "a" || job.Timeout != 5 * time.Second || job.MaxSize != 512 * KB || !job.Enabled || strings.Join(job.Hosts, ",") != "h1,h2" { t.Errorf("TestConfigurationUnmarshal is broken - job: %+v", job) }
+	if job := config.Jobs[1]; job.Id != "b" || job.Timeout != 30 * time.Second || job.MaxSize != 2 * KB || job.Enabled { t.Errorf("TestConfigurationUnmarshal is broken - job: %+v", job) }
+	if config.Defaults.Id != "default" || config.Defaults.MaxSize != MB { t.Errorf("TestConfigurationUnmarshal is broken - defaults: %+v", config.Defaults) }
+	if job := config.Named["c"]; job.Id != "c" || job.Timeout != 1500 * time.Millisecond { t.Errorf("TestConfigurationUnmarshal is broken - named: %+v", config.Named) }
+
+	// The environment variable overrides are applied (they are read when the configuration is loaded).
+	os.Setenv(EnvOverrideName("unmarshal.bufferSize"), "2GB")
+	defer os.Unsetenv(EnvOverrideName("unmarshal.bufferSize"))
+
+	if configuration, err = NewConfigurationFromMap(values); err != nil { t.Errorf("TestConfigurationUnmarshal is broken: %v", err); return }
+
+	if err := configuration.Unmarshal("unmarshal", config); err != nil || config.BufferSize != 2 * GB { t.Errorf("TestConfigurationUnmarshal is broken - override: %v - %v", err, config.BufferSize) }
+
+	// A target that is not a struct.
+	var jobs []testUnmarshalJob
+	if err := configuration.Unmarshal("unmarshal.jobs", &jobs); err != nil || len(jobs) != 2 || jobs[1].Id != "b" { t.Errorf("TestConfigurationUnmarshal is broken - slice: %v - %+v", err, jobs) }
+
+	if err := configuration.Unmarshal("unmarshal", *config); err == nil { t.Errorf("TestConfigurationUnmarshal is broken - expected a pointer error") }
+}
+
+func TestConfigurationUnmarshalErrors(t *testing.T) {
+
+	configuration, err := NewConfigurationFromMap(map[string]interface{} {
+		"version": "1.0.0",
+		"environment": "test",
+		"unmarshal": map[string]interface{} {
+			"bufferSize": "10 parsecs",
+			"jobs": []interface{} {
+				map[string]interface{} { "jobId": "a" },
+				map[string]interface{} { "timeout": "soon", "limits": map[string]interface{} { "maxSize": 1.5 } },
+				"not a document",
+			},
+			"named": map[string]interface{} { "c": map[string]interface{} { "jobId": "c", "hosts": true } },
+		},
+	})
+
+	if err != nil { t.Errorf("TestConfigurationUnmarshalErrors is broken: %v", err); return }
+
+	err = configuration.Unmarshal("unmarshal", &testUnmarshalConfig{})
+	if err == nil { t.Errorf("TestConfigurationUnmarshalErrors is broken - expected an error"); return }
+
+	// Every problem must be reported in the one error.
+	for _, expected := range []string{
+		"field: Name - path: unmarshal.name - reason: required value not set",
+		"field: BufferSize - path: unmarshal.bufferSize - reason: expected a byte size",
+		"field: Jobs[1].Id - path: unmarshal.jobs[1].jobId - reason: required value not set",
+		"field: Jobs[1].Timeout - path: unmarshal.jobs[1].timeout - reason: expected a duration",
+		"field: Jobs[1].MaxSize - path: unmarshal.jobs[1].limits.maxSize - reason: expected a byte size",
+		"field: Jobs[2] - path: unmarshal.jobs[2] - reason: expected a document",
+		"field: Named[c].Hosts - path: unmarshal.named.c.hosts - reason: unable to convert bool",
+	} {
+		if !strings.Contains(err.Error(), expected) { t.Errorf("TestConfigurationUnmarshalErrors is broken - expected: %s - received: %v", expected, err) }
+	}
+
+	if strings.Contains(err.Error(), "Jobs[0]") { t.Errorf("TestConfigurationUnmarshalErrors is broken - unexpected error: %v", err) }
+
+	for value, expected := range map[string]ByteSize { "1024": KB, "1.5GB": GB + 512 * MB, "10 mib": 10 * MB, "3tb": 3 * TB } {
+		if size, err := ParseByteSize(value); err != nil || size != expected { t.Errorf("TestConfigurationUnmarshalErrors is broken - byte size: %s - %v - %v", value, size, err) }
+	}
+
+	for _, value := range []string { "", "MB", "-1MB", "1.2.3KB", "10XB", "8388608TB" } {
+		if _, err := ParseByteSize(value); err == nil { t.Errorf("TestConfigurationUnmarshalErrors is broken - expected a byte size error: %q", value) }
+	}
+
+	if size := (1536 * KB).String(); size != "1536KB" { t.Errorf("TestConfigurationUnmarshalErrors is broken - byte size string: %s", size) }
+
+	// An invalid default is an error even if the value is set.
+	var defaults struct { BufferSize ByteSize `dlconfig:"bufferSize,default=10XB"` }
+
+	configuration, _ = NewConfigurationFromMap(map[string]interface{} { "version": "1.0.0", "environment": "test", "unmarshal": map[string]interface{} { "bufferSize": "1MB" } })
+
+	if err := configuration.Unmarshal("unmarshal", &defaults); err == nil || !strings.Contains(err.Error(), "field: BufferSize - path: unmarshal.bufferSize - reason: invalid default") {
+		t.Errorf("TestConfigurationUnmarshalErrors is broken - expected an invalid default error: %v", err)
+	}
+}
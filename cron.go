@@ -17,8 +17,11 @@
 package dlshared
 
 import (
+	"fmt"
 	"time"
 	"sync"
+	"context"
+	"sync/atomic"
 	"github.com/robfig/cron"
 	"labix.org/v2/mgo/bson"
 )
@@ -27,14 +30,14 @@ type CronJob interface { Run() }
 type CronSchedule interface { Next(time.Time) time.Time }
 
 type cronJobDefinition struct {
-	Id string  `bson:"_id"`
-	ComponentId string `bson:"componentId"`
-	MethodName string `bson:"methodName"`
-	Schedule string `bson:"schedule"`
-	RequiresDistributedLock bool `bson:"requiresDistributedLock"`
-	Audit bool `bson:"audit"`
-	Enabled bool `bson:"enabled"`
-	MaxRunTimeInSec int `bson:"maxRunTimeInSec"`
+	Id string  `bson:"_id" dlconfig:"jobId,required"`
+	ComponentId string `bson:"componentId" dlconfig:"componentId,required"`
+	MethodName string `bson:"methodName" dlconfig:"methodName,required"`
+	Schedule string `bson:"schedule" dlconfig:"schedule,required"`
+	RequiresDistributedLock bool `bson:"requiresDistributedLock" dlconfig:"requiresDistributedLock,default=false"`
+	Audit bool `bson:"audit" dlconfig:"audit,default=false"`
+	Enabled bool `bson:"enabled" dlconfig:"enabled,default=true"`
+	MaxRunTimeInSec int `bson:"maxRunTimeInSec" dlconfig:"maxRunTimeInSec,default=0"`
 	Created *time.Time `bson:"created"`
 }
 
@@ -118,10 +121,11 @@ type CronSvc struct {
 	Logger
 	lock *sync.RWMutex
 	cronJobDefinitions map[string]*cronJobDefinition
-	stopChannel chan bool
+	monitorWorkers []*Worker
 	stopWaitGroup *sync.WaitGroup
 	cronJobDefMonitorTicker *time.Ticker
 	interruptChannels map[string]chan bool
+	running int32 // Set to one while the cron scheduler is running.
 }
 
 func NewCronSvc(configPath string) *CronSvc {
@@ -132,7 +136,6 @@ func NewCronSvc(configPath string) *CronSvc {
 		auditDs: &cronAuditDs{},
 		lock : &sync.RWMutex{},
 		cronJobDefinitions: make(map[string]*cronJobDefinition),
-		stopChannel: make(chan bool),
 		stopWaitGroup: new(sync.WaitGroup),
 		interruptChannels: make(map[string]chan bool),
 	}
@@ -330,19 +333,63 @@ func (self *CronSvc) addFunc(jobId, schedule string, cmd func(chan bool)) error
 	})
 }
 
+// The schema for the configuration path. This implements the ConfigurationSchemaProvider interface. In the
+// scheduled functions, requiresDistributedLock and audit default to false, enabled defaults to true and
+// a maxRunTimeInSec of zero means there is no limit.
+func (self *CronSvc) ConfigurationSchema() *ConfigurationSchema {
+
+	schema := NewConfigurationSchema(self.configPath)
+	schema.Key("mongoComponentId", StringConfigurationType).Require()
+	schema.Key("distributedLockComponentId", StringConfigurationType).Require()
+	schema.Key("definitionDbName", StringConfigurationType).Require()
+	schema.Key("definitionCollectionName", StringConfigurationType).Require()
+	schema.Key("auditDbName", StringConfigurationType).Require()
+	schema.Key("auditCollectionName", StringConfigurationType).Require()
+	schema.Key("auditTimeoutInSec", IntConfigurationType).AtLeast(0)
+	schema.Key("monitorScheduledFreqInSec", IntConfigurationType).AtLeast(1)
+
+	scheduled := schema.Key("scheduledFunctions", DocumentListConfigurationType).Require()
+	scheduled.Item("jobId", StringConfigurationType).Require()
+	scheduled.Item("componentId", StringConfigurationType).Require()
+	scheduled.Item("methodName", StringConfigurationType).Require()
+	scheduled.Item("schedule", StringConfigurationType).Require()
+	scheduled.Item("requiresDistributedLock", BoolConfigurationType)
+	scheduled.Item("audit", BoolConfigurationType)
+	scheduled.Item("enabled", BoolConfigurationType)
+	scheduled.Item("maxRunTimeInSec", IntConfigurationType).AtLeast(0)
+
+	return schema
+}
+
 // Load the cron job configuration from the config file and add the jobs to the cron struct.
 func (self *CronSvc) initJobsFromConfig(kernel *Kernel) error {
 
 	self.lock.Lock()
 	defer self.lock.Unlock()
 
+	// The kernel validates the schema before the components are started, but the service can be started
+	// on its own.
+	if err := kernel.Configuration.Validate(self.ConfigurationSchema()); err != nil { return err }
+
+	scheduled := &struct { Functions []*cronJobDefinition `dlconfig:"scheduledFunctions,required"` }{}
+
+	if err := kernel.Configuration.Unmarshal(self.configPath, scheduled); err != nil { return err }
+
 	mongoComponentId := kernel.Configuration.StringWithPath(self.configPath, "mongoComponentId", "")
 
+	mongo, err := mongoComponent(kernel, mongoComponentId)
+	if err != nil { return err }
+
 	auditTimeoutInSec := kernel.Configuration.IntWithPath(self.configPath, "auditTimeoutInSec", 31536000) // one year in seconds is the default
 
 	distributedLockComponentId := kernel.Configuration.StringWithPath(self.configPath, "distributedLockComponentId", "")
 
-	self.distributedLock = kernel.GetComponent(distributedLockComponentId).(DistributedLock)
+	if !kernel.HasComponent(distributedLockComponentId) { return NewStackError("Invalid cron distributed lock component id: %s", distributedLockComponentId) }
+
+	var ok bool
+	if self.distributedLock, ok = kernel.GetComponent(distributedLockComponentId).(DistributedLock); !ok {
+		return NewStackError("Cron distributed lock component: %s - does not implement DistributedLock", distributedLockComponentId)
+	}
 
 	self.cronJobDefMonitorTicker = time.NewTicker(time.Duration(kernel.Configuration.IntWithPath(self.configPath, "monitorScheduledFreqInSec", 5)) * time.Second)
 
@@ -353,13 +400,13 @@ func (self *CronSvc) initJobsFromConfig(kernel *Kernel) error {
 	self.definitionDs.MongoDataSource = MongoDataSource{
 		DbName: kernel.Configuration.StringWithPath(self.configPath, "definitionDbName", ""),
 		CollectionName: kernel.Configuration.StringWithPath(self.configPath, "definitionCollectionName", ""),
-		Mongo: kernel.GetComponent(mongoComponentId).(*Mongo),
+		Mongo: mongo,
 	}
 
 	self.auditDs.MongoDataSource = MongoDataSource{
 		DbName: kernel.Configuration.StringWithPath(self.configPath, "auditDbName", ""),
 		CollectionName: kernel.Configuration.StringWithPath(self.configPath, "auditCollectionName", ""),
-		Mongo: kernel.GetComponent(mongoComponentId).(*Mongo),
+		Mongo: mongo,
 	}
 
 	// If the audit timeout is eanbled, ensure the index on the created field.
@@ -372,31 +419,16 @@ func (self *CronSvc) initJobsFromConfig(kernel *Kernel) error {
 	if err := self.auditDs.EnsureIndex([]string{ "jobId", "_id", "startTime" }); err != nil { return err }
 	if err := self.auditDs.EnsureIndex([]string{ "startTime"  }); err != nil { return err }
 
-	scheduledInterface := kernel.Configuration.ListWithPath(self.configPath, "scheduledFunctions", nil)
-
-	if scheduledInterface == nil { return NewStackError("Unable to init configuration - path: %s.%s", self.configPath, "scheduledFunctions") }
-
 	seenJobIds := make(map[string]bool)
 
-	for i := range scheduledInterface {
-		if err := self.initJobFromConfig(kernel, seenJobIds, scheduledInterface[i].(map[string]interface{})); err != nil { return err }
+	for _, cronJobDefinition := range scheduled.Functions {
+		if err := self.initJobFromConfig(kernel, seenJobIds, cronJobDefinition); err != nil { return err }
 	}
 
 	return nil
 }
 
-func (self *CronSvc) initJobFromConfig(kernel *Kernel, seenJobIds map[string]bool, scheduledEntry map[string]interface{}) error {
-
-	cronJobDefinition := &cronJobDefinition{
-		Id: scheduledEntry["jobId"].(string),
-		ComponentId: scheduledEntry["componentId"].(string),
-		MethodName: scheduledEntry["methodName"].(string),
-		Schedule : scheduledEntry["schedule"].(string),
-		RequiresDistributedLock : scheduledEntry["requiresDistributedLock"].(bool),
-		Audit: scheduledEntry["audit"].(bool),
-		Enabled: scheduledEntry["enabled"].(bool),
-		MaxRunTimeInSec: int(scheduledEntry["maxRunTimeInSec"].(float64)),
-	}
+func (self *CronSvc) initJobFromConfig(kernel *Kernel, seenJobIds map[string]bool, cronJobDefinition *cronJobDefinition) error {
 
 	if _, found := seenJobIds[cronJobDefinition.Id]; found { return NewStackError("Duplicate cron job id - jobId: %s", cronJobDefinition.Id)
 	} else if !found { seenJobIds[cronJobDefinition.Id] = true }
@@ -439,6 +471,24 @@ func (self *CronSvc) initJobFromConfig(kernel *Kernel, seenJobIds map[string]boo
 	return nil
 }
 
+// The cron service uses the mongo component, the distributed lock and the components that
+// the scheduled functions are called on. All of these are defined in the configuration file.
+func (self *CronSvc) DependsOn(kernel *Kernel) []string {
+
+	dependencies := []string{
+		kernel.Configuration.StringWithPath(self.configPath, "mongoComponentId", ""),
+		kernel.Configuration.StringWithPath(self.configPath, "distributedLockComponentId", ""),
+	}
+
+	for _, scheduledEntry := range kernel.Configuration.ListWithPath(self.configPath, "scheduledFunctions", nil) {
+		if entry, ok := scheduledEntry.(map[string]interface{}); ok {
+			if componentId, ok := entry["componentId"].(string); ok { dependencies = append(dependencies, componentId) }
+		}
+	}
+
+	return dependencies
+}
+
 func (self *CronSvc) Start(kernel *Kernel) error {
 
 	self.Logger = kernel.Logger
@@ -449,29 +499,54 @@ func (self *CronSvc) Start(kernel *Kernel) error {
 
 	self.cron.Start()
 
-	go self.monitorCronJobDefinitions()
-	go self.monitorCronJobsAndDistributedLock()
+	self.monitorWorkers = []*Worker{
+		kernel.StartWorker("CronSvc.monitorCronJobDefinitions", self.monitorCronJobDefinitions),
+		kernel.StartWorker("CronSvc.monitorCronJobsAndDistributedLock", self.monitorCronJobsAndDistributedLock),
+	}
+
+	atomic.StoreInt32(&self.running, 1)
 
 	return nil
 }
 
-func (self *CronSvc) monitorCronJobsAndDistributedLock() {
-	self.stopWaitGroup.Add(1)
-	defer self.stopWaitGroup.Done()
+// The service is healthy if the scheduler is running. The detail includes the number of jobs and whether
+// or not the distributed lock is held. This implements the HealthChecker interface.
+func (self *CronSvc) CheckHealth(ctx context.Context) (string, error) {
+
+	if atomic.LoadInt32(&self.running) == 0 { return nadaStr, NewStackError("Cron service not running - configPath: %s", self.configPath) }
+
+	self.lock.RLock()
+	jobCount := len(self.cronJobDefinitions)
+	enabledCount := 0
+	for _, def := range self.cronJobDefinitions { if def.Enabled { enabledCount++ } }
+	self.lock.RUnlock()
+
+	// HasLock blocks while the lock is busy, so the check does not wait past the context.
+	held := make(chan bool, 1)
+	go func() { held <- self.distributedLock.HasLock() }()
+
+	select {
+		case hasLock := <- held: return fmt.Sprintf("jobs: %d - enabled: %d - lock: %s - held: %t", jobCount, enabledCount, self.distributedLock.LockId(), hasLock), nil
+		case <- ctx.Done(): return nadaStr, NewStackError("Cron distributed lock did not respond - lock: %s - err: %v", self.distributedLock.LockId(), ctx.Err())
+	}
+}
+
+// Run by the kernel as a worker (restarted on a panic).
+func (self *CronSvc) monitorCronJobsAndDistributedLock(ctx context.Context) error {
 
 	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
 
 	for {
 		select {
 			case <- ticker.C: self.signalRunningCronJobsIfDistributedLockLost()
-			case <- self.stopChannel: return
+			case <- ctx.Done(): return nil
 		}
 	}
 }
 
-func (self *CronSvc) monitorCronJobDefinitions() {
-	self.stopWaitGroup.Add(1)
-	defer self.stopWaitGroup.Done()
+// Run by the kernel as a worker (restarted on a panic).
+func (self *CronSvc) monitorCronJobDefinitions(ctx context.Context) error {
 	for {
 		select {
 			case <- self.cronJobDefMonitorTicker.C: {
@@ -480,15 +555,15 @@ func (self *CronSvc) monitorCronJobDefinitions() {
 				for _, cronJobDefinition := range cronJobDefinitions { self.updateCronJobDefintion(cronJobDefinition) }
 			}
 
-			case <- self.stopChannel: return
+			case <- ctx.Done(): return nil
 		}
 	}
 }
 
 func (self *CronSvc) Stop(kernel *Kernel) error {
+	atomic.StoreInt32(&self.running, 0)
 	self.cron.Stop()
-	self.stopChannel <- true
-	self.stopChannel <- true
+	for _, worker := range self.monitorWorkers { worker.Stop() }
 	self.signalAndRemoveAllInterruptChannels()
 	self.stopWaitGroup.Wait()
 	return nil
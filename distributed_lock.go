@@ -20,6 +20,8 @@ import (
 	"fmt"
 	"time"
 	"sync"
+	"context"
+	"sync/atomic"
 	"labix.org/v2/mgo"
 	"labix.org/v2/mgo/bson"
 )
@@ -80,6 +82,8 @@ type MongoDistributedLock struct {
 
 	currentProcessHasLock bool
 
+	running int32 // Set to one while the event listener is running.
+
 	stopWaitGroup *sync.WaitGroup
 
 	heartbeatTicker *time.Ticker
@@ -96,8 +100,7 @@ type MongoDistributedLock struct {
 	lockAcquiredChannel chan bool
 	lockReleasedChannel chan bool
 
-	hasLockRequestChannel chan bool
-	hasLockResponseChannel chan bool
+	hasLockRequestChannel chan chan bool // The response channel is sent with the request (see hasLock).
 
 	tryLockRequestChannel chan bool
 	tryLockResponseChannel chan bool
@@ -171,8 +174,7 @@ func NewMongoDistributedLock(	lockId,
 		heartbeatCompletedChannel: make(chan bool),
 		expireInactiveLockCompletedChannel: make(chan bool),
 
-		hasLockRequestChannel: make(chan bool),
-		hasLockResponseChannel: make(chan bool),
+		hasLockRequestChannel: make(chan chan bool),
 	}
 }
 
@@ -195,8 +197,37 @@ func (self *MongoDistributedLock) TryLock() bool {
 func (self *MongoDistributedLock) Unlock() { self.unlockRequestChannel <- true }
 
 func (self *MongoDistributedLock) HasLock() bool {
-	self.hasLockRequestChannel <- true
-	return <- self.hasLockResponseChannel
+	held, _ := self.hasLock(context.Background())
+	return held
+}
+
+// Returns true if the process has the lock or an error if the context is done first. Each request has
+// its own buffered response channel, so the lock loop does not block if the caller stops waiting.
+func (self *MongoDistributedLock) hasLock(ctx context.Context) (bool, error) {
+
+	response := make(chan bool, 1)
+
+	select {
+		case self.hasLockRequestChannel <- response:
+		case <- ctx.Done(): return false, ctx.Err()
+	}
+
+	select {
+		case held := <- response: return held, nil
+		case <- ctx.Done(): return false, ctx.Err()
+	}
+}
+
+// The lock is healthy if it is running. Not holding the lock is normal (another process may have it), so
+// the detail reports whether or not it is held. This implements the HealthChecker interface.
+func (self *MongoDistributedLock) CheckHealth(ctx context.Context) (string, error) {
+
+	if atomic.LoadInt32(&self.running) == 0 { return nadaStr, NewStackError("Distributed lock not running - lock: %s", self.lockId) }
+
+	held, err := self.hasLock(ctx)
+	if err != nil { return nadaStr, NewStackError("Distributed lock did not respond - lock: %s - err: %v", self.lockId, err) }
+
+	return fmt.Sprintf("lock: %s - held: %t", self.lockId, held), nil
 }
 
 // Try to expire inactive locks.
@@ -264,7 +295,7 @@ func (self *MongoDistributedLock) listenForEvents() {
 			case <- self.lockAcquiredChannel: { haveDistributedLock = true; lockInUse = false; self.localLock.Signal() }
 
 			case <- self.lockReleasedChannel: { haveDistributedLock = false; lockInUse = false }
-			case <- self.hasLockRequestChannel: { self.hasLockResponseChannel <- haveDistributedLock }
+			case response := <- self.hasLockRequestChannel: { response <- haveDistributedLock }
 
 			case <- self.acquireLockCompletedChannel: { acquireLockRunning = false }
 			case <- self.heartbeatCompletedChannel: { heartbeatRunning = false }
@@ -320,6 +351,9 @@ func (self *MongoDistributedLock) releaseLock() {
 	}
 }
 
+// The lock uses the mongo component so it must be started first.
+func (self *MongoDistributedLock) DependsOn(kernel *Kernel) []string { return []string{ self.ds.mongoComponentId } }
+
 func (self *MongoDistributedLock) Start(kernel *Kernel) error {
 
 	hostId := fmt.Sprintf("%s-%s-%d-%s", kernel.Configuration.Hostname, kernel.Id, kernel.Configuration.Pid, kernel.Configuration.Version)
@@ -351,11 +385,15 @@ func (self *MongoDistributedLock) Start(kernel *Kernel) error {
 	go self.listenForEvents()
 	self.stopWaitGroup.Add(1)
 
+	atomic.StoreInt32(&self.running, 1)
+
 	return nil
 }
 
 func (self *MongoDistributedLock) Stop(kernel *Kernel) error {
 
+	atomic.StoreInt32(&self.running, 0)
+
 	self.stopChannel <- true
 
 	self.heartbeatTicker.Stop()
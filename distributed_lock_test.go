@@ -19,6 +19,8 @@ package dlshared
 import (
 	"sync"
 	"time"
+	"context"
+	"strings"
 	"testing"
 )
 
@@ -67,3 +69,24 @@ func testLockUnlock(lock DistributedLock) {
 	lock.Unlock()
 }
 
+
+// The health checks do not wait on a busy lock past the context (this does not require mongo).
+func TestDistributedLockCheckHealthContext(t *testing.T) {
+
+	lock := &MongoDistributedLock{ lockId: "busy", running: 1, hasLockRequestChannel: make(chan chan bool) }
+
+	cronSvc := NewCronSvc("cron")
+	cronSvc.running, cronSvc.distributedLock = 1, lock
+
+	for name, checker := range map[string]HealthChecker { "lock": lock, "cron": cronSvc } {
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+
+		startTime := time.Now()
+		_, err := checker.CheckHealth(ctx)
+		cancel()
+
+		if err == nil || !strings.Contains(err.Error(), "did not respond") { t.Errorf("TestDistributedLockCheckHealthContext is broken - %s - expected an error: %v", name, err) }
+		if time.Since(startTime) > 2 * time.Second { t.Errorf("TestDistributedLockCheckHealthContext is broken - %s - the context was not used", name) }
+	}
+}
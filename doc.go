@@ -9,6 +9,9 @@
 //        github.com/mreiferson/go-httpclient
 //        labix.org/v2/mgo
 //        github.com/daviddengcn/go-ljson-conf
+//        github.com/daviddengcn/ljson
+//        gopkg.in/yaml.v2
+//        github.com/BurntSushi/toml
 //        github.com/gorilla/mux
 //        code.google.com/p/go.crypto/bcrypt
 //        github.com/nranchev/go-libGeoIP
@@ -257,6 +257,8 @@ func (self *AwsEmailSvc) SendHtmlEmailToOneAddress(from, to, subject, htmlTempla
 	return nil
 }
 
+func (self *AwsEmailSvc) DependsOn(kernel *Kernel) []string { return []string{ self.dbComponentName, self.templateComponentName } }
+
 func (self *AwsEmailSvc) Start(kernel *Kernel) error {
 
 	self.Logger = kernel.Logger
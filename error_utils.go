@@ -0,0 +1,42 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"fmt"
+	"bytes"
+)
+
+// The aggregate error holds all of the errors from an operation that does not stop at
+// the first problem (e.g., stopping the kernel components).
+type AggregateError struct {
+	Message string
+	Errors []error
+}
+
+// Create an aggregate error. If the errors slice is empty, nil is returned.
+func NewAggregateError(message string, errs []error) error {
+	if len(errs) == 0 { return nil }
+	return &AggregateError{ Message: message, Errors: errs }
+}
+
+func (self *AggregateError) Error() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("%s - %d error(s)", self.Message, len(self.Errors)))
+	for i, err := range self.Errors { buffer.WriteString(fmt.Sprintf("\n[%d] %v", i+1, err)) }
+	return buffer.String()
+}
@@ -0,0 +1,35 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestAggregateError(t *testing.T) {
+
+	if err := NewAggregateError("nothing", nil); err != nil { t.Errorf("TestAggregateError is broken - expected nil for no errors - received: %v", err) }
+
+	err := NewAggregateError("Unable to stop", []error{ fmt.Errorf("first"), fmt.Errorf("second") })
+	if err == nil { t.Errorf("TestAggregateError is broken - expected an error"); return }
+
+	msg := err.Error()
+	if !strings.HasPrefix(msg, "Unable to stop - 2 error(s)") { t.Errorf("TestAggregateError is broken - bad message: %s", msg) }
+	if !strings.Contains(msg, "[1] first") || !strings.Contains(msg, "[2] second") { t.Errorf("TestAggregateError is broken - missing errors: %s", msg) }
+}
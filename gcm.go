@@ -20,6 +20,7 @@ import(
 	"fmt"
 	"time"
 	"sync"
+	"context"
 	"strings"
 	"encoding/json"
 )
@@ -42,7 +43,8 @@ import(
 //		}
 //
 // In the example above, the configuration path would be "gcm" (passed in New function). This assumes
-// that "gcm" is located as child of the root of the document.
+// that "gcm" is located as child of the root of the document. The failure percent and backoff settings
+// are changed when the configuration is reloaded.
 //
 type GoogleCloudMessagingSvc struct {
 	Logger
@@ -57,12 +59,27 @@ type GoogleCloudMessagingSvc struct {
 	postUrl string
 
 	updateStatsTicker *time.Ticker
+	updateStatsWorker *Worker
+
+	removeConfigListener func()
 
 	httpClient HttpRequestClient
 
 	stats *GoogleCloudMsgSendStats
 	sync.WaitGroup
 
+	Config GoogleCloudMessagingSvcConfig `dlconfig:"."`
+}
+
+// The configuration values. These are set by the kernel from the configuration path (see the dlconfig tag).
+type GoogleCloudMessagingSvcConfig struct {
+	AuthKey string `dlconfig:"authKey,required"`
+	PostUrl string `dlconfig:"postUrl,default=https://android.googleapis.com/gcm/send"`
+	AcceptableFailurePercent int `dlconfig:"acceptableGoogleCloudMsgFailurePercent,default=10"`
+	InitialBackoffInMs int `dlconfig:"initialGoogleCloudMsgBackoffInMs,default=100"`
+	MaxBackoffInMs int `dlconfig:"maxGoogleCloudMsgBackoffInMs,default=10000"`
+	ConsumerMaxGoroutines int `dlconfig:"consumer.maxGoroutines,default=1000"`
+	ConsumerMaxWaitOnStopInMs int `dlconfig:"consumer.maxWaitOnStopInMs,default=30000"`
 }
 
 type GoogleCloudMessagingMsgResponseHandler func(*GoogleCloudMsgResponse)
@@ -193,29 +210,45 @@ func (self *GoogleCloudMessagingSvc) listenForRequests() {
 	}
 }
 
-func (self *GoogleCloudMessagingSvc) updateStats() { for now := range self.updateStatsTicker.C { self.stats.update(&now) } }
+// Run by the kernel as a worker (restarted on a panic).
+func (self *GoogleCloudMessagingSvc) updateStats(ctx context.Context) error {
+	for {
+		select {
+			case now := <- self.updateStatsTicker.C: self.stats.update(&now)
+			case <- ctx.Done(): return nil
+		}
+	}
+}
+
+func (self *GoogleCloudMessagingSvc) ConfigPath() string { return self.configPath }
 
 func (self *GoogleCloudMessagingSvc) Start(kernel *Kernel) error {
 
-	self.authKey = strings.TrimSpace(kernel.Configuration.StringWithPath(self.configPath, "authKey", ""))
-	self.postUrl = strings.TrimSpace(kernel.Configuration.StringWithPath(self.configPath, "postUrl", "https://android.googleapis.com/gcm/send"))
+	self.authKey = strings.TrimSpace(self.Config.AuthKey)
+	self.postUrl = strings.TrimSpace(self.Config.PostUrl)
 
 	if len(self.authKey) == 0 { return NewStackError("Unable to create GoogleCloudMessagingSvc - no \"authKey\" field in config file - path: %s", self.configPath) }
 
-	self.stats.acceptableGcmFailurePercent = kernel.Configuration.IntWithPath(self.configPath, "acceptableGoogleCloudMsgFailurePercent", 10)
-	self.stats.initialGcmBackoffInMs = kernel.Configuration.IntWithPath(self.configPath, "initialGoogleCloudMsgBackoffInMs", 100)
-	self.stats.maxGcmBackoffInMs = kernel.Configuration.IntWithPath(self.configPath, "maxGoogleCloudMsgBackoffInMs", 10000)
+	self.stats.setBackoffConfig(&self.Config)
 
 	self.consumer = NewConsumer("GoogleCloudMessagingSvcConsumer",
 								self.consumerChannel,
 								self.processMsg,
-								kernel.Configuration.IntWithPath(self.configPath, "consumer.maxGoroutines", 1000),
-								kernel.Configuration.IntWithPath(self.configPath, "consumer.maxWaitOnStopInMs", 30000),
+								self.Config.ConsumerMaxGoroutines,
+								self.Config.ConsumerMaxWaitOnStopInMs,
 								kernel.Logger)
 
 	if err := self.consumer.Start(); err != nil { return err }
 
-	go self.updateStats()
+	// The backoff settings are changed when the configuration is reloaded. The listener is removed in Stop.
+	self.removeConfigListener = kernel.Configuration.AddChangeListener(self.configPath, func(change *ConfigurationChange) error {
+		config := &GoogleCloudMessagingSvcConfig{}
+		if err := kernel.configureFromPath("GoogleCloudMessagingSvc", self.configPath, config); err != nil { return err }
+		self.stats.setBackoffConfig(config)
+		return nil
+	})
+
+	self.updateStatsWorker = kernel.StartWorker("GoogleCloudMessagingSvc.updateStats", self.updateStats)
 
 	go self.listenForRequests()
 
@@ -224,6 +257,10 @@ func (self *GoogleCloudMessagingSvc) Start(kernel *Kernel) error {
 
 func (self *GoogleCloudMessagingSvc) Stop(kernel *Kernel) error {
 
+	if self.removeConfigListener != nil { self.removeConfigListener() }
+
+	if self.updateStatsWorker != nil { self.updateStatsWorker.Stop() }
+
 	close(self.consumerChannel)
 
 	if self.consumer != nil { if err := self.consumer.Stop(); err != nil { return err } }
@@ -256,6 +293,14 @@ func (self *GoogleCloudMsgSendStats) update(now *time.Time) {
 	self.updateCurrent()
 }
 
+func (self *GoogleCloudMsgSendStats) setBackoffConfig(config *GoogleCloudMessagingSvcConfig) {
+	self.Lock(); defer self.Unlock()
+	self.acceptableGcmFailurePercent = config.AcceptableFailurePercent
+	self.initialGcmBackoffInMs = config.InitialBackoffInMs
+	self.maxGcmBackoffInMs = config.MaxBackoffInMs
+	if self.BackoffTimeInMs > self.maxGcmBackoffInMs { self.BackoffTimeInMs = self.maxGcmBackoffInMs }
+}
+
 func (self *GoogleCloudMsgSendStats) updateCurrent() {
 	self.PreviousSuccessCount = self.CurrentSuccessCount
 	self.PreviousFailureCount = self.CurrentFailureCount
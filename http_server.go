@@ -21,11 +21,15 @@ import (
 	"sync"
 	"time"
 	"net"
+	"context"
 	"strings"
+	"sync/atomic"
 	"net/http"
 	"github.com/gorilla/mux"
 )
 
+const httpServerConfigPath = "server.http"
+
 type HttpServerHandlerDef struct {
 	Path string
 	HandlerFunc http.HandlerFunc
@@ -38,12 +42,39 @@ type HttpServer struct {
 	kernel *Kernel
 	Logger
 	listener net.Listener
+	serving int32 // Set to one while the server is accepting connections.
+	staticFileDir atomic.Value // Changed when the configuration is reloaded.
+	removeConfigListener func()
+
+	Config HttpServerConfig `dlconfig:"server.http"`
+}
+
+// The configuration values. These are set by the kernel from "server.http" (see the dlconfig tag). The
+// static file dir is changed when the configuration is reloaded.
+type HttpServerConfig struct {
+	StaticFileDir string `dlconfig:"staticFileDir,default=./static/"`
+	BindAddress string `dlconfig:"bindAddress,default=127.0.0.1"`
+	Port int16 `dlconfig:"port,default=8080"`
+}
+
+// The server is healthy while it is accepting connections. This implements the HealthChecker interface.
+func (self *HttpServer) CheckHealth(ctx context.Context) (string, error) {
+	if atomic.LoadInt32(&self.serving) == 0 || self.listener == nil { return nadaStr, NewStackError("Http server not serving") }
+	return fmt.Sprintf("address: %s", self.listener.Addr()), nil
 }
 
 func (self *HttpServer) Id() string { return "httpServer" }
 
+// Add a handler. This must be called before the server is started (e.g., in the Start method of a
+// component that depends on the server).
+func (self *HttpServer) AddHandler(path string, handlerFunc http.HandlerFunc) {
+	self.handlerDefs = append(self.handlerDefs, &HttpServerHandlerDef{ Path: path, HandlerFunc: handlerFunc })
+}
+
 func (self *HttpServer) Stop(kernel *Kernel) error {
 
+	if self.removeConfigListener != nil { self.removeConfigListener() }
+
 	if self.listener != nil { if err := self.listener.Close(); err != nil { return err } }
 	return nil
 }
@@ -54,22 +85,33 @@ func (self *HttpServer) Start(kernel *Kernel) error {
 
 	self.Logger = kernel.Logger
 
-	staticFileDir := kernel.Configuration.String("server.http.staticFileDir", "./static/")
-	bindAddress := kernel.Configuration.String("server.http.bindAddress", "127.0.0.1")
-	port := int16(kernel.Configuration.Int("server.http.port", 8080))
+	self.staticFileDir.Store(self.Config.StaticFileDir)
+	bindAddress := self.Config.BindAddress
+	port := self.Config.Port
 
 	self.kernel = kernel
 	self.router = mux.NewRouter()
 
 	if self.handlerDefs != nil { for _, handlerDef := range self.handlerDefs { self.router.HandleFunc(handlerDef.Path, handlerDef.HandlerFunc) } }
 
-	self.router.PathPrefix("/").Handler(http.FileServer(http.Dir(staticFileDir)))
+	self.router.PathPrefix("/").HandlerFunc(self.serveStaticFile)
 
 	var err error
 
 	self.listener, err = net.Listen("tcp", AssembleHostnameAndPort(bindAddress, port))
 	if err != nil { return NewStackError(fmt.Sprintf("Unable to bind listener - address: %s - port: %d - err: %v", bindAddress, port, err)) }
 
+	// The static file dir is changed when the configuration is reloaded. The listener is removed in Stop.
+	self.removeConfigListener = kernel.Configuration.AddChangeListener(httpServerConfigPath, func(change *ConfigurationChange) error {
+		config := &HttpServerConfig{}
+		if err := kernel.configureFromPath(self.Id(), httpServerConfigPath, config); err != nil { return err }
+		if previous := self.staticFileDir.Load().(string); previous != config.StaticFileDir {
+			self.Logf(Info, "Http server static file dir changed - from: %s - to: %s", previous, config.StaticFileDir)
+			self.staticFileDir.Store(config.StaticFileDir)
+		}
+		return nil
+	})
+
 	self.server = &http.Server{
 		Addr: AssembleHostnameAndPort(bindAddress, port),
 		Handler: self.router,
@@ -81,7 +123,10 @@ func (self *HttpServer) Start(kernel *Kernel) error {
 	var startWaitGroup sync.WaitGroup
 	startWaitGroup.Add(1)
 
+	atomic.StoreInt32(&self.serving, 1)
+
 	go func() {
+		defer atomic.StoreInt32(&self.serving, 0)
 		startWaitGroup.Done()
 		if err = self.server.Serve(self.listener); err != nil {
 			if !strings.Contains(err.Error(), "closed") {
@@ -98,6 +143,10 @@ func (self *HttpServer) Start(kernel *Kernel) error {
 	return nil
 }
 
+func (self *HttpServer) serveStaticFile(response http.ResponseWriter, request *http.Request) {
+	http.FileServer(http.Dir(self.staticFileDir.Load().(string))).ServeHTTP(response, request)
+}
+
 func NewHttpServer(handlerDefs ...*HttpServerHandlerDef) *HttpServer {
 
 	server := &HttpServer{ }
@@ -21,9 +21,9 @@ import (
 	"fmt"
 	"time"
 	"flag"
+	"sync"
 	"strings"
 	"reflect"
-	"strconv"
 	"syscall"
 	"os/signal"
 	"math/rand"
@@ -49,9 +49,23 @@ type Kernel struct {
 	Configuration *Configuration
 	Components map[string]Component
 	components []Component
+	dependencies map[string][]string
+	startOrder []Component
 	Id string
 	Logger
 	Pid int
+	pidFileName string
+	pidFile *os.File // Held open (and locked) until the kernel is stopped.
+	started int32 // Set to one when the kernel is started (see Started).
+	failedStart bool // Set when the start fails, the components and workers are already stopped (see startFailed).
+	workers []*Worker
+	workerLock sync.Mutex
+	listeners []KernelEventListener
+	listenerLock sync.Mutex
+	lazyStarted []Component // The lazy singletons that were started (see ComponentDefinition).
+	lazyLock sync.Mutex
+	creating map[uint64][]string // The on-demand components each goroutine is creating (see enterCreating).
+	creatingLock sync.Mutex
 }
 
 type Component struct {
@@ -59,6 +73,12 @@ type Component struct {
 	singleton interface{}
 	startMethodName string
 	stopMethodName string
+	startInterfaceName string // Set if the start method is called through a lifecycle interface.
+	stopInterfaceName string // Set if the stop method is called through a lifecycle interface.
+	start lifecycleFunc
+	stop lifecycleFunc
+	definition *componentDefinition // Set if the component was registered with AddComponentDefinition.
+	creating []string // The ids of the on-demand components that are being created, including this one (see onDemandInstance).
 }
 
 // Returns true if the component is present. This panics if the component id is empty.
@@ -70,15 +90,26 @@ func (self *Kernel) HasComponent(componentId string) (found bool) {
 
 // Access another component. This method will panic if you attempt to reference a
 // non-existent component. If the component id has a length of zero, it is also panics.
+// If the component is a prototype, a new instance is returned. If the component is a lazy
+// singleton, it is created and started the first time this is called (see ComponentDefinition)
+// and this method will panic if it cannot be created or started. If this is called by the factory
+// or the start method of an on-demand component and it needs that component (a cycle), it panics.
 func (self *Kernel) GetComponent(componentId string) interface{} {
 
 	panicIfComponentIdNotSet(componentId)
 
-	if _, found := self.Components[componentId]; !found {
+	component, found := self.Components[componentId]
+	if !found {
 		panic(fmt.Sprintf("kernel.GetComponent called with an invalid component id: %s", componentId))
 	}
 
-	return self.Components[componentId].singleton.(interface{})
+	if component.onDemand() {
+		instance, err := self.onDemandInstance(component, self.creatingComponents())
+		if err != nil { panic(fmt.Sprintf("kernel.GetComponent unable to create component: %s - err: %v", componentId, err)) }
+		return instance
+	}
+
+	return component.singleton.(interface{})
 }
 
 func panicIfComponentIdNotSet(componentId string) { if len(componentId) == 0 { panic("kernel.GetComponent called with an empty component id") } }
@@ -88,10 +119,19 @@ func (self *Kernel) AddComponentWithStartStopMethods(componentId string, singlet
 
 	if singleton == nil { panic(fmt.Sprintf("Nil component passed to kernel for id: %s", componentId)) }
 
-	component := Component{ componentId : componentId, singleton : singleton, startMethodName : startMethodName, stopMethodName : stopMethodName }
+	self.addComponent(Component{	componentId : componentId,
+									singleton : singleton,
+									startMethodName : startMethodName,
+									stopMethodName : stopMethodName,
+									start : lifecycleMethodFunc("start", startMethodName, singleton),
+									stop : lifecycleMethodFunc("stop", stopMethodName, singleton),
+	})
+}
 
+func (self *Kernel) addComponent(component Component) {
 	self.components = append(self.components , component)
-	self.Components[componentId] = component
+	self.Components[component.componentId] = component
+	self.emitEvent(ComponentRegisteredEvent, &component, 0, nil)
 }
 
 // Register a component with a start method.
@@ -104,9 +144,25 @@ func (self *Kernel) AddComponentWithStopMethod(componentId string, singleton int
 	self.AddComponentWithStartStopMethods(componentId, singleton, "", stopMethodName)
 }
 
-// Register a component without a start or stop method.
+// Register a component. If the component implements one of the lifecycle interfaces (Starter,
+// KernelStarter, ContextStarter, Stopper, KernelStopper or ContextStopper), the kernel calls the
+// start/stop methods. This method will panic if a nil component is passed.
 func (self *Kernel) AddComponent(componentId string, singleton interface{}) {
-	self.AddComponentWithStartStopMethods(componentId, singleton, "", "")
+
+	self.addComponent(newComponent(componentId, singleton))
+}
+
+// Create a component and detect the lifecycle interfaces. This method will panic if a nil component is passed.
+func newComponent(componentId string, singleton interface{}) Component {
+
+	if singleton == nil { panic(fmt.Sprintf("Nil component passed to kernel for id: %s", componentId)) }
+
+	component := Component{ componentId : componentId, singleton : singleton }
+
+	if component.start, component.startInterfaceName = lifecycleStartFunc(singleton); component.start != nil { component.startMethodName = "Start" }
+	if component.stop, component.stopInterfaceName = lifecycleStopFunc(singleton); component.stop != nil { component.stopMethodName = "Stop" }
+
+	return component
 }
 
 // Called by the kernel during Start/Stop.
@@ -149,138 +205,366 @@ func callStartStopMethod(methodTypeName, methodName string, singleton interface{
 	return nil
 }
 
-// Call this after the kernel has been created and components registered.
+// Call this after the kernel has been created and components registered. The configuration schemas
+// declared by the components are validated first (see ConfigurationSchemaProvider). The components are
+// started in dependency order (see AddDependency and DependentComponent). If a component fails
+// to start, the components that were already started and the workers are stopped (in reverse order)
+// and the pid file is removed. The error returned contains the start error and any stop errors. The event
+// listeners are called as the kernel starts (see AddEventListener).
 func (self *Kernel) Start() error {
 
+	startTime := time.Now()
+
+	self.emitEvent(KernelStartingEvent, nil, 0, nil)
+
+	if err := self.start(); err != nil {
+		self.emitEvent(KernelStartFailedEvent, nil, time.Since(startTime), err)
+		return err
+	}
+
+	self.emitEvent(KernelReadyEvent, nil, time.Since(startTime), nil)
+
+	return nil
+}
+
+func (self *Kernel) start() error {
+
 	rand.Seed(time.Now().UTC().UnixNano())
 
 	self.Logf(Info, "Starting: %s - version: %s - config file: %s", self.Id, self.Configuration.Version, self.Configuration.FileName)
 
-	if err := self.injectComponents(); err != nil { return err }
+	self.failedStart = false
 
-	for i := range self.components {
-		if len(self.components[i].startMethodName) > 0 {
-			if err := callStartStopMethod("start", self.components[i].startMethodName, self.components[i].singleton, self); err != nil {
-				return err
-			}
-		}
+	if err := self.createDefinedComponents(); err != nil { return self.startFailed(err, nil) }
+
+	if err := self.Configuration.Validate(self.configurationSchemas()...); err != nil { return self.startFailed(err, nil) }
+
+	// The missing dependencies and cycles are found before anything is injected. The start order is calculated
+	// again after the prototypes are injected, so the components they use are started first.
+	if _, err := self.componentStartOrder(); err != nil { return self.startFailed(err, nil) }
+
+	if err := self.injectComponents(); err != nil { return self.startFailed(err, nil) }
+
+	if err := self.configureComponents(); err != nil { return self.startFailed(err, nil) }
+
+	startOrder, err := self.componentStartOrder()
+	if err != nil { return self.startFailed(err, nil) }
+
+	self.Logf(Debug, "Component start order: %s", strings.Join(componentIds(startOrder), ", "))
+
+	self.startOrder = startOrder
+
+	for i := range self.startOrder {
+		if err := self.startComponent(self.startOrder[i]); err != nil { return self.startFailed(err, self.startOrder[:i]) }
 	}
 
+	self.setStarted(true)
+
+	self.startConfigurationWatcher()
+
 	self.Logf(Info, "Started: %s - version: %s - config file: %s ", self.Id, self.Configuration.Version, self.Configuration.FileName)
 
 	return nil
 }
 
+// Call the start method on a component. A panic in the start method is returned as an error. If a
+// start timeout is configured for the component and the start method does not return in time, an
+// error is returned.
+func (self *Kernel) startComponent(component Component) error {
+
+	self.emitEvent(ComponentStartingEvent, &component, 0, nil)
+
+	startTime := time.Now()
+
+	if component.start != nil {
+		timeout := self.componentTimeout(component.componentId, kernelStartTimeoutKey)
+
+		if err := self.callLifecycle(component, "start", component.start, timeout, time.Time{}); err != nil {
+			err = NewStackError("Unable to start component: %s - err: %v", component.componentId, err)
+			self.emitEvent(ComponentStartFailedEvent, &component, time.Since(startTime), err)
+			return err
+		}
+	}
+
+	self.emitEvent(ComponentStartedEvent, &component, time.Since(startTime), nil)
+
+	return nil
+}
+
+// Returns the end of the shutdown budget (kernel.shutdownTimeoutInMs) or the zero time if it is not set.
+func (self *Kernel) shutdownDeadline() time.Time {
+	if shutdownTimeout := self.kernelTimeout(kernelShutdownTimeoutKey); shutdownTimeout > 0 { return time.Now().Add(shutdownTimeout) }
+	return time.Time{}
+}
+
+// Call the stop methods on the components in reverse order. This does not stop at the first
+// error, all of the components are stopped and the errors are returned. If the deadline is set
+// (see shutdownDeadline), the kernel will not wait on components once the budget is used up.
+func (self *Kernel) stopComponents(components []Component, deadline time.Time) []error {
+
+	var errs []error
+
+	for i := len(components)-1 ; i >= 0 ; i-- {
+		if err := self.stopComponent(components[i], deadline); err != nil {
+			self.Logf(Error, "%v", err)
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// Call the stop method on a component. A panic in the stop method is returned as an error.
+func (self *Kernel) stopComponent(component Component, deadline time.Time) error {
+
+	self.emitEvent(ComponentStoppingEvent, &component, 0, nil)
+
+	startTime := time.Now()
+
+	if component.stop != nil {
+		timeout := self.componentTimeout(component.componentId, kernelStopTimeoutKey)
+
+		if err := self.callLifecycle(component, "stop", component.stop, timeout, deadline); err != nil {
+			err = NewStackError("Unable to stop component: %s - err: %v", component.componentId, err)
+			self.emitEvent(ComponentStopFailedEvent, &component, time.Since(startTime), err)
+			return err
+		}
+	}
+
+	self.emitEvent(ComponentStoppedEvent, &component, time.Since(startTime), nil)
+
+	return nil
+}
+
+// Called when the kernel is unable to start. This stops the components that were started and the workers
+// and removes the pid file so a failed start does not leave anything behind. The kernel is then stopped, so
+// a later call to Stop does not stop anything.
+func (self *Kernel) startFailed(startErr error, started []Component) error {
+
+	self.Logf(Error, "Unable to start: %s - stopping %d started component(s) - err: %v", self.Id, len(started), startErr)
+
+	// The started components are a slice of the start order, so they are copied before the lazy singletons are added.
+	stopping := append(append(make([]Component, 0, len(started)), started...), self.takeLazyStarted()...)
+
+	deadline := self.shutdownDeadline()
+
+	errs := append([]error{ startErr }, self.stopComponents(self.componentStopOrder(stopping), deadline)...)
+
+	errs = append(errs, self.stopWorkers(deadline)...)
+
+	if err := removePidFile(self); err != nil { errs = append(errs, err) }
+
+	self.startOrder = nil
+	self.failedStart = true
+
+	return NewAggregateError(fmt.Sprintf("Unable to start: %s", self.Id), errs)
+}
+
 func (self *Kernel) injectComponents() error {
 
 	// Loop through the components, look at the variables for tags and automatically do the injection
-	// if the tag is set on a field.
-	for componentId, component := range self.Components {
+	// if the tag is set on a field. The components are injected in the order they were registered.
+	// The components that are created on demand are injected when they are created.
+	for _, component := range self.components {
+
+		if component.onDemand() { continue }
+
+		injectStartTime := time.Now()
+
+		if err := self.injectComponent(component); err != nil { return err }
+
+		self.emitEvent(ComponentInjectedEvent, &component, time.Since(injectStartTime), nil)
+	}
+
+	return nil
+}
+
+func (self *Kernel) injectComponent(component Component) error {
+
+	componentId := component.componentId
 
-		// Get the value of the component and cast.
-		componentValue := reflect.ValueOf(component.singleton).Elem()
-		componentType := reflect.TypeOf(componentValue.Interface())
+	// Get the value of the component. Only struct pointers are injected.
+	componentValue, ok := componentStructValue(component.singleton)
+	if !ok { return nil }
 
-		// Loop through the fields.
-		fieldCount := componentType.NumField()
-		for i := 0; i < fieldCount; i++ {
+	componentType := componentValue.Type()
 
-			structField := componentType.Field(i)
-			fieldValue := componentValue.Field(i)
+	// Loop through the fields.
+	fieldCount := componentType.NumField()
+	for i := 0; i < fieldCount; i++ {
 
-			var mongoDbName string
-			var mongoCollectionName string
+		structField := componentType.Field(i)
+		fieldValue := componentValue.Field(i)
 
-			// Check to see if the tag is set.
-			injectComponentId := structField.Tag.Get("dlinject")
+		var mongoDbName string
+		var mongoCollectionName string
 
-			if len(injectComponentId) == 0 {
-				if structField.Type.String() == injectLoggerName && structField.Name == injectLoggerFieldName {
-					fieldValue.Set(reflect.ValueOf(self.Logger))
-					continue
-				}
+		// Check to see if the tag is set.
+		injectComponentId, tagged := structField.Tag.Lookup(injectTagName)
 
-				if structField.Type.String() == injectConfigurationName && structField.Name == injectConfigurationFieldName {
-					fieldValue.Set(reflect.ValueOf(self.Configuration))
-					continue
-				}
+		if !tagged {
+			if structField.Type.String() == injectLoggerName && structField.Name == injectLoggerFieldName {
+				fieldValue.Set(reflect.ValueOf(self.Logger))
+				continue
+			}
 
-				if structField.Type.String() == injectKernelName && structField.Name == injectKernelFieldName {
-					fieldValue.Set(reflect.ValueOf(self))
-					continue
-				}
+			if structField.Type.String() == injectConfigurationName && structField.Name == injectConfigurationFieldName {
+				fieldValue.Set(reflect.ValueOf(self.Configuration))
+				continue
+			}
 
+			if structField.Type.String() == injectKernelName && structField.Name == injectKernelFieldName {
+				fieldValue.Set(reflect.ValueOf(self))
 				continue
 			}
 
-			if structField.Type.String() == injectMongoDataSourceName {
-				dataSourceConfig := strings.Split(injectComponentId, commaStr)
+			continue
+		}
 
-				if len(dataSourceConfig) != 3 {
-					return NewStackError(	"Unable to inject component: %s - into component: %s - reason: config must be componentId,dbName,collectionName",
-											injectComponentId,
-											componentId)
-				}
+		if structField.Type.String() == injectMongoDataSourceName {
+			dataSourceConfig := strings.Split(injectComponentId, commaStr)
 
-				injectComponentId = strings.TrimSpace(dataSourceConfig[0])
-				mongoDbName = strings.TrimSpace(dataSourceConfig[1])
-				mongoCollectionName = strings.TrimSpace(dataSourceConfig[2])
+			if len(dataSourceConfig) != 3 {
+				return NewStackError(	"Unable to inject component: %s - into component: %s - reason: config must be componentId,dbName,collectionName",
+										injectComponentId,
+										componentId)
 			}
 
-			// Make sure the component is present.
-			injectComponent, found := self.Components[injectComponentId]
-			if !found {
-				return NewStackError(	"Unable to inject component: %s - into component: %s - reason: %s not found",
+			injectComponentId = strings.TrimSpace(dataSourceConfig[0])
+			mongoDbName = strings.TrimSpace(dataSourceConfig[1])
+			mongoCollectionName = strings.TrimSpace(dataSourceConfig[2])
+
+		} else {
+			var err error
+			if injectComponentId, err = self.injectFieldComponentId(componentId, structField, injectComponentId); err != nil { return err }
+
+			// This is an optional field and there is nothing to inject.
+			if len(injectComponentId) == 0 { continue }
+		}
+
+		// Make sure the component is present.
+		injectComponent, found := self.Components[injectComponentId]
+		if !found {
+			return NewStackError(	"Unable to inject component: %s - into component: %s - reason: %s not found",
+									injectComponentId,
+									componentId,
+									injectComponentId)
+		}
+
+		if !fieldValue.CanSet() {
+			return NewStackError(	"Unable to inject component: %s - into component: %s - on field: %s - reason: field not exported",
+									injectComponentId,
+									componentId,
+									structField.Name)
+		}
+
+		// A prototype is created for each field.
+		injectSingleton := injectComponent.singleton
+		if injectComponent.onDemand() {
+			var err error
+			if injectSingleton, err = self.onDemandInstance(injectComponent, component.creating); err != nil {
+				return NewStackError("Unable to inject component: %s - into component: %s - err: %v", injectComponentId, componentId, err)
+			}
+		}
+
+		// Check to see if this is a mongo data source component
+		if structField.Type.String() == injectMongoDataSourceName {
+			mongo, ok := injectSingleton.(*Mongo)
+			if !ok {
+				return NewStackError(	"Unable to inject component: %s - into component: %s - on field: %s - reason: %T is not a *Mongo",
 										injectComponentId,
 										componentId,
-										injectComponentId)
+										structField.Name,
+										injectSingleton)
 			}
 
-			if !fieldValue.CanSet() {
-				return NewStackError(	"Unable to inject component: %s - into component: %s - on field: %s - reason: field not exported",
+			fieldValue.Set(reflect.ValueOf(MongoDataSource{	DbName: mongoDbName,
+															CollectionName: mongoCollectionName,
+															Mongo: mongo,
+															Logger: self.Logger,
+			}))
+
+		} else {
+			if !reflect.TypeOf(injectSingleton).AssignableTo(structField.Type) {
+				return NewStackError(	"Unable to inject component: %s - into component: %s - on field: %s - reason: %T is not assignable to %s",
 										injectComponentId,
 										componentId,
-										structField.Name)
+										structField.Name,
+										injectSingleton,
+										structField.Type)
 			}
 
-			// Check to see if this is a mongo data source component
-			if structField.Type.String() == injectMongoDataSourceName {
-				fieldValue.Set(reflect.ValueOf(MongoDataSource{	DbName: mongoDbName,
-																CollectionName: mongoCollectionName,
-																Mongo: injectComponent.singleton.(*Mongo),
-																Logger: self.Logger,
-				}))
-
-			} else { fieldValue.Set(reflect.ValueOf(injectComponent.singleton)) }
+			fieldValue.Set(reflect.ValueOf(injectSingleton))
 		}
 	}
 
 	return nil
 }
 
-// Stop the kernel. Call this before exiting.
+// Stop the kernel. Call this before exiting. The components are stopped in the reverse
+// of the order they were started (a lazy singleton is stopped before the components that depend
+// on it, see componentStopOrder), the workers are stopped and then the pid file is removed. If the
+// start failed, everything was already stopped (see Start), so only the log files are closed.
 func (self *Kernel) Stop() error {
 
+	startTime := time.Now()
+
+	self.emitEvent(KernelStoppingEvent, nil, 0, nil)
+
+	err := self.stop()
+
+	self.emitEvent(KernelStoppedEvent, nil, time.Since(startTime), err)
+
+	return err
+}
+
+func (self *Kernel) stop() error {
+
 	self.Logf(Info, "Stopping: %s - version: %s - config file %s", self.Id, self.Configuration.Version, self.Configuration.FileName)
 
-	for i := len(self.components)-1 ; i >= 0 ; i-- {
+	self.setStarted(false)
 
-		if len(self.components[i].stopMethodName) > 0 {
-			if err := callStartStopMethod("stop", self.components[i].stopMethodName, self.components[i].singleton, self); err != nil {
-				return err
-			}
-		}
+	if self.failedStart {
+		self.Logf(Info, "Stopped: %s - the start failed, nothing to stop", self.Id)
+		self.closeAppenders()
+		return nil
+	}
+
+	stopOrder := self.startOrder
+	if stopOrder == nil { stopOrder = self.components }
+
+	// The components and workers share the shutdown budget.
+	deadline := self.shutdownDeadline()
+
+	errs := self.stopComponents(self.componentStopOrder(append(append([]Component{}, stopOrder...), self.takeLazyStarted()...)), deadline)
+
+	errs = append(errs, self.stopWorkers(deadline)...)
+
+	if err := removePidFile(self); err != nil { errs = append(errs, err) }
+
+	if len(errs) > 0 {
+		self.closeAppenders()
+		return NewAggregateError(fmt.Sprintf("Unable to cleanly stop: %s", self.Id), errs)
 	}
 
 	self.Logf(Info, "Stopped: %s - version: %s - config file: %s", self.Id, self.Configuration.Version, self.Configuration.FileName)
 
+	// Close the log files last, this waits for the rotated files to be compressed.
+	self.closeAppenders()
+
 	return nil
 }
 
+func (self *Kernel) closeAppenders() {
+	for _, appender := range self.Logger.Appenders {
+		if err := closeAppender(appender); err != nil { fmt.Fprintf(os.Stderr, "Unable to close log appender: %T - err: %v\n", appender, err) }
+	}
+}
+
 func newKernel(id, configFileName string) (*Kernel, error) {
 
 	// Init the application configuration
-	conf, err := NewConfiguration(configFileName)
+	conf, err := NewEnvironmentConfiguration(configFileName)
 
 	if err != nil {
 		return nil, err
@@ -292,12 +576,7 @@ func newKernel(id, configFileName string) (*Kernel, error) {
 		return nil, err
 	}
 
-	logger := Logger{ Prefix: id, Appenders: logAppenders }
-
-	// Create the kernel
-	kernel := &Kernel{ Components : make(map[string]Component), Configuration : conf }
-	kernel.Logger = logger
-	kernel.Id = id
+	kernel := newKernelWithConfiguration(id, conf, Logger{ Prefix: id, Appenders: logAppenders })
 
 	if err = writePidFile(kernel); err != nil {
 		return nil, err
@@ -306,12 +585,75 @@ func newKernel(id, configFileName string) (*Kernel, error) {
 	return kernel, nil
 }
 
-// TODO: Add a logging structure to the configuration file and configure. Make
-// sure this supports configuring syslog.
+// Create the kernel struct. This does not write the pid file.
+func newKernelWithConfiguration(id string, conf *Configuration, logger Logger) *Kernel {
+	kernel := &Kernel{ Components : make(map[string]Component), dependencies : make(map[string][]string), Configuration : conf }
+	kernel.Logger = logger
+	kernel.Id = id
+	kernel.Pid = os.Getpid()
+	return kernel
+}
+
+// The log level is set in the configuration file and defaults to debug. The level is changed when the
+// configuration is reloaded. The format of the logs is "text" (see FormatLog) or "json" (see JsonAppender)
+// and defaults to text. The format is not changed when the configuration is reloaded. If the file is set,
+// the logs are also written to a file that is rotated (see RotatingFileAppenderConfig). The file is reopened
+// when the kernel is reloaded (e.g., on a SIGHUP after logrotate moves the file).
+//
+//    "logger": {
+//        "level": "info",
+//        "format": "json",
+//        "file": {
+//            "fileName": "/var/log/myapp/myapp.log",
+//            "maxSize": "100MB",
+//            "rotateInterval": "24h",
+//            "compress": true,
+//            "maxFiles": 30,
+//            "maxAgeInDays": 90
+//        }
+//    }
+const (
+	loggerLevelConfigKey = "logger.level"
+	defaultLoggerLevel = "debug"
+	loggerFormatConfigKey = "logger.format"
+	loggerFileConfigPath = "logger.file"
+	textLoggerFormat = "text"
+	jsonLoggerFormat = "json"
+)
+
+// TODO: Add the appenders to the configuration file. Make sure this supports configuring syslog.
 func configureLogger(id string, conf *Configuration) ([]Appender, error) {
 
-	var appenders []Appender
-	appenders = append(appenders, LevelFilter(Debug, StdErrAppender()))
+	level, err := ParseLevel(conf.String(loggerLevelConfigKey, defaultLoggerLevel))
+	if err != nil {
+		return nil, err
+	}
+
+	format := conf.String(loggerFormatConfigKey, textLoggerFormat)
+
+	var stdErrAppender Appender
+
+	switch format {
+		case textLoggerFormat: stdErrAppender = StdErrAppender()
+		case jsonLoggerFormat: stdErrAppender = NewJsonAppender(os.Stderr)
+		default: return nil, NewStackError("Invalid log format: %s - expected text or json", format)
+	}
+
+	filters := []*LevelFilterAppender{ NewLevelFilterAppender(level, stdErrAppender) }
+
+	if conf.Interface(loggerFileConfigPath, nil) != nil {
+		fileConfig := &RotatingFileAppenderConfig{}
+		if err := conf.Unmarshal(loggerFileConfigPath, fileConfig); err != nil { return nil, err }
+
+		fileAppender, err := NewRotatingFileAppender(fileConfig)
+		if err != nil { return nil, err }
+
+		if format == jsonLoggerFormat {
+			filters = append(filters, NewLevelFilterAppender(level, NewJsonAppender(fileAppender)))
+		} else {
+			filters = append(filters, NewLevelFilterAppender(level, fileAppender))
+		}
+	}
 
 	if conf.EnvironmentIs("prod") {
 		syslogAppender, err := NewSyslogAppender("", "", id)
@@ -319,25 +661,20 @@ func configureLogger(id string, conf *Configuration) ([]Appender, error) {
 			return nil, err
 		}
 
-		appenders = append(appenders, LevelFilter(Debug, syslogAppender))
+		filters = append(filters, NewLevelFilterAppender(level, syslogAppender))
 	}
 
-	return appenders, nil
-}
-
-func writePidFile(kernel *Kernel) error {
-	kernel.Pid = os.Getpid()
-	pidFile, err := os.Create(kernel.Configuration.PidFile)
-	if err != nil {
-		return NewStackError("Unable to start kernel - problem creating pid file %s - error: %v", kernel.Configuration.PidFile, err)
-	}
-	defer pidFile.Close()
+	conf.AddChangeListener(loggerLevelConfigKey, func(change *ConfigurationChange) error {
+		level, err := ParseLevel(conf.String(loggerLevelConfigKey, defaultLoggerLevel))
+		if err != nil { return err }
+		for _, filter := range filters { filter.SetLevel(level) }
+		return nil
+	})
 
-	if _, err := pidFile.Write([]byte(strconv.Itoa(kernel.Pid))); err != nil {
-		return NewStackError("Unable to start kernel - problem writing pid file %s - error: %v", kernel.Configuration.PidFile, err)
-	}
+	appenders := make([]Appender, len(filters))
+	for i := range filters { appenders[i] = filters[i] }
 
-	return nil
+	return appenders, nil
 }
 
 // This method will load the configuration file, start the kernel and then
@@ -361,8 +698,9 @@ func RunKernelAndListenForInterrupt(id string, addComponentsFunction func(kernel
 	return nil
 }
 
-// Call this from your main to create the kernel. After init kernel is called you must add
-// your components and then call kernel.Start()
+// Call this from your main to create the kernel. The components in the configuration file are added
+// first (see AddComponentsFromConfiguration) and then the add components function is called. The add
+// components function can be nil if all of the components are in the configuration file.
 func StartKernel(id string, configFileName string, addComponentsFunction func(kernel *Kernel)) (*Kernel, error) {
 
 	kernel, err := newKernel(id, configFileName)
@@ -370,7 +708,12 @@ func StartKernel(id string, configFileName string, addComponentsFunction func(ke
 		return nil, err
 	}
 
-	addComponentsFunction(kernel)
+	if err = kernel.AddComponentsFromConfiguration(); err != nil {
+		removePidFile(kernel)
+		return nil, err
+	}
+
+	if addComponentsFunction != nil { addComponentsFunction(kernel) }
 
     if err = kernel.Start(); err != nil {
 		return nil, err
@@ -379,25 +722,33 @@ func StartKernel(id string, configFileName string, addComponentsFunction func(ke
 	return kernel, nil
 }
 
-// ListenForInterrupt blocks until an interrupt signal is detected.
+// ListenForInterrupt blocks until an interrupt (SIGINT) or terminate (SIGTERM) signal is detected
+// and then stops the kernel. While waiting, a SIGHUP calls Reload on the kernel and a SIGUSR1 logs the
+// kernel diagnostics (see LogDiagnostics).
 func (self *Kernel) ListenForInterrupt() error {
-	quitChannel := make(chan bool)
-
-	// Register the interrupt listener.
-	interruptSignalChannel := make(chan os.Signal, 1)
-	signal.Notify(interruptSignalChannel, os.Interrupt)
-	go func() {
-		for sig := range interruptSignalChannel {
-			if sig == syscall.SIGINT {
-				quitChannel <- true
+
+	signalChannel := make(chan os.Signal, 1)
+	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1)
+	defer signal.Stop(signalChannel)
+
+	return self.listenForSignals(signalChannel)
+}
+
+func (self *Kernel) listenForSignals(signalChannel chan os.Signal) error {
+
+	for sig := range signalChannel {
+		switch sig {
+			case os.Interrupt, syscall.SIGTERM: {
+				self.Logf(Info, "Received signal: %v - stopping: %s", sig, self.Id)
+				return self.Stop()
+			}
+
+			case syscall.SIGHUP: {
+				self.Logf(Info, "Received signal: %v - reloading: %s", sig, self.Id)
+				if err := self.Reload(); err != nil { self.Logf(Error, "Unable to reload: %s - err: %v", self.Id, err) }
 			}
-		}
-	}()
 
-	// Block until we receive the stop notification.
-	select {
-		case <- quitChannel: {
-			return self.Stop()
+			case syscall.SIGUSR1: self.LogDiagnostics()
 		}
 	}
 
@@ -0,0 +1,291 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"fmt"
+	"strings"
+	"reflect"
+)
+
+// The dlconfig tag tells the kernel to set an exported field from the configuration before the components
+// are started. The tag is in the form of "path.to.key,default=value,required". Both options are optional.
+// If the value is not in the configuration, the default is used (an invalid default is an error even if the
+// value is set). If there is no default and the value is required, the kernel will not start. The errors for
+// all of the fields are returned in one error.
+//
+//    type MyComponent struct {
+//        Url string `dlconfig:"myComponent.url,required"`
+//        Timeout time.Duration `dlconfig:"myComponent.timeout,default=30s"`
+//        Hosts []string `dlconfig:"myComponent.hosts,default=a.example.com,b.example.com"`
+//        Retry RetryConfig `dlconfig:"myComponent.retry"`
+//    }
+//
+// Supported types are strings, ints, uints, floats, bools, time.Duration (strings like "30s" or numbers in
+// milliseconds), ByteSize (strings like "10MB" or numbers in bytes), time.Time (RFC3339), slices, maps with
+// string keys, pointers and nested structs. The fields of a nested struct are relative to the struct path
+// and the fields of a struct in a slice or map are relative to the element document. If a path starts with a ".", it is relative to the
+// component configuration path (see ConfigurableComponent) and "." is the configuration path itself.
+// The default must be the last option because it can contain commas.
+const (
+	configTagName = "dlconfig"
+	configTagRequired = "required"
+	configTagDefaultPrefix = "default="
+)
+
+// Components that are configured from a configuration path passed at runtime (e.g., NewCronSvc("cron.scheduled"))
+// implement this interface so dlconfig tags can be relative to the path.
+type ConfigurableComponent interface {
+	ConfigPath() string
+}
+
+type configTag struct {
+	path string
+	defaultValue string
+	hasDefault bool
+	required bool
+}
+
+// Parse a dlconfig tag. Everything after "default=" is the default value.
+func parseConfigTag(tag string) (*configTag, error) {
+
+	values := strings.Split(tag, commaStr)
+
+	parsed := &configTag{ path: strings.TrimSpace(values[0]) }
+
+	if len(parsed.path) == 0 { return nil, NewStackError("Invalid dlconfig tag: %s - the path is not set", tag) }
+
+	for i := 1; i < len(values); i++ {
+		option := strings.TrimSpace(values[i])
+
+		switch {
+			case option == configTagRequired: parsed.required = true
+			case strings.HasPrefix(option, configTagDefaultPrefix): {
+				parsed.hasDefault = true
+				parsed.defaultValue = strings.TrimPrefix(strings.TrimSpace(strings.Join(values[i:], commaStr)), configTagDefaultPrefix)
+				return parsed, nil
+			}
+			default: return nil, NewStackError("Invalid dlconfig tag: %s - unknown option: %s", tag, option)
+		}
+	}
+
+	return parsed, nil
+}
+
+// Set the dlconfig fields on all of the components. This does not stop at the first error.
+func (self *Kernel) configureComponents() error {
+
+	var errs []error
+
+	for _, component := range self.components { errs = append(errs, self.configureComponentStruct(component)...) }
+
+	return NewAggregateError(fmt.Sprintf("Unable to configure components: %s", self.Id), errs)
+}
+
+// Set the dlconfig fields on a component that is created on demand (see ComponentDefinition).
+func (self *Kernel) configureComponent(component Component) error {
+	return NewAggregateError(fmt.Sprintf("Unable to configure component: %s", component.componentId), self.configureComponentStruct(component))
+}
+
+func (self *Kernel) configureComponentStruct(component Component) []error {
+
+	componentValue, ok := componentStructValue(component.singleton)
+	if !ok { return nil }
+
+	var configPath string
+	if configurable, ok := component.singleton.(ConfigurableComponent); ok { configPath = configurable.ConfigPath() }
+
+	return self.configureStruct(component.componentId, configPath, nadaStr, componentValue)
+}
+
+// Set the dlconfig fields on a struct. The parent path is set if this is a nested struct.
+func (self *Kernel) configureStruct(componentId, configPath, parentPath string, structValue reflect.Value) []error {
+	binder := &configBinder{ name: fmt.Sprintf("Unable to configure component: %s", componentId), lookup: self.Configuration.configLookup }
+	return binder.bindStruct(configPath, parentPath, nadaStr, structValue)
+}
+
+// Sets the dlconfig fields on a struct from the values returned by the lookup (the full configuration path
+// is passed). The errors contain the field (e.g., "Jobs[1].Timeout") and the path of the invalid value.
+type configBinder struct {
+	name string
+	lookup func(path string) interface{}
+}
+
+func (self *configBinder) fieldError(fieldName, format string, args ...interface{}) error {
+	if len(fieldName) == 0 { return NewStackError("%s - %s", self.name, fmt.Sprintf(format, args...)) }
+	return NewStackError("%s - field: %s - %s", self.name, fieldName, fmt.Sprintf(format, args...))
+}
+
+// The field prefix is the name of the parent field (e.g., "Retry.").
+func (self *configBinder) bindStruct(configPath, parentPath, fieldPrefix string, structValue reflect.Value) []error {
+
+	var errs []error
+
+	structType := structValue.Type()
+
+	for i := 0; i < structType.NumField(); i++ {
+
+		structField := structType.Field(i)
+
+		tag, tagged := structField.Tag.Lookup(configTagName)
+		if !tagged { continue }
+
+		fieldName := fieldPrefix + structField.Name
+
+		parsedTag, err := parseConfigTag(tag)
+		if err != nil { errs = append(errs, self.fieldError(fieldName, "%v", err)); continue }
+
+		path, err := resolveConfigPath(configPath, parentPath, parsedTag.path)
+		if err != nil { errs = append(errs, self.fieldError(fieldName, "%v", err)); continue }
+
+		fieldValue := structValue.Field(i)
+		if !fieldValue.CanSet() { errs = append(errs, self.fieldError(fieldName, "path: %s - reason: field not exported", path)); continue }
+
+		value := self.lookup(path)
+
+		// Walk the nested structs. The fields are relative to the struct path.
+		if nestedType := structField.Type; isConfigStructOrPtr(nestedType) {
+
+			if value == nil && parsedTag.required { errs = append(errs, self.fieldError(fieldName, "path: %s - reason: required value not set", path)); continue }
+
+			if nestedType.Kind() == reflect.Ptr {
+				if fieldValue.IsNil() { fieldValue.Set(reflect.New(nestedType.Elem())) }
+				fieldValue = fieldValue.Elem()
+			}
+
+			errs = append(errs, self.bindStruct(configPath, path, fieldName + ".", fieldValue)...)
+			continue
+		}
+
+		// The default is checked even if the value is set, so an invalid default is not found only when the value is removed.
+		if parsedTag.hasDefault {
+			if err := setConfigValue(reflect.New(fieldValue.Type()).Elem(), parsedTag.defaultValue); err != nil { errs = append(errs, self.fieldError(fieldName, "path: %s - reason: invalid default - %v", path, err)); continue }
+		}
+
+		switch {
+			case value != nil: errs = append(errs, self.bindValue(path, fieldName, fieldValue, value)...)
+
+			case parsedTag.hasDefault: {
+				if err := setConfigValue(fieldValue, parsedTag.defaultValue); err != nil { errs = append(errs, self.fieldError(fieldName, "path: %s - reason: invalid default - %v", path, err)) }
+			}
+
+			case parsedTag.required: errs = append(errs, self.fieldError(fieldName, "path: %s - reason: required value not set", path))
+		}
+	}
+
+	return errs
+}
+
+// Set a value that is not a struct. The slices and maps of structs are walked - the element fields are
+// relative to the element (e.g., "jobs[1].timeout").
+func (self *configBinder) bindValue(path, fieldName string, target reflect.Value, value interface{}) []error {
+
+	targetType := target.Type()
+
+	if (targetType.Kind() != reflect.Slice && targetType.Kind() != reflect.Map) || !isConfigStructOrPtr(targetType.Elem()) {
+		if err := setConfigValue(target, value); err != nil { return []error{ self.fieldError(fieldName, "path: %s - reason: %v", path, err) } }
+		return nil
+	}
+
+	var errs []error
+
+	switch v := value.(type) {
+		case []interface{}: {
+			if targetType.Kind() != reflect.Slice { break }
+
+			slice := reflect.MakeSlice(targetType, len(v), len(v))
+			for i := range v {
+				errs = append(errs, self.bindElement(fmt.Sprintf("%s[%d]", path, i), fmt.Sprintf("%s[%d]", fieldName, i), slice.Index(i), v[i])...)
+			}
+			target.Set(slice)
+			return errs
+		}
+
+		case map[string]interface{}: {
+			if targetType.Kind() != reflect.Map || targetType.Key().Kind() != reflect.String { break }
+
+			m := reflect.MakeMap(targetType)
+			for key, item := range v {
+				element := reflect.New(targetType.Elem()).Elem()
+				errs = append(errs, self.bindElement(joinConfigPath(path, key), fmt.Sprintf("%s[%s]", fieldName, key), element, item)...)
+				m.SetMapIndex(reflect.ValueOf(key).Convert(targetType.Key()), element)
+			}
+			target.Set(m)
+			return errs
+		}
+	}
+
+	return []error{ self.fieldError(fieldName, "path: %s - reason: %v", path, configValueTypeError(targetType, value)) }
+}
+
+// Set the fields of a struct in a slice or map from the element document.
+func (self *configBinder) bindElement(path, fieldName string, target reflect.Value, value interface{}) []error {
+
+	doc, ok := value.(map[string]interface{})
+	if !ok { return []error{ self.fieldError(fieldName, "path: %s - reason: expected a document - received: %T", path, value) } }
+
+	if target.Kind() == reflect.Ptr {
+		target.Set(reflect.New(target.Type().Elem()))
+		target = target.Elem()
+	}
+
+	element := &configBinder{ name: self.name, lookup: configDocumentLookup(path, doc) }
+
+	return element.bindStruct(nadaStr, path, fieldName + ".", target)
+}
+
+// Returns the lookup for the values in an element document. The paths start with the element path.
+func configDocumentLookup(elementPath string, doc map[string]interface{}) func(path string) interface{} {
+	return func(path string) interface{} {
+
+		if path == elementPath { return doc }
+
+		var value interface{} = doc
+
+		for _, key := range strings.Split(strings.TrimPrefix(path, elementPath + "."), ".") {
+			current, ok := value.(map[string]interface{})
+			if !ok { return nil }
+			value = current[key]
+		}
+
+		return value
+	}
+}
+
+// Returns true if the type is a struct that is walked (time.Time is set from a string).
+func isConfigStruct(structType reflect.Type) bool { return structType.Kind() == reflect.Struct && structType != timeType }
+
+func isConfigStructOrPtr(structType reflect.Type) bool {
+	return isConfigStruct(structType) || (structType.Kind() == reflect.Ptr && isConfigStruct(structType.Elem()))
+}
+
+// Returns the full configuration path for a tag path.
+func resolveConfigPath(configPath, parentPath, path string) (string, error) {
+
+	if len(parentPath) > 0 { return joinConfigPath(parentPath, strings.TrimPrefix(path, ".")), nil }
+
+	if !strings.HasPrefix(path, ".") { return path, nil }
+
+	if len(configPath) == 0 { return nadaStr, fmt.Errorf("path: %s is relative, but the component does not have a configuration path (see ConfigurableComponent)", path) }
+
+	return joinConfigPath(configPath, strings.TrimPrefix(path, ".")), nil
+}
+
+func joinConfigPath(path, key string) string {
+	if len(key) == 0 { return path }
+	return fmt.Sprintf(confPathKeyPattern, path, key)
+}
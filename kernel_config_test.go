@@ -0,0 +1,110 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"time"
+	"strings"
+	"testing"
+)
+
+type testKernelConfigRetry struct {
+	Attempts int `dlconfig:"attempts,default=3"`
+	Backoff time.Duration `dlconfig:"backoff,required"`
+}
+
+type testKernelConfigComponent struct {
+	Url string `dlconfig:"testComponent.url,required"`
+	Port uint16 `dlconfig:"testComponent.port"`
+	Ratio float64 `dlconfig:"testComponent.ratio,default=0.5"`
+	Enabled bool `dlconfig:"testComponent.enabled,default=true"`
+	Timeout time.Duration `dlconfig:"testComponent.timeout,default=30s"`
+	Interval time.Duration `dlconfig:"testComponent.intervalInMs"`
+	Hosts []string `dlconfig:"testComponent.hosts,default=a.example.com, b.example.com"`
+	Tags []string `dlconfig:"testComponent.tags"`
+	Retry testKernelConfigRetry `dlconfig:"testComponent.retry"`
+	RetryPtr *testKernelConfigRetry `dlconfig:"testComponent.retry"`
+	Relative string `dlconfig:".url"`
+	configPath string
+}
+
+func (self *testKernelConfigComponent) ConfigPath() string { return self.configPath }
+
+func TestKernelConfigInject(t *testing.T) {
+
+	kernel, err := NewTestKernel("kernelConfig", map[string]interface{} {
+		"testComponent": map[string]interface{} {
+			"url": "http://localhost",
+			"port": 8080,
+			"timeout": "1m",
+			"intervalInMs": 250,
+			"tags": []string{ "x", "y" },
+			"retry": map[string]interface{} { "backoff": "2s" },
+		},
+	})
+
+	if err != nil { t.Errorf("TestKernelConfigInject new kernel is broken: %v", err); return }
+
+	component := &testKernelConfigComponent{ configPath: "testComponent" }
+	kernel.AddComponent("testKernelConfigComponent", component)
+
+	if err := kernel.Start(); err != nil { t.Errorf("TestKernelConfigInject start is broken: %v", err); return }
+
+	if component.Url != "http://localhost" || component.Relative != "http://localhost" { t.Errorf("TestKernelConfigInject is broken - url: %s - relative: %s", component.Url, component.Relative) }
+	if component.Port != 8080 || component.Ratio != 0.5 || !component.Enabled { t.Errorf("TestKernelConfigInject is broken - port: %d - ratio: %v - enabled: %t", component.Port, component.Ratio, component.Enabled) }
+	if component.Timeout != time.Minute || component.Interval != 250 * time.Millisecond { t.Errorf("TestKernelConfigInject is broken - timeout: %v - interval: %v", component.Timeout, component.Interval) }
+	if strings.Join(component.Hosts, ",") != "a.example.com,b.example.com" || strings.Join(component.Tags, ",") != "x,y" { t.Errorf("TestKernelConfigInject is broken - hosts: %v - tags: %v", component.Hosts, component.Tags) }
+	if component.Retry.Attempts != 3 || component.Retry.Backoff != 2 * time.Second { t.Errorf("TestKernelConfigInject is broken - retry: %+v", component.Retry) }
+	if component.RetryPtr == nil || component.RetryPtr.Backoff != 2 * time.Second { t.Errorf("TestKernelConfigInject is broken - retry pointer: %+v", component.RetryPtr) }
+
+	kernel.Stop()
+}
+
+func TestKernelConfigInjectErrors(t *testing.T) {
+
+	kernel, err := NewTestKernel("kernelConfig", map[string]interface{} {
+		"testComponent": map[string]interface{} {
+			"port": "not a number",
+			"ratio": true,
+			"timeout": 1.5,
+			"tags": "x",
+			"retry": map[string]interface{} { "attempts": 1.5 },
+		},
+	})
+
+	if err != nil { t.Errorf("TestKernelConfigInjectErrors new kernel is broken: %v", err); return }
+
+	kernel.AddComponent("testKernelConfigComponent", &testKernelConfigComponent{})
+
+	err = kernel.Start()
+	if err == nil { t.Errorf("TestKernelConfigInjectErrors is broken - expected an error"); return }
+
+	// Every problem must be reported in the one error.
+	for _, expected := range []string{
+		"field: Url - path: testComponent.url - reason: required value not set",
+		"field: Port - path: testComponent.port - reason: expected an integer",
+		"field: Ratio - path: testComponent.ratio - reason: unable to convert bool to float64",
+		"field: Retry.Attempts - path: testComponent.retry.attempts - reason: expected an integer",
+		"field: Retry.Backoff - path: testComponent.retry.backoff - reason: required value not set",
+		"field: Relative - path: .url is relative",
+	} {
+		if !strings.Contains(err.Error(), expected) { t.Errorf("TestKernelConfigInjectErrors is broken - expected: %s - received: %v", expected, err) }
+	}
+
+	if _, err := parseConfigTag(",required"); err == nil { t.Errorf("TestKernelConfigInjectErrors is broken - expected an empty path error") }
+	if _, err := parseConfigTag("a.b,unknown"); err == nil { t.Errorf("TestKernelConfigInjectErrors is broken - expected an unknown option error") }
+}
@@ -0,0 +1,306 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"bytes"
+	"strings"
+	"reflect"
+	"runtime"
+	"strconv"
+)
+
+type ComponentScope string
+
+// A singleton is created once and shared by everything it is injected into. A prototype is created
+// each time it is injected (one instance per field) and each time GetComponent is called.
+const (
+	SingletonScope = ComponentScope("singleton")
+	PrototypeScope = ComponentScope("prototype")
+)
+
+// Creates an instance of a component. The dlinject and dlconfig fields are set by the kernel after
+// the factory returns, so the factory should only create the struct.
+type ComponentDefinitionFactory func(kernel *Kernel) (interface{}, error)
+
+// A component that is created by the kernel. If the scope is not set, the component is a singleton.
+//
+// A singleton that is not lazy is created when the kernel starts and it is treated like any other
+// component. A lazy singleton is created, injected, configured and started the first time GetComponent
+// is called and stopped when the kernel is stopped. If a lazy singleton is injected (dlinject) into
+// another component, it is created when the kernel starts.
+//
+// Prototype instances are injected and configured, but the kernel does not call the start/stop methods.
+// Lazy singletons and prototypes are not autowired (set the component id in the dlinject tag). Their dlinject
+// fields are added to the dependency graph once an instance has been created (e.g., a prototype injected into
+// another component when the kernel starts), so the components they inject are started first and stopped after
+// them. The components a factory or start method looks up with GetComponent must be declared with AddDependency.
+// A cycle between them (e.g., two lazy singletons that inject each other or a lazy singleton that calls
+// GetComponent on itself when it is started) is an error when they are created, not when the kernel starts.
+type ComponentDefinition struct {
+	Id string
+	Scope ComponentScope
+	Factory ComponentDefinitionFactory
+	Lazy bool
+}
+
+// The definition with the lazy singleton instance. This is shared by the copies of the component.
+type componentDefinition struct {
+	ComponentDefinition
+	lock sync.Mutex
+	instance interface{}
+	instanceType reflect.Type // Set when the first instance is created (see dependencySingleton).
+	typeLock sync.Mutex
+}
+
+// Register a component definition. This method will panic if the id is empty, the factory is nil,
+// the scope is unknown or a prototype is marked as lazy.
+func (self *Kernel) AddComponentDefinition(definition ComponentDefinition) {
+
+	panicIfComponentIdNotSet(definition.Id)
+
+	if definition.Factory == nil { panic(fmt.Sprintf("kernel.AddComponentDefinition called with a nil factory for id: %s", definition.Id)) }
+
+	if len(definition.Scope) == 0 { definition.Scope = SingletonScope }
+
+	switch definition.Scope {
+		case SingletonScope:
+		case PrototypeScope: if definition.Lazy { panic(fmt.Sprintf("kernel.AddComponentDefinition called with a lazy prototype for id: %s - prototypes are always created on demand", definition.Id)) }
+		default: panic(fmt.Sprintf("kernel.AddComponentDefinition called with an invalid scope: %s - for id: %s", definition.Scope, definition.Id))
+	}
+
+	self.addComponent(Component{ componentId: definition.Id, definition: &componentDefinition{ ComponentDefinition: definition } })
+}
+
+// Returns true if the component is created on demand (a prototype or a lazy singleton that is not created).
+func (self *Component) onDemand() bool { return self.definition != nil && self.singleton == nil }
+
+// Create the singletons that are not lazy and the lazy singletons that are injected into other components.
+// This is called when the kernel starts, before the start order is calculated.
+func (self *Kernel) createDefinedComponents() error {
+
+	var errs []error
+
+	for i := range self.components {
+		component := self.components[i]
+		if !component.onDemand() || component.definition.Scope != SingletonScope || component.definition.Lazy { continue }
+		if err := self.createDefinedSingleton(component); err != nil { errs = append(errs, err) }
+	}
+
+	// The lazy singletons can be injected into other lazy singletons, so loop until nothing is created.
+	for created := true; created && len(errs) == 0; {
+		created = false
+
+		injected := make(map[string]bool)
+		for _, component := range self.components {
+			if component.singleton == nil { continue }
+			for _, componentId := range self.injectedComponentIds(component) { injected[componentId] = true }
+		}
+
+		for i := range self.components {
+			component := self.components[i]
+			if !component.onDemand() || component.definition.Scope != SingletonScope || !injected[component.componentId] { continue }
+			if err := self.createDefinedSingleton(component); err != nil { errs = append(errs, err); continue }
+			created = true
+		}
+	}
+
+	return NewAggregateError(fmt.Sprintf("Unable to create components: %s", self.Id), errs)
+}
+
+// Call the factory and replace the registered component with the instance. The instance is then
+// started with the other components.
+func (self *Kernel) createDefinedSingleton(component Component) error {
+
+	instance, err := self.callComponentFactory(component)
+	if err != nil { return err }
+
+	created := newComponent(component.componentId, instance)
+	created.definition = component.definition
+
+	for i := range self.components {
+		if self.components[i].componentId == component.componentId { self.components[i] = created }
+	}
+
+	self.Components[component.componentId] = created
+
+	return nil
+}
+
+// Returns an instance of a component that is created on demand. A prototype is created, injected and configured
+// each time this is called. A lazy singleton is also started the first time. The creating ids are the on-demand
+// components that are being injected or created (see enterCreating) when this is called. The lazy singletons and
+// prototypes are created outside of the dependency graph, so a cycle (e.g., two lazy singletons that inject each
+// other or a factory that calls GetComponent on itself) is detected here - without the check, a lazy singleton
+// would lock its definition twice and a prototype would recurse forever.
+func (self *Kernel) onDemandInstance(component Component, creating []string) (interface{}, error) {
+
+	for i, componentId := range creating {
+		if componentId == component.componentId {
+			return nil, NewStackError("Unable to create component: %s - reason: dependency cycle: %s -> %s", component.componentId, strings.Join(creating[i:], " -> "), componentId)
+		}
+	}
+
+	if component.definition.Scope == PrototypeScope { return self.newDefinedInstance(component, creating) }
+
+	definition := component.definition
+
+	definition.lock.Lock()
+	defer definition.lock.Unlock()
+
+	if definition.instance != nil { return definition.instance, nil }
+
+	instance, err := self.newDefinedInstance(component, creating)
+	if err != nil { return nil, err }
+
+	started := newComponent(component.componentId, instance)
+	started.definition = definition
+
+	// The start method is called with the creating ids, but they are not kept (the stop method is not creating anything).
+	starting := started
+	starting.creating = appendCreating(creating, component.componentId)
+
+	if err := self.startComponent(starting); err != nil { return nil, err }
+
+	self.lazyLock.Lock()
+	self.lazyStarted = append(self.lazyStarted, started)
+	self.lazyLock.Unlock()
+
+	definition.instance = instance
+
+	self.Logf(Debug, "Started lazy component: %s - type: %T", component.componentId, instance)
+
+	return instance, nil
+}
+
+// Call the factory and set the dlinject and dlconfig fields on the instance.
+func (self *Kernel) newDefinedInstance(component Component, creating []string) (interface{}, error) {
+
+	component.creating = appendCreating(creating, component.componentId)
+
+	instance, err := self.callComponentFactory(component)
+	if err != nil { return nil, err }
+
+	component.definition.typeLock.Lock()
+	if component.definition.instanceType == nil { component.definition.instanceType = reflect.TypeOf(instance) }
+	component.definition.typeLock.Unlock()
+
+	injectStartTime := time.Now()
+
+	created := newComponent(component.componentId, instance)
+	created.definition = component.definition
+	created.creating = component.creating
+
+	if err := self.injectComponent(created); err != nil { return nil, err }
+
+	if err := self.configureComponent(created); err != nil { return nil, err }
+
+	self.emitEvent(ComponentInjectedEvent, &created, time.Since(injectStartTime), nil)
+
+	return instance, nil
+}
+
+func (self *Kernel) callComponentFactory(component Component) (instance interface{}, err error) {
+
+	defer self.enterCreating(component.creating)()
+
+	defer func() {
+		if r := recover(); r != nil { err = NewStackError("Unable to create component: %s - reason: the factory panicked - problem: %v", component.componentId, r) }
+	}()
+
+	if instance, err = component.definition.Factory(self); err != nil { return nil, NewStackError("Unable to create component: %s - err: %v", component.componentId, err) }
+
+	if instance == nil { return nil, NewStackError("Unable to create component: %s - reason: the factory returned nil", component.componentId) }
+
+	return instance, nil
+}
+
+// Returns the components that were started, including the lazy singletons. If the kernel is not
+// started, the registered components are returned.
+func (self *Kernel) startedComponents() []Component {
+
+	components := self.startOrder
+	if components == nil { components = self.components }
+
+	self.lazyLock.Lock()
+	defer self.lazyLock.Unlock()
+
+	if len(self.lazyStarted) == 0 { return components }
+
+	return append(append(make([]Component, 0, len(components) + len(self.lazyStarted)), components...), self.lazyStarted...)
+}
+
+// Returns the lazy singletons that were started and clears the list (they are stopped by the caller).
+func (self *Kernel) takeLazyStarted() []Component {
+	self.lazyLock.Lock()
+	defer self.lazyLock.Unlock()
+	lazyStarted := self.lazyStarted
+	self.lazyStarted = nil
+	return lazyStarted
+}
+
+func appendCreating(creating []string, componentId string) []string {
+	return append(append(make([]string, 0, len(creating) + 1), creating...), componentId)
+}
+
+// Set the on-demand components the current goroutine is creating, so GetComponent (called by a factory or
+// a start method) can detect a cycle. The returned function restores the previous ids. The ids are kept per
+// goroutine because another goroutine calling GetComponent for the same lazy singleton must wait, not fail.
+func (self *Kernel) enterCreating(creating []string) func() {
+
+	if len(creating) == 0 { return func() { } }
+
+	goroutineId := currentGoroutineId()
+
+	self.creatingLock.Lock()
+	defer self.creatingLock.Unlock()
+
+	if self.creating == nil { self.creating = make(map[uint64][]string) }
+
+	previous, found := self.creating[goroutineId]
+	self.creating[goroutineId] = creating
+
+	return func() {
+		self.creatingLock.Lock()
+		defer self.creatingLock.Unlock()
+		if found { self.creating[goroutineId] = previous } else { delete(self.creating, goroutineId) }
+	}
+}
+
+// Returns the on-demand components the current goroutine is creating (see enterCreating).
+func (self *Kernel) creatingComponents() []string {
+
+	self.creatingLock.Lock()
+	defer self.creatingLock.Unlock()
+
+	if len(self.creating) == 0 { return nil }
+
+	return self.creating[currentGoroutineId()]
+}
+
+// Returns the id of the current goroutine. The first line of the stack is "goroutine 1 [running]:".
+func currentGoroutineId() uint64 {
+	buffer := make([]byte, 64)
+	buffer = buffer[:runtime.Stack(buffer, false)]
+	fields := bytes.Fields(buffer)
+	if len(fields) < 2 { return 0 }
+	goroutineId, _ := strconv.ParseUint(string(fields[1]), 10, 64)
+	return goroutineId
+}
@@ -0,0 +1,232 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"fmt"
+	"time"
+	"strings"
+	"testing"
+)
+
+type testDefinitionPrototype struct {
+	Name string `dlconfig:"definition.name,default=proto"`
+	Eager *testKernelOrderComponent `dlinject:"Eager"`
+}
+
+type testDefinitionUser struct {
+	testKernelOrderComponent
+	First *testDefinitionPrototype `dlinject:"Prototype"`
+	Second *testDefinitionPrototype `dlinject:"Prototype"`
+	Injected *testKernelOrderComponent `dlinject:"LazyInjected"`
+}
+
+// Looks up a component when it is started.
+type testDefinitionLookup struct {
+	id string
+	lookup string
+	calls *[]string
+}
+
+func (self *testDefinitionLookup) Start(kernel *Kernel) error {
+	if len(self.lookup) > 0 { kernel.GetComponent(self.lookup) }
+	*self.calls = append(*self.calls, "start:" + self.id)
+	return nil
+}
+
+func (self *testDefinitionLookup) Stop() error { *self.calls = append(*self.calls, "stop:" + self.id); return nil }
+
+type testDefinitionCycleA struct { B *testDefinitionCycleB `dlinject:"CycleB"` }
+type testDefinitionCycleB struct { A *testDefinitionCycleA `dlinject:"CycleA"` }
+
+func TestKernelComponentDefinitions(t *testing.T) {
+
+	var calls []string
+	created := make(map[string]int)
+
+	orderFactory := func(id string) ComponentDefinitionFactory {
+		return func(kernel *Kernel) (interface{}, error) {
+			created[id]++
+			return &testKernelOrderComponent{ id: id, calls: &calls }, nil
+		}
+	}
+
+	kernel, err := StartTestKernel("kernelDefinitions", nil, func(kernel *Kernel) {
+		kernel.AddComponent("User", &testDefinitionUser{ testKernelOrderComponent: testKernelOrderComponent{ id: "User", calls: &calls } })
+		kernel.AddComponentDefinition(ComponentDefinition{ Id: "Eager", Factory: orderFactory("Eager") })
+		kernel.AddComponentDefinition(ComponentDefinition{ Id: "Lazy", Factory: orderFactory("Lazy"), Lazy: true })
+		kernel.AddComponentDefinition(ComponentDefinition{ Id: "LazyInjected", Factory: orderFactory("LazyInjected"), Lazy: true })
+		kernel.AddComponentDefinition(ComponentDefinition{ Id: "Prototype", Scope: PrototypeScope, Factory: func(kernel *Kernel) (interface{}, error) {
+			created["Prototype"]++
+			return &testDefinitionPrototype{}, nil
+		}})
+	}, nil)
+
+	if err != nil { t.Errorf("TestKernelComponentDefinitions is broken: %v", err); return }
+
+	user := kernel.GetComponent("User").(*testDefinitionUser)
+
+	if user.First == nil || user.First == user.Second { t.Errorf("TestKernelComponentDefinitions is broken - prototype not created per field") }
+	if user.First.Name != "proto" || user.First.Eager != kernel.GetComponent("Eager") { t.Errorf("TestKernelComponentDefinitions is broken - prototype not injected/configured") }
+	if user.Injected == nil || user.Injected != kernel.GetComponent("LazyInjected") { t.Errorf("TestKernelComponentDefinitions is broken - injected lazy singleton not shared") }
+
+	if strings.Join(calls, ",") != "start:Eager,start:LazyInjected,start:User" { t.Errorf("TestKernelComponentDefinitions is broken - start order: %v", calls) }
+
+	if created["Lazy"] != 0 { t.Errorf("TestKernelComponentDefinitions is broken - lazy singleton created before use") }
+
+	lazy := kernel.GetComponent("Lazy")
+	if lazy != kernel.GetComponent("Lazy") || created["Lazy"] != 1 { t.Errorf("TestKernelComponentDefinitions is broken - lazy singleton created more than once: %d", created["Lazy"]) }
+
+	if kernel.GetComponent("Prototype") == kernel.GetComponent("Prototype") { t.Errorf("TestKernelComponentDefinitions is broken - prototype shared") }
+
+	if created["Prototype"] != 4 { t.Errorf("TestKernelComponentDefinitions is broken - prototypes created: %d", created["Prototype"]) }
+
+	calls = nil
+
+	if err := kernel.Stop(); err != nil { t.Errorf("TestKernelComponentDefinitions is broken - stop: %v", err) }
+
+	if strings.Join(calls, ",") != "stop:Lazy,stop:User,stop:LazyInjected,stop:Eager" { t.Errorf("TestKernelComponentDefinitions is broken - stop order: %v", calls) }
+}
+
+func TestKernelComponentDefinitionErrors(t *testing.T) {
+
+	kernel, _ := NewTestKernel("kernelDefinitionErrors", nil)
+
+	kernel.AddComponentDefinition(ComponentDefinition{ Id: "Failing", Factory: func(kernel *Kernel) (interface{}, error) { return nil, nil } })
+
+	if err := kernel.Start(); err == nil || !strings.Contains(err.Error(), "the factory returned nil") { t.Errorf("TestKernelComponentDefinitionErrors is broken - nil instance: %v", err) }
+
+	kernel, _ = NewTestKernel("kernelDefinitionErrors", nil)
+
+	kernel.AddComponentDefinition(ComponentDefinition{ Id: "Lazy", Lazy: true, Factory: func(kernel *Kernel) (interface{}, error) { panic("boom") } })
+
+	if err := kernel.Start(); err != nil { t.Errorf("TestKernelComponentDefinitionErrors is broken - start: %v", err); return }
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil { t.Errorf("TestKernelComponentDefinitionErrors is broken - GetComponent did not panic") }
+		}()
+		kernel.GetComponent("Lazy")
+	}()
+
+	kernel.Stop()
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil { t.Errorf("TestKernelComponentDefinitionErrors is broken - lazy prototype accepted") }
+		}()
+		kernel.AddComponentDefinition(ComponentDefinition{ Id: "LazyPrototype", Scope: PrototypeScope, Lazy: true, Factory: func(kernel *Kernel) (interface{}, error) { return nil, nil } })
+	}()
+}
+
+// The lazy singletons and prototypes are not in the dependency graph, so the cycles are detected when they are created.
+func TestKernelComponentDefinitionCycles(t *testing.T) {
+
+	for _, definition := range []ComponentDefinition{ { Lazy: true }, { Scope: PrototypeScope } } {
+
+		kernel, _ := NewTestKernel("kernelDefinitionCycles", nil)
+
+		a, b := definition, definition
+		a.Id, a.Factory = "CycleA", func(kernel *Kernel) (interface{}, error) { return &testDefinitionCycleA{}, nil }
+		b.Id, b.Factory = "CycleB", func(kernel *Kernel) (interface{}, error) { return &testDefinitionCycleB{}, nil }
+
+		kernel.AddComponentDefinition(a)
+		kernel.AddComponentDefinition(b)
+
+		if err := kernel.Start(); err != nil { t.Errorf("TestKernelComponentDefinitionCycles is broken - start: %v", err); continue }
+
+		func() {
+			defer func() {
+				if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "dependency cycle: CycleA -> CycleB -> CycleA") {
+					t.Errorf("TestKernelComponentDefinitionCycles is broken - scope: %s - lazy: %t - expected a cycle error: %v", definition.Scope, definition.Lazy, r)
+				}
+			}()
+			kernel.GetComponent("CycleA")
+		}()
+
+		// The lazy singleton lock is released.
+		func() {
+			defer func() { recover() }()
+			kernel.GetComponent("CycleB")
+		}()
+
+		kernel.Stop()
+	}
+}
+
+// The factories and start methods call GetComponent, so the cycles are found on the GetComponent path.
+func TestKernelComponentDefinitionLookupCycles(t *testing.T) {
+
+	var calls []string
+
+	lookupFactory := func(id, lookup string) ComponentDefinitionFactory {
+		return func(kernel *Kernel) (interface{}, error) { return &testDefinitionLookup{ id: id, lookup: lookup, calls: &calls }, nil }
+	}
+
+	for name, test := range map[string]struct { definitions []ComponentDefinition; cycle string } {
+		"factory": { []ComponentDefinition{ { Id: "Self", Lazy: true, Factory: func(kernel *Kernel) (interface{}, error) { return kernel.GetComponent("Self"), nil } } }, "Self -> Self" },
+		"start": { []ComponentDefinition{ { Id: "Self", Lazy: true, Factory: lookupFactory("Self", "Self") } }, "Self -> Self" },
+		"chain": { []ComponentDefinition{	{ Id: "A", Lazy: true, Factory: func(kernel *Kernel) (interface{}, error) { return kernel.GetComponent("B"), nil } },
+											{ Id: "B", Lazy: true, Factory: lookupFactory("B", "A") } }, "A -> B -> A" },
+	} {
+
+		kernel, _ := NewTestKernel("kernelDefinitionLookupCycles", nil)
+
+		for _, definition := range test.definitions { kernel.AddComponentDefinition(definition) }
+
+		if err := kernel.Start(); err != nil { t.Errorf("TestKernelComponentDefinitionLookupCycles is broken - %s start: %v", name, err); continue }
+
+		// Called twice to make sure the lazy singleton lock is released.
+		for i := 0; i < 2; i++ {
+			recovered := make(chan interface{}, 1)
+
+			go func() {
+				defer func() { recovered <- recover() }()
+				kernel.GetComponent(test.definitions[0].Id)
+			}()
+
+			select {
+				case r := <- recovered: {
+					if r == nil || !strings.Contains(fmt.Sprint(r), "dependency cycle: " + test.cycle) { t.Errorf("TestKernelComponentDefinitionLookupCycles is broken - %s - expected a cycle error: %v", name, r) }
+				}
+				case <- time.After(5 * time.Second): t.Errorf("TestKernelComponentDefinitionLookupCycles is broken - %s - GetComponent deadlocked", name); return
+			}
+		}
+
+		kernel.Stop()
+	}
+}
+
+// A lazy singleton used by another component is stopped after it, even though it was started last.
+func TestKernelLazyDependencyStopOrder(t *testing.T) {
+
+	var calls []string
+
+	kernel, err := StartTestKernel("kernelLazyDependencyStopOrder", nil, func(kernel *Kernel) {
+		kernel.AddComponent("User", &testDefinitionLookup{ id: "User", lookup: "Lazy", calls: &calls })
+		kernel.AddComponentDefinition(ComponentDefinition{ Id: "Lazy", Lazy: true, Factory: func(kernel *Kernel) (interface{}, error) {
+			return &testKernelOrderComponent{ id: "Lazy", calls: &calls }, nil
+		}})
+		kernel.AddDependency("User", "Lazy")
+	}, nil)
+
+	if err != nil { t.Errorf("TestKernelLazyDependencyStopOrder is broken: %v", err); return }
+
+	if err := kernel.Stop(); err != nil { t.Errorf("TestKernelLazyDependencyStopOrder is broken - stop: %v", err) }
+
+	if strings.Join(calls, ",") != "start:Lazy,start:User,stop:User,stop:Lazy" { t.Errorf("TestKernelLazyDependencyStopOrder is broken - order: %v", calls) }
+}
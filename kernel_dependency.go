@@ -0,0 +1,216 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"fmt"
+	"strings"
+	"reflect"
+)
+
+// Components that look up other components at runtime (e.g., kernel.GetComponent in the Start method)
+// should implement this interface so the kernel knows to start the components they use first. The
+// dependencies declared through `dlinject` tags (including autowired fields) are found automatically
+// and do not need to be returned.
+type DependentComponent interface {
+	DependsOn(kernel *Kernel) []string
+}
+
+// Explicitly declare that a component depends on other components. The dependencies are started
+// before the component and stopped after it. The component does not need to be registered when this
+// is called, but all of the ids must be registered before the kernel is started. This method will panic
+// if the component id is empty.
+func (self *Kernel) AddDependency(componentId string, dependsOnComponentIds ...string) {
+	panicIfComponentIdNotSet(componentId)
+	self.dependencies[componentId] = append(self.dependencies[componentId], dependsOnComponentIds...)
+}
+
+// Returns the component id referenced by a dlinject tag. The MongoDataSource tag is in the
+// form of "componentId,dbName,collectionName" so only the first value is used.
+func injectTagComponentId(tag string) string { return strings.TrimSpace(strings.Split(tag, commaStr)[0]) }
+
+// Returns the struct value of a component or false if the component is not a pointer to a struct.
+func componentStructValue(singleton interface{}) (reflect.Value, bool) {
+	value := reflect.ValueOf(singleton)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct { return reflect.Value{}, false }
+	return value.Elem(), true
+}
+
+// Returns the ids of the components this component depends on. This includes the dlinject
+// tags, the explicit declarations and the DependentComponent interface (in that order).
+func (self *Kernel) componentDependencies(component Component) []string {
+
+	dependencies := self.injectedComponentIds(component)
+
+	dependencies = append(dependencies, self.dependencies[component.componentId]...)
+
+	if dependent, ok := component.singleton.(DependentComponent); ok {
+		dependencies = append(dependencies, dependent.DependsOn(self)...)
+	}
+
+	// Remove the duplicates, but keep the order.
+	seen := make(map[string]bool)
+	unique := make([]string, 0, len(dependencies))
+	for _, dependencyId := range dependencies {
+		if len(dependencyId) == 0 || seen[dependencyId] { continue }
+		seen[dependencyId] = true
+		unique = append(unique, dependencyId)
+	}
+
+	return unique
+}
+
+// Returns the ids of the components referenced by the dlinject tags (including autowired fields).
+func (self *Kernel) injectedComponentIds(component Component) []string {
+
+	componentValue, ok := componentStructValue(component.dependencySingleton())
+	if !ok { return nil }
+
+	var componentIds []string
+
+	componentType := componentValue.Type()
+	for i := 0; i < componentType.NumField(); i++ {
+		structField := componentType.Field(i)
+
+		tag, tagged := structField.Tag.Lookup(injectTagName)
+		if !tagged { continue }
+
+		if structField.Type.String() == injectMongoDataSourceName { componentIds = append(componentIds, injectTagComponentId(tag)); continue }
+
+		// The autowire errors are returned when the components are injected.
+		if injectComponentId, err := self.injectFieldComponentId(component.componentId, structField, tag); err == nil {
+			componentIds = append(componentIds, injectComponentId)
+		}
+	}
+
+	return componentIds
+}
+
+// Returns the components in the order they must be started. Dependencies are always started
+// before the components that use them, otherwise the registration order is kept. The components
+// that are created on demand are not started by the kernel, but the components they inject are
+// (see ComponentDefinition). An error is
+// returned if there is a cycle or if a dependency is not registered.
+func (self *Kernel) componentStartOrder() ([]Component, error) {
+
+	dependencies := make(map[string][]string)
+
+	var missing []string
+	for _, component := range self.components {
+		dependencies[component.componentId] = self.componentDependencies(component)
+		for _, dependencyId := range dependencies[component.componentId] {
+			if _, found := self.Components[dependencyId]; !found {
+				missing = append(missing, fmt.Sprintf("%s depends on: %s", component.componentId, dependencyId))
+			}
+		}
+	}
+
+	if len(missing) > 0 { return nil, NewStackError("Unable to start kernel - missing component dependencies - %s", strings.Join(missing, " - ")) }
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make(map[string]int)
+	order := make([]Component, 0, len(self.components))
+	var path []string
+
+	var visit func(componentId string) error
+	visit = func(componentId string) error {
+		switch state[componentId] {
+			case visited: return nil
+			case visiting: {
+				// Find where the cycle starts in the current path.
+				start := 0
+				for i := range path { if path[i] == componentId { start = i; break } }
+				return NewStackError("Unable to start kernel - component dependency cycle: %s", strings.Join(append(path[start:], componentId), " -> "))
+			}
+		}
+
+		state[componentId] = visiting
+		path = append(path, componentId)
+
+		for _, dependencyId := range dependencies[componentId] {
+			if err := visit(dependencyId); err != nil { return err }
+		}
+
+		path = path[:len(path)-1]
+		state[componentId] = visited
+		if component := self.Components[componentId]; !component.onDemand() { order = append(order, component) }
+		return nil
+	}
+
+	for _, component := range self.components {
+		if err := visit(component.componentId); err != nil { return nil, err }
+	}
+
+	return order, nil
+}
+
+// Returns the started components in the order they must be stopped in (the reverse of the returned order). The
+// lazy singletons are started when they are first used, so they are sorted with the other components: the
+// components a component depends on (directly or through a component that is not started, e.g., a prototype)
+// are before it, otherwise the start order is kept. The cycles were found when the components were started.
+func (self *Kernel) componentStopOrder(started []Component) []Component {
+
+	startedComponents := make(map[string]Component, len(started))
+	for _, component := range started { startedComponents[component.componentId] = component }
+
+	visited := make(map[string]bool)
+	order := make([]Component, 0, len(started))
+
+	var visit func(componentId string)
+	visit = func(componentId string) {
+		if visited[componentId] { return }
+		visited[componentId] = true
+
+		component, found := startedComponents[componentId]
+		if !found { component = self.Components[componentId] }
+
+		for _, dependencyId := range self.componentDependencies(component) { visit(dependencyId) }
+
+		if found { order = append(order, component) }
+	}
+
+	for _, component := range started { visit(component.componentId) }
+
+	return order
+}
+
+// Returns the instance used to find the dlinject fields on a component. A component that is created on demand
+// does not have an instance, so an empty instance of the type the factory returned is used. If nothing has been
+// created, nil is returned and only the explicit dependencies are known (see ComponentDefinition).
+func (self *Component) dependencySingleton() interface{} {
+
+	if !self.onDemand() { return self.singleton }
+
+	self.definition.typeLock.Lock()
+	instanceType := self.definition.instanceType
+	self.definition.typeLock.Unlock()
+
+	if instanceType == nil || instanceType.Kind() != reflect.Ptr { return nil }
+
+	return reflect.New(instanceType.Elem()).Interface()
+}
+
+func componentIds(components []Component) []string {
+	ids := make([]string, len(components))
+	for i := range components { ids[i] = components[i].componentId }
+	return ids
+}
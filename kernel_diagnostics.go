@@ -0,0 +1,81 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"fmt"
+	"bytes"
+	"runtime"
+)
+
+// Log the kernel diagnostics. This includes the registered components, the configuration values
+// that were read and their sources (see Configuration.Dump), the recent entries
+// in the LogCache (see CapLogCache) and the stacks of all of the goroutines. This is called
+// when the process receives a SIGUSR1 (see ListenForInterrupt).
+func (self *Kernel) LogDiagnostics() {
+
+	// Copy the cache before we add the diagnostics logs.
+	recentLogs := Cache.Copy()
+
+	self.Logf(Info, "Diagnostics: %s - version: %s - pid: %d - goroutines: %d", self.Id, self.Configuration.Version, self.Pid, runtime.NumGoroutine())
+
+	self.Logf(Info, "Diagnostics - components:\n%s", self.componentDiagnostics())
+
+	self.Logf(Info, "Diagnostics - configuration:\n%s", self.Configuration.DumpString())
+
+	var workers bytes.Buffer
+	for _, worker := range self.Workers() { workers.WriteString(fmt.Sprintf("\t%v\n", worker)) }
+	self.Logf(Info, "Diagnostics - workers:\n%s", workers.String())
+
+	var recent bytes.Buffer
+	for _, log := range recentLogs { recent.WriteString(FormatLog(log)) }
+	self.Logf(Info, "Diagnostics - recent logs: %d\n%s", len(recentLogs), recent.String())
+
+	self.Logf(Info, "Diagnostics - goroutine stacks:\n%s", goroutineStacks())
+}
+
+func (self *Kernel) componentDiagnostics() string {
+
+	components := self.startedComponents()
+
+	var buffer bytes.Buffer
+	for _, component := range components {
+		buffer.WriteString(fmt.Sprintf(	"\t%s - type: %T - start: %s - stop: %s\n",
+										component.componentId,
+										component.singleton,
+										lifecycleDescription(component.startMethodName, component.startInterfaceName),
+										lifecycleDescription(component.stopMethodName, component.stopInterfaceName)))
+	}
+
+	return buffer.String()
+}
+
+func lifecycleDescription(methodName, interfaceName string) string {
+	if len(methodName) == 0 { return "none" }
+	if len(interfaceName) == 0 { return methodName }
+	return fmt.Sprintf("%s (%s)", methodName, interfaceName)
+}
+
+// Returns the stacks of all of the goroutines.
+func goroutineStacks() []byte {
+	buffer := make([]byte, 1 << 16)
+	for {
+		size := runtime.Stack(buffer, true)
+		if size < len(buffer) { return buffer[:size] }
+		buffer = make([]byte, len(buffer) * 2)
+	}
+}
@@ -0,0 +1,93 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"time"
+)
+
+type KernelEventType string
+
+// The kernel events. The component events have the component id and the component set.
+const (
+	ComponentRegisteredEvent = KernelEventType("componentRegistered")
+	ComponentInjectedEvent = KernelEventType("componentInjected")
+	ComponentStartingEvent = KernelEventType("componentStarting")
+	ComponentStartedEvent = KernelEventType("componentStarted")
+	ComponentStartFailedEvent = KernelEventType("componentStartFailed")
+	ComponentStoppingEvent = KernelEventType("componentStopping")
+	ComponentStoppedEvent = KernelEventType("componentStopped")
+	ComponentStopFailedEvent = KernelEventType("componentStopFailed")
+
+	KernelStartingEvent = KernelEventType("kernelStarting")
+	KernelReadyEvent = KernelEventType("kernelReady")
+	KernelStartFailedEvent = KernelEventType("kernelStartFailed")
+	KernelStoppingEvent = KernelEventType("kernelStopping")
+	KernelStoppedEvent = KernelEventType("kernelStopped")
+)
+
+// The event passed to the listeners. The elapsed time is set on the events that end a step (e.g.,
+// ComponentStartedEvent is the time the start method took and KernelReadyEvent is the time the kernel
+// took to start). The error is set on the failed events and on KernelStoppedEvent if the kernel did
+// not stop cleanly.
+type KernelEvent struct {
+	Type KernelEventType
+	Kernel *Kernel
+	ComponentId string
+	Component interface{}
+	Time time.Time
+	Elapsed time.Duration
+	Err error
+}
+
+// The listener is called synchronously, so it blocks the kernel until it returns. A panic in a listener
+// is logged and ignored.
+type KernelEventListener func(event *KernelEvent)
+
+// Add an event listener. The listeners are called in the order they are added.
+func (self *Kernel) AddEventListener(listener KernelEventListener) {
+	if listener == nil { panic("kernel.AddEventListener called with a nil listener") }
+	self.listenerLock.Lock()
+	defer self.listenerLock.Unlock()
+	self.listeners = append(self.listeners, listener)
+}
+
+// Send the event to the listeners.
+func (self *Kernel) emitEvent(eventType KernelEventType, component *Component, elapsed time.Duration, err error) {
+
+	self.listenerLock.Lock()
+	listeners := self.listeners
+	self.listenerLock.Unlock()
+
+	if len(listeners) == 0 { return }
+
+	event := &KernelEvent{ Type: eventType, Kernel: self, Time: time.Now(), Elapsed: elapsed, Err: err }
+
+	if component != nil {
+		event.ComponentId = component.componentId
+		event.Component = component.singleton
+	}
+
+	for _, listener := range listeners { self.callEventListener(listener, event) }
+}
+
+func (self *Kernel) callEventListener(listener KernelEventListener, event *KernelEvent) {
+	defer func() {
+		if r := recover(); r != nil { self.Logf(Error, "Kernel event listener panicked - event: %s - component: %s - problem: %v", event.Type, event.ComponentId, r) }
+	}()
+	listener(event)
+}
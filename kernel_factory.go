@@ -0,0 +1,221 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"reflect"
+	"strings"
+)
+
+// The components can be created from the "components" array in the configuration file. Each entry
+// has the component id, the type (the name the factory is registered with), an optional configuration
+// path and an optional list of component ids it depends on.
+//
+//    "components": [
+//        { "id": "MongoDb", "type": "mongo", "configPath": "mongoDb.data" },
+//        { "id": "DistributedLock", "type": "mongoDistributedLock", "configPath": "distributedLock" },
+//        { "id": "CronSvc", "type": "cron", "configPath": "cron.scheduled", "dependsOn": [ "MyComponent" ] },
+//        { "id": "HttpServer", "type": "httpServer" }
+//    ]
+//
+// The components are registered in the order they are listed, before the components added in code (see
+// StartKernel). The built-in types are "mongo", "mongoDistributedLock", "cron" and "httpServer". Register
+// your own types with RegisterComponentFactory (usually in an init function).
+const (
+	componentsConfigPath = "components"
+
+	MongoComponentType = "mongo"
+	MongoDistributedLockComponentType = "mongoDistributedLock"
+	CronSvcComponentType = "cron"
+	HttpServerComponentType = "httpServer"
+)
+
+// The definition of a component in the configuration file.
+type ComponentConfig struct {
+	Id string
+	Type string
+	ConfigPath string
+	DependsOn []string
+}
+
+// Creates a component from the definition. The kernel is not started when this is called, so the
+// factory should only create the struct (and validate the definition). Use the Start method to
+// access other components.
+type ComponentFactory func(kernel *Kernel, config *ComponentConfig) (interface{}, error)
+
+var componentFactories = struct {
+	sync.RWMutex
+	factories map[string]ComponentFactory
+}{ factories: make(map[string]ComponentFactory) }
+
+// Register a component factory. This method will panic if the type name is empty, the factory is nil
+// or if a factory is already registered with the type name.
+func RegisterComponentFactory(typeName string, factory ComponentFactory) {
+
+	if len(typeName) == 0 { panic("RegisterComponentFactory called with an empty type name") }
+	if factory == nil { panic(fmt.Sprintf("RegisterComponentFactory called with a nil factory for type: %s", typeName)) }
+
+	componentFactories.Lock()
+	defer componentFactories.Unlock()
+
+	if _, found := componentFactories.factories[typeName]; found { panic(fmt.Sprintf("RegisterComponentFactory called twice for type: %s", typeName)) }
+
+	componentFactories.factories[typeName] = factory
+}
+
+func lookupComponentFactory(typeName string) (ComponentFactory, bool) {
+	componentFactories.RLock()
+	defer componentFactories.RUnlock()
+	factory, found := componentFactories.factories[typeName]
+	return factory, found
+}
+
+// Returns the registered type names (sorted).
+func componentFactoryTypes() []string {
+	componentFactories.RLock()
+	defer componentFactories.RUnlock()
+
+	types := make([]string, 0, len(componentFactories.factories))
+	for typeName := range componentFactories.factories { types = append(types, typeName) }
+	sort.Strings(types)
+	return types
+}
+
+// Create and register the components in the "components" array in the configuration. This is called
+// by StartKernel. This does not stop at the first error, all of the errors are returned in one error.
+func (self *Kernel) AddComponentsFromConfiguration() error {
+
+	entries := self.Configuration.List(componentsConfigPath, nil)
+
+	var errs []error
+
+	for i := range entries {
+
+		config, err := componentConfigFromEntry(i, entries[i])
+		if err != nil { errs = append(errs, err); continue }
+
+		if self.HasComponent(config.Id) { errs = append(errs, NewStackError("Unable to add component: %s - reason: the id is already registered", config.Id)); continue }
+
+		factory, found := lookupComponentFactory(config.Type)
+		if !found {
+			errs = append(errs, NewStackError("Unable to add component: %s - reason: unknown type: %s - registered types: %s", config.Id, config.Type, strings.Join(componentFactoryTypes(), ", ")))
+			continue
+		}
+
+		singleton, err := factory(self, config)
+		if err != nil { errs = append(errs, NewStackError("Unable to add component: %s - type: %s - err: %v", config.Id, config.Type, err)); continue }
+
+		if singleton == nil { errs = append(errs, NewStackError("Unable to add component: %s - type: %s - reason: the factory returned nil", config.Id, config.Type)); continue }
+
+		self.AddComponent(config.Id, singleton)
+
+		if len(config.DependsOn) > 0 { self.AddDependency(config.Id, config.DependsOn...) }
+
+		self.Logf(Debug, "Added component: %s - type: %s - config path: %s", config.Id, config.Type, config.ConfigPath)
+	}
+
+	return NewAggregateError(fmt.Sprintf("Unable to add components from configuration: %s", self.Id), errs)
+}
+
+func componentConfigFromEntry(index int, entry interface{}) (*ComponentConfig, error) {
+
+	doc, ok := entry.(map[string]interface{})
+	if !ok { return nil, NewStackError("Invalid component definition: %s[%d] - reason: expected a json document", componentsConfigPath, index) }
+
+	config := &ComponentConfig{}
+
+	for _, field := range []struct { name string; target interface{}; required bool } {
+		{ "id", &config.Id, true },
+		{ "type", &config.Type, true },
+		{ "configPath", &config.ConfigPath, false },
+		{ "dependsOn", &config.DependsOn, false },
+	} {
+		value, found := doc[field.name]
+		if !found || value == nil {
+			if field.required { return nil, NewStackError("Invalid component definition: %s[%d] - reason: %s not set", componentsConfigPath, index, field.name) }
+			continue
+		}
+
+		if err := setConfigValue(reflect.ValueOf(field.target).Elem(), value); err != nil {
+			return nil, NewStackError("Invalid component definition: %s[%d] - field: %s - reason: %v", componentsConfigPath, index, field.name, err)
+		}
+	}
+
+	if len(config.Id) == 0 || len(config.Type) == 0 { return nil, NewStackError("Invalid component definition: %s[%d] - reason: id and type must not be empty", componentsConfigPath, index) }
+
+	return config, nil
+}
+
+// Set the dlconfig fields on the target struct. The paths in the struct are relative to the path passed.
+func (self *Kernel) configureFromPath(componentId, path string, target interface{}) error {
+	targetValue, ok := componentStructValue(target)
+	if !ok { return NewStackError("Unable to configure component: %s - reason: target must be a struct pointer", componentId) }
+	return NewAggregateError(fmt.Sprintf("Unable to configure component: %s - path: %s", componentId, path), self.configureStruct(componentId, nadaStr, path, targetValue))
+}
+
+func requireConfigPath(config *ComponentConfig) error {
+	if len(config.ConfigPath) == 0 { return fmt.Errorf("configPath not set") }
+	return nil
+}
+
+// The configuration for a distributed lock created by the factory.
+type mongoDistributedLockConfig struct {
+	LockId string `dlconfig:"lockId,required"`
+	MongoComponentId string `dlconfig:"mongoComponentId,required"`
+	DbName string `dlconfig:"dbName,required"`
+	CollectionName string `dlconfig:"collectionName,required"`
+	HeartbeatFreqInSec int `dlconfig:"heartbeatFreqInSec,default=1"`
+	LockCheckFreqInSec int `dlconfig:"lockCheckFreqInSec,default=1"`
+	LockTimeoutInSec int `dlconfig:"lockTimeoutInSec,default=30"`
+	HistoryTimeoutInSec int `dlconfig:"historyTimeoutInSec,default=0"`
+}
+
+func init() {
+
+	RegisterComponentFactory(MongoComponentType, func(kernel *Kernel, config *ComponentConfig) (interface{}, error) {
+		if err := requireConfigPath(config); err != nil { return nil, err }
+		return NewMongoFromConfigPath(config.Id, config.ConfigPath), nil
+	})
+
+	RegisterComponentFactory(MongoDistributedLockComponentType, func(kernel *Kernel, config *ComponentConfig) (interface{}, error) {
+		if err := requireConfigPath(config); err != nil { return nil, err }
+
+		lockConfig := &mongoDistributedLockConfig{}
+		if err := kernel.configureFromPath(config.Id, config.ConfigPath, lockConfig); err != nil { return nil, err }
+
+		return NewMongoDistributedLock(	lockConfig.LockId,
+										lockConfig.MongoComponentId,
+										lockConfig.DbName,
+										lockConfig.CollectionName,
+										lockConfig.HeartbeatFreqInSec,
+										lockConfig.LockCheckFreqInSec,
+										lockConfig.LockTimeoutInSec,
+										lockConfig.HistoryTimeoutInSec), nil
+	})
+
+	RegisterComponentFactory(CronSvcComponentType, func(kernel *Kernel, config *ComponentConfig) (interface{}, error) {
+		if err := requireConfigPath(config); err != nil { return nil, err }
+		return NewCronSvc(config.ConfigPath), nil
+	})
+
+	RegisterComponentFactory(HttpServerComponentType, func(kernel *Kernel, config *ComponentConfig) (interface{}, error) {
+		return NewHttpServer(), nil
+	})
+}
@@ -0,0 +1,112 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"strings"
+	"testing"
+)
+
+type testFactoryComponent struct {
+	testKernelOrderComponent
+	Name string `dlconfig:".name,required"`
+	configPath string
+}
+
+func (self *testFactoryComponent) ConfigPath() string { return self.configPath }
+
+var testFactoryCalls []string
+
+func init() {
+	RegisterComponentFactory("testFactoryComponent", func(kernel *Kernel, config *ComponentConfig) (interface{}, error) {
+		return &testFactoryComponent{ testKernelOrderComponent: testKernelOrderComponent{ id: config.Id, calls: &testFactoryCalls }, configPath: config.ConfigPath }, nil
+	})
+}
+
+func TestKernelComponentsFromConfiguration(t *testing.T) {
+
+	testFactoryCalls = nil
+
+	kernel, err := StartTestKernel("kernelFactory", map[string]interface{} {
+		"factoryA": map[string]interface{} { "name": "a" },
+		"factoryB": map[string]interface{} { "name": "b" },
+		"components": []interface{} {
+			map[string]interface{} { "id": "B", "type": "testFactoryComponent", "configPath": "factoryB", "dependsOn": []string{ "A" } },
+			map[string]interface{} { "id": "A", "type": "testFactoryComponent", "configPath": "factoryA" },
+			map[string]interface{} { "id": "MongoDb", "type": MongoComponentType, "configPath": "mongoDb.testDb" },
+		},
+	}, nil, map[string]interface{} { "MongoDb": &testKernelOrderComponent{ id: "MongoDb", calls: &testFactoryCalls } })
+
+	if err != nil { t.Errorf("TestKernelComponentsFromConfiguration is broken: %v", err); return }
+
+	if name := kernel.GetComponent("B").(*testFactoryComponent).Name; name != "b" { t.Errorf("TestKernelComponentsFromConfiguration is broken - config not set - name: %s", name) }
+
+	if strings.Join(testFactoryCalls, ",") != "start:A,start:B,start:MongoDb" { t.Errorf("TestKernelComponentsFromConfiguration is broken - start order: %v", testFactoryCalls) }
+
+	kernel.Stop()
+
+	// The built-in factories.
+	kernel2, _ := NewTestKernel("kernelFactory", map[string]interface{} {
+		"lock": map[string]interface{} { "lockId": "testLock", "mongoComponentId": "MongoDb", "dbName": "test", "collectionName": "locks" },
+		"components": []interface{} {
+			map[string]interface{} { "id": "MongoDb", "type": MongoComponentType, "configPath": "mongoDb.testDb" },
+			map[string]interface{} { "id": "DistributedLock", "type": MongoDistributedLockComponentType, "configPath": "lock" },
+			map[string]interface{} { "id": "CronSvc", "type": CronSvcComponentType, "configPath": "cron.scheduled" },
+			map[string]interface{} { "id": "HttpServer", "type": HttpServerComponentType },
+		},
+	})
+
+	if err := kernel2.AddComponentsFromConfiguration(); err != nil { t.Errorf("TestKernelComponentsFromConfiguration is broken - built-ins: %v", err); return }
+
+	if _, ok := kernel2.GetComponent("MongoDb").(*Mongo); !ok { t.Errorf("TestKernelComponentsFromConfiguration is broken - mongo not created") }
+	if lock, ok := kernel2.GetComponent("DistributedLock").(*MongoDistributedLock); !ok || lock.LockId() != "testLock" { t.Errorf("TestKernelComponentsFromConfiguration is broken - lock not created") }
+	if _, ok := kernel2.GetComponent("CronSvc").(*CronSvc); !ok { t.Errorf("TestKernelComponentsFromConfiguration is broken - cron not created") }
+	if _, ok := kernel2.GetComponent("HttpServer").(*HttpServer); !ok { t.Errorf("TestKernelComponentsFromConfiguration is broken - http server not created") }
+}
+
+func TestKernelComponentsFromConfigurationErrors(t *testing.T) {
+
+	kernel, _ := NewTestKernel("kernelFactory", map[string]interface{} {
+		"components": []interface{} {
+			map[string]interface{} { "id": "A", "type": "unknownType" },
+			map[string]interface{} { "type": "testFactoryComponent" },
+			map[string]interface{} { "id": "B", "type": "testFactoryComponent", "dependsOn": "C, D" },
+			map[string]interface{} { "id": "B", "type": "testFactoryComponent" },
+			map[string]interface{} { "id": "MongoDb", "type": MongoComponentType },
+			map[string]interface{} { "id": "DistributedLock", "type": MongoDistributedLockComponentType, "configPath": "missing" },
+			"notADocument",
+		},
+	})
+
+	err := kernel.AddComponentsFromConfiguration()
+	if err == nil { t.Errorf("TestKernelComponentsFromConfigurationErrors is broken - expected an error"); return }
+
+	for _, expected := range []string{
+		"Unable to add component: A - reason: unknown type: unknownType - registered types: cron, httpServer, mongo, mongoDistributedLock",
+		"components[1] - reason: id not set",
+		"Unable to add component: B - reason: the id is already registered",
+		"Unable to add component: MongoDb - type: mongo - err: configPath not set",
+		"field: LockId - path: missing.lockId - reason: required value not set",
+		"components[6] - reason: expected a json document",
+	} {
+		if !strings.Contains(err.Error(), expected) { t.Errorf("TestKernelComponentsFromConfigurationErrors is broken - expected: %s - received: %v", expected, err) }
+	}
+
+	// The valid definition is still added.
+	if !kernel.HasComponent("B") { t.Errorf("TestKernelComponentsFromConfigurationErrors is broken - B was not added") }
+	if deps := kernel.dependencies["B"]; strings.Join(deps, ",") != "C,D" { t.Errorf("TestKernelComponentsFromConfigurationErrors is broken - dependencies: %v", deps) }
+}
@@ -0,0 +1,233 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"fmt"
+	"bytes"
+	"strings"
+	"net/http"
+	"encoding/json"
+)
+
+// The kinds of dependency edges in the component graph.
+const (
+	InjectEdge = "inject" // A dlinject field.
+	ExplicitEdge = "explicit" // Declared with AddDependency (or dependsOn in the configuration file).
+	DependentEdge = "dependent" // Returned by the DependentComponent interface.
+
+	graphFormatParam = "format"
+	graphFormatDot = "dot"
+	ContentTypeGraphviz = "text/vnd.graphviz; charset=utf-8"
+)
+
+// The component graph. The start order is the order the components were started in (or will be
+// started in if the kernel is not started). If the start order cannot be calculated (e.g., there
+// is a cycle), the error is set.
+type ComponentGraph struct {
+	Id string `json:"id"`
+	Started bool `json:"started"`
+	StartOrder []string `json:"startOrder"`
+	StartOrderError string `json:"startOrderError,omitempty"`
+	Components []*ComponentNode `json:"components"`
+	Edges []*ComponentEdge `json:"edges"`
+}
+
+// A component in the graph. The type is not set for components that are created on demand and
+// have not been created (see ComponentDefinition).
+type ComponentNode struct {
+	Id string `json:"id"`
+	Type string `json:"type,omitempty"`
+	Scope ComponentScope `json:"scope,omitempty"`
+	Lazy bool `json:"lazy,omitempty"`
+	Start string `json:"start"`
+	Stop string `json:"stop"`
+	InjectedFields []*InjectedField `json:"injectedFields,omitempty"`
+}
+
+// A dlinject field. The component id is empty if the field is optional and nothing is injected.
+type InjectedField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	ComponentId string `json:"componentId,omitempty"`
+	Autowired bool `json:"autowired"`
+	Optional bool `json:"optional"`
+	DbName string `json:"dbName,omitempty"`
+	CollectionName string `json:"collectionName,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// An edge from a component to a component it depends on. The field is set on inject edges.
+type ComponentEdge struct {
+	From string `json:"from"`
+	To string `json:"to"`
+	Kind string `json:"kind"`
+	Field string `json:"field,omitempty"`
+}
+
+// Returns the component graph. This can be called before or after the kernel is started.
+func (self *Kernel) Graph() *ComponentGraph {
+
+	graph := &ComponentGraph{ Id: self.Id, Started: self.Started(), StartOrder: []string{}, Components: []*ComponentNode{}, Edges: []*ComponentEdge{} }
+
+	if graph.Started {
+		graph.StartOrder = componentIds(self.startedComponents())
+	} else if startOrder, err := self.componentStartOrder(); err != nil {
+		graph.StartOrderError = err.Error()
+	} else {
+		graph.StartOrder = componentIds(startOrder)
+	}
+
+	for _, component := range self.components {
+
+		node := &ComponentNode{	Id: component.componentId,
+								Start: lifecycleDescription(component.startMethodName, component.startInterfaceName),
+								Stop: lifecycleDescription(component.stopMethodName, component.stopInterfaceName),
+		}
+
+		singleton := component.singleton
+
+		if component.definition != nil {
+			node.Scope = component.definition.Scope
+			node.Lazy = component.definition.Lazy
+
+			component.definition.lock.Lock()
+			if singleton == nil { singleton = component.definition.instance }
+			component.definition.lock.Unlock()
+		}
+
+		if singleton != nil { node.Type = fmt.Sprintf("%T", singleton) }
+
+		graph.Components = append(graph.Components, node)
+
+		// The edges are added in the same order as the dependencies are found (see componentDependencies).
+		seen := make(map[string]bool)
+		addEdge := func(edge *ComponentEdge) {
+			if len(edge.To) == 0 || seen[edge.To] { return }
+			seen[edge.To] = true
+			graph.Edges = append(graph.Edges, edge)
+		}
+
+		// The fields on a component that is created on demand are known once an instance has been created.
+		if singleton == nil { node.InjectedFields = self.injectedFields(component.componentId, component.dependencySingleton()) } else { node.InjectedFields = self.injectedFields(component.componentId, singleton) }
+		for _, field := range node.InjectedFields { addEdge(&ComponentEdge{ From: node.Id, To: field.ComponentId, Kind: InjectEdge, Field: field.Name }) }
+
+		for _, dependencyId := range self.dependencies[component.componentId] { addEdge(&ComponentEdge{ From: node.Id, To: dependencyId, Kind: ExplicitEdge }) }
+
+		if dependent, ok := singleton.(DependentComponent); ok {
+			for _, dependencyId := range dependent.DependsOn(self) { addEdge(&ComponentEdge{ From: node.Id, To: dependencyId, Kind: DependentEdge }) }
+		}
+	}
+
+	return graph
+}
+
+// Returns the dlinject fields on a component.
+func (self *Kernel) injectedFields(componentId string, singleton interface{}) []*InjectedField {
+
+	componentValue, ok := componentStructValue(singleton)
+	if !ok { return nil }
+
+	var fields []*InjectedField
+
+	componentType := componentValue.Type()
+	for i := 0; i < componentType.NumField(); i++ {
+		structField := componentType.Field(i)
+
+		tag, tagged := structField.Tag.Lookup(injectTagName)
+		if !tagged { continue }
+
+		field := &InjectedField{ Name: structField.Name, Type: structField.Type.String() }
+		fields = append(fields, field)
+
+		if structField.Type.String() == injectMongoDataSourceName {
+			if values := strings.Split(tag, commaStr); len(values) == 3 {
+				field.ComponentId, field.DbName, field.CollectionName = strings.TrimSpace(values[0]), strings.TrimSpace(values[1]), strings.TrimSpace(values[2])
+			} else {
+				field.Error = "config must be componentId,dbName,collectionName"
+			}
+			continue
+		}
+
+		tagComponentId, optional, err := parseInjectTag(tag)
+		field.Optional = optional
+		field.Autowired = err == nil && len(tagComponentId) == 0
+
+		if field.ComponentId, err = self.injectFieldComponentId(componentId, structField, tag); err != nil { field.Error = err.Error() }
+	}
+
+	return fields
+}
+
+// Returns the graph in the Graphviz DOT format. The inject edges are labeled with the field name
+// and the other edges are dashed.
+func (self *ComponentGraph) Dot() string {
+
+	var buffer bytes.Buffer
+
+	buffer.WriteString(fmt.Sprintf("digraph %s {\n", dotQuote(self.Id)))
+	buffer.WriteString("\trankdir=LR;\n")
+	buffer.WriteString("\tnode [shape=box];\n")
+
+	for _, node := range self.Components {
+		label := dotEscape(node.Id)
+		if len(node.Type) > 0 { label += `\n` + dotEscape(node.Type) }
+		if len(node.Scope) > 0 && node.Scope != SingletonScope { label += `\n` + dotEscape(string(node.Scope)) }
+		if node.Lazy { label += `\nlazy` }
+		buffer.WriteString(fmt.Sprintf("\t%s [label=\"%s\"];\n", dotQuote(node.Id), label))
+	}
+
+	for _, edge := range self.Edges {
+		switch edge.Kind {
+			case InjectEdge: buffer.WriteString(fmt.Sprintf("\t%s -> %s [label=%s];\n", dotQuote(edge.From), dotQuote(edge.To), dotQuote(edge.Field)))
+			default: buffer.WriteString(fmt.Sprintf("\t%s -> %s [style=dashed, label=%s];\n", dotQuote(edge.From), dotQuote(edge.To), dotQuote(edge.Kind)))
+		}
+	}
+
+	buffer.WriteString("}\n")
+
+	return buffer.String()
+}
+
+func dotQuote(value string) string { return `"` + dotEscape(value) + `"` }
+
+func dotEscape(value string) string { return strings.Replace(strings.Replace(value, `\`, `\\`, -1), `"`, `\"`, -1) }
+
+// Returns the graph as json.
+func (self *ComponentGraph) Json() ([]byte, error) { return json.MarshalIndent(self, nadaStr, "  ") }
+
+// An http handler that returns the component graph. The graph is returned as json unless the "format"
+// query parameter is set to "dot". Mount this on the HttpServer (e.g., httpServer.AddHandler("/admin/graph",
+// kernel.GraphHandler)).
+func (self *Kernel) GraphHandler(response http.ResponseWriter, request *http.Request) {
+
+	graph := self.Graph()
+
+	if request.URL.Query().Get(graphFormatParam) == graphFormatDot {
+		response.Header().Set(ContentTypeHeader, ContentTypeGraphviz)
+		response.WriteHeader(http.StatusOK)
+		response.Write([]byte(graph.Dot()))
+		return
+	}
+
+	rawJson, err := graph.Json()
+	if err != nil { http.Error(response, "Error", http.StatusInternalServerError); return }
+
+	response.Header().Set(ContentTypeHeader, ContentTypeJson)
+	response.WriteHeader(http.StatusOK)
+	response.Write(rawJson)
+}
@@ -0,0 +1,83 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"strings"
+	"testing"
+	"net/http"
+	"encoding/json"
+	"net/http/httptest"
+)
+
+type testGraphUser struct {
+	testKernelOrderComponent
+	Named *testKernelOrderComponent `dlinject:"A"`
+	Autowired testKernelAutowireLock `dlinject:""`
+	Missing *testGraphUser `dlinject:"Missing,optional"`
+}
+
+func TestKernelGraph(t *testing.T) {
+
+	var calls []string
+
+	kernel, _ := NewTestKernel("kernelGraph", nil)
+	kernel.AddComponent("User", &testGraphUser{ testKernelOrderComponent: testKernelOrderComponent{ id: "User", calls: &calls } })
+	kernel.AddComponent("A", &testKernelOrderComponent{ id: "A", calls: &calls })
+	kernel.AddComponent("Lock", &testKernelAutowireLockImpl{ testKernelOrderComponent{ id: "Lock", calls: &calls } })
+	kernel.AddComponentDefinition(ComponentDefinition{ Id: "Prototype", Scope: PrototypeScope, Factory: func(kernel *Kernel) (interface{}, error) { return &testKernelOrderComponent{}, nil } })
+	kernel.AddDependency("A", "Lock")
+
+	graph := kernel.Graph()
+
+	if graph.Started || strings.Join(graph.StartOrder, ",") != "Lock,A,User" { t.Errorf("TestKernelGraph is broken - start order: %v", graph.StartOrder) }
+
+	if len(graph.Components) != 4 || graph.Components[0].Type != "*dlshared.testGraphUser" || graph.Components[0].Start != "Start (Starter)" {
+		t.Errorf("TestKernelGraph is broken - components: %+v", graph.Components[0])
+	}
+
+	if prototype := graph.Components[3]; prototype.Scope != PrototypeScope || len(prototype.Type) != 0 { t.Errorf("TestKernelGraph is broken - prototype: %+v", prototype) }
+
+	fields := graph.Components[0].InjectedFields
+	if len(fields) != 3 || fields[0].ComponentId != "A" || !fields[1].Autowired || fields[1].ComponentId != "Lock" || !fields[2].Optional || len(fields[2].ComponentId) != 0 {
+		t.Errorf("TestKernelGraph is broken - injected fields: %+v %+v %+v", fields[0], fields[1], fields[2])
+	}
+
+	var edges []string
+	for _, edge := range graph.Edges { edges = append(edges, edge.From + "->" + edge.To + ":" + edge.Kind) }
+	if strings.Join(edges, ",") != "User->A:inject,User->Lock:inject,A->Lock:explicit" { t.Errorf("TestKernelGraph is broken - edges: %v", edges) }
+
+	dot := graph.Dot()
+	if !strings.HasPrefix(dot, `digraph "kernelGraph" {`) || !strings.Contains(dot, `"User" -> "A" [label="Named"];`) || !strings.Contains(dot, `"A" -> "Lock" [style=dashed, label="explicit"];`) {
+		t.Errorf("TestKernelGraph is broken - dot: %s", dot)
+	}
+
+	if err := kernel.Start(); err != nil { t.Errorf("TestKernelGraph is broken - start: %v", err); return }
+	defer kernel.Stop()
+
+	// The http handler.
+	recorder := httptest.NewRecorder()
+	kernel.GraphHandler(recorder, httptest.NewRequest(http.MethodGet, "/graph", nil))
+
+	decoded := &ComponentGraph{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), decoded); err != nil || !decoded.Started || len(decoded.Edges) != 3 { t.Errorf("TestKernelGraph is broken - json: %v - %s", err, recorder.Body.String()) }
+
+	recorder = httptest.NewRecorder()
+	kernel.GraphHandler(recorder, httptest.NewRequest(http.MethodGet, "/graph?format=dot", nil))
+
+	if recorder.Header().Get(ContentTypeHeader) != ContentTypeGraphviz || !strings.HasPrefix(recorder.Body.String(), "digraph") { t.Errorf("TestKernelGraph is broken - dot handler: %s", recorder.Body.String()) }
+}
@@ -0,0 +1,186 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"time"
+	"context"
+	"net/http"
+	"sync/atomic"
+	"encoding/json"
+)
+
+// Components implement this interface to report their health to the kernel. The detail
+// returned is a short description of the component state (e.g., "lock held: true") and
+// is included in the report even if the component is healthy. Return an error if the
+// component is not healthy. The context is canceled if the check exceeds its timeout.
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) (string, error)
+}
+
+// The health check timeout defaults to five seconds. This can be changed for all components
+// by setting "kernel.healthCheckTimeoutInMs" or for a single component by setting
+// "kernel.components.<componentId>.healthCheckTimeoutInMs" in the configuration file.
+const (
+	kernelHealthCheckTimeoutKey = "healthCheckTimeoutInMs"
+	defaultHealthCheckTimeout = 5 * time.Second
+)
+
+type ComponentHealth struct {
+	ComponentId string `json:"componentId"`
+	Healthy bool `json:"healthy"`
+	Detail string `json:"detail,omitempty"`
+	Error string `json:"error,omitempty"`
+	TimedOut bool `json:"timedOut"`
+	ElapsedInMs int64 `json:"elapsedInMs"`
+}
+
+// The health report. Healthy is true if all of the checks passed and none of the workers failed.
+// Ready is true if the kernel is started and healthy.
+type HealthReport struct {
+	Id string `json:"id"`
+	Version string `json:"version"`
+	Hostname string `json:"hostname"`
+	Pid int `json:"pid"`
+	Started bool `json:"started"`
+	Healthy bool `json:"healthy"`
+	Ready bool `json:"ready"`
+	Checked time.Time `json:"checked"`
+	Components []*ComponentHealth `json:"components"`
+	Workers []WorkerStatus `json:"workers,omitempty"`
+}
+
+// Returns true if the kernel was started and has not been stopped.
+func (self *Kernel) Started() bool { return atomic.LoadInt32(&self.started) == 1 }
+
+func (self *Kernel) setStarted(started bool) {
+	if started { atomic.StoreInt32(&self.started, 1) } else { atomic.StoreInt32(&self.started, 0) }
+}
+
+// Run the health checks on all of the components that implement the HealthChecker interface. The
+// checks are run concurrently and each check has a timeout. If a check exceeds its timeout, the
+// component is reported as unhealthy.
+func (self *Kernel) Health() *HealthReport {
+
+	components := self.startedComponents()
+
+	report := &HealthReport{
+		Id: self.Id,
+		Version: self.Configuration.Version,
+		Hostname: self.Configuration.Hostname,
+		Pid: self.Configuration.Pid,
+		Started: self.Started(),
+		Healthy: true,
+		Checked: time.Now(),
+	}
+
+	var results []chan *ComponentHealth
+
+	for _, component := range components {
+		if healthChecker, ok := component.singleton.(HealthChecker); ok {
+			result := make(chan *ComponentHealth, 1)
+			results = append(results, result)
+			go func(componentId string, healthChecker HealthChecker) { result <- self.checkComponentHealth(componentId, healthChecker) }(component.componentId, healthChecker)
+		}
+	}
+
+	for _, result := range results {
+		componentHealth := <- result
+		report.Components = append(report.Components, componentHealth)
+		if !componentHealth.Healthy { report.Healthy = false }
+	}
+
+	report.Workers = self.Workers()
+	for _, worker := range report.Workers { if worker.State == WorkerFailed { report.Healthy = false } }
+
+	report.Ready = report.Started && report.Healthy
+
+	return report
+}
+
+func (self *Kernel) checkComponentHealth(componentId string, healthChecker HealthChecker) *ComponentHealth {
+
+	timeout := self.componentTimeout(componentId, kernelHealthCheckTimeoutKey)
+	if timeout <= 0 { timeout = defaultHealthCheckTimeout }
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	type checkResult struct { detail string; err error }
+
+	done := make(chan checkResult, 1)
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil { done <- checkResult{ err: NewStackError("Health check panicked - problem: %v", r) } }
+		}()
+		detail, err := healthChecker.CheckHealth(ctx)
+		done <- checkResult{ detail, err }
+	}()
+
+	componentHealth := &ComponentHealth{ ComponentId: componentId }
+	startTime := time.Now()
+
+	select {
+		case result := <- done: {
+			componentHealth.Detail = result.detail
+			componentHealth.Healthy = result.err == nil
+			if result.err != nil { componentHealth.Error = result.err.Error() }
+		}
+
+		case <- ctx.Done(): {
+			componentHealth.TimedOut = true
+			componentHealth.Error = "health check timed out"
+			self.Logf(Warn, "Component: %s - health check timed out - timeout: %v", componentId, timeout)
+		}
+	}
+
+	elapsed := time.Since(startTime)
+	componentHealth.ElapsedInMs = DurationToMillis(&elapsed)
+
+	return componentHealth
+}
+
+// An http handler that returns the health report as json. If the kernel is not ready (i.e., not started
+// or a check failed), a 503 status code is returned. Use this for load balancer readiness checks.
+func (self *Kernel) ReadinessHandler(response http.ResponseWriter, request *http.Request) {
+	report := self.Health()
+
+	statusCode := http.StatusOK
+	if !report.Ready { statusCode = http.StatusServiceUnavailable }
+
+	writeHealthResponse(response, statusCode, report)
+}
+
+// An http handler that returns a 200 status code while the kernel is started. The component health
+// checks are not run. Use this for load balancer liveness checks.
+func (self *Kernel) LivenessHandler(response http.ResponseWriter, request *http.Request) {
+
+	statusCode := http.StatusOK
+	if !self.Started() { statusCode = http.StatusServiceUnavailable }
+
+	writeHealthResponse(response, statusCode, map[string]interface{} { "id": self.Id, "started": self.Started() })
+}
+
+func writeHealthResponse(response http.ResponseWriter, statusCode int, value interface{}) {
+	rawJson, err := json.Marshal(value)
+	if err != nil { http.Error(response, "Error", http.StatusInternalServerError); return }
+
+	response.Header().Set(ContentTypeHeader, ContentTypeJson)
+	response.WriteHeader(statusCode)
+	response.Write(rawJson)
+}
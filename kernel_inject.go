@@ -0,0 +1,101 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"strings"
+	"reflect"
+)
+
+// The dlinject tag tells the kernel to inject a component into an exported field. The tag can be
+// in the following forms:
+//
+//    Lock DistributedLock `dlinject:"MyDistributedLock"`            // Inject the component by id.
+//    Lock DistributedLock `dlinject:""`                             // Autowire by type.
+//    Lock DistributedLock `dlinject:",optional"`                    // Autowire by type - nil if there is no match.
+//    Lock DistributedLock `dlinject:"MyDistributedLock,optional"`   // Inject by id - nil if not registered.
+//    Ds MongoDataSource `dlinject:"MongoDb,dbName,collectionName"`  // Create a MongoDataSource for the Mongo component.
+//
+// When autowiring, the kernel looks for the registered component that is assignable to the field type
+// (e.g., a struct pointer or an interface it implements). If no component matches or more than one
+// component matches, the kernel will not start (unless the field is optional and there is no match).
+// The components that are created on demand (see ComponentDefinition) are only injected by id.
+const (
+	injectTagName = "dlinject"
+	injectTagOptional = "optional"
+)
+
+// Parse a dlinject tag. An empty component id means autowire by type. An error is returned
+// if an unknown option is set.
+func parseInjectTag(tag string) (componentId string, optional bool, err error) {
+
+	values := strings.Split(tag, commaStr)
+
+	componentId = strings.TrimSpace(values[0])
+
+	for _, option := range values[1:] {
+		switch strings.TrimSpace(option) {
+			case injectTagOptional: optional = true
+			default: return nadaStr, false, NewStackError("Invalid dlinject tag: %s - unknown option: %s", tag, option)
+		}
+	}
+
+	return
+}
+
+// Returns the id of the component to inject into the field. If the field is optional and no component
+// is found, an empty string is returned. If the component id is set in the tag and it is not optional,
+// the id is returned even if the component is not registered (the caller reports the missing component).
+func (self *Kernel) injectFieldComponentId(componentId string, structField reflect.StructField, tag string) (string, error) {
+
+	injectComponentId, optional, err := parseInjectTag(tag)
+	if err != nil { return nadaStr, NewStackError("Unable to inject into component: %s - on field: %s - reason: %v", componentId, structField.Name, err) }
+
+	if len(injectComponentId) == 0 { return self.autowireComponentId(componentId, structField, optional) }
+
+	if optional && !self.HasComponent(injectComponentId) { return nadaStr, nil }
+
+	return injectComponentId, nil
+}
+
+// Find the one component that is assignable to the field type. The component is never injected into itself.
+func (self *Kernel) autowireComponentId(componentId string, structField reflect.StructField, optional bool) (string, error) {
+
+	var matches []string
+
+	for _, component := range self.components {
+		if component.componentId == componentId || component.onDemand() { continue }
+		if reflect.TypeOf(component.singleton).AssignableTo(structField.Type) { matches = append(matches, component.componentId) }
+	}
+
+	switch len(matches) {
+		case 1: return matches[0], nil
+		case 0: {
+			if optional { return nadaStr, nil }
+			return nadaStr, NewStackError(	"Unable to autowire component: %s - on field: %s - reason: no component is assignable to type: %s",
+											componentId,
+											structField.Name,
+											structField.Type)
+		}
+	}
+
+	return nadaStr, NewStackError(	"Unable to autowire component: %s - on field: %s - reason: more than one component is assignable to type: %s - matches: %s - set the component id in the dlinject tag",
+									componentId,
+									structField.Name,
+									structField.Type,
+									strings.Join(matches, ", "))
+}
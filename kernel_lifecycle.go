@@ -0,0 +1,228 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"fmt"
+	"time"
+	"context"
+	"strings"
+)
+
+// The lifecycle timeouts are set in the configuration file. The defaults for all of the components
+// are set in the "kernel" document and can be overridden for a specific component by adding a document
+// with the component id to "kernel.components". A value of zero (the default) means no limit. The
+// shutdownTimeoutInMs is the total amount of time the kernel will wait for all of the components to stop.
+//
+//    "kernel": {
+//        "startTimeoutInMs": 30000,
+//        "stopTimeoutInMs": 10000,
+//        "shutdownTimeoutInMs": 60000,
+//        "components": {
+//            "CronSvc": { "stopTimeoutInMs": 20000 }
+//        }
+//    }
+//
+// If a component exceeds its deadline, an error is logged and the kernel moves on. The context passed
+// to the ContextStarter/ContextStopper interfaces is canceled when the deadline is exceeded.
+const (
+	kernelConfigPath = "kernel"
+	kernelComponentsConfigPath = "kernel.components"
+	kernelStartTimeoutKey = "startTimeoutInMs"
+	kernelStopTimeoutKey = "stopTimeoutInMs"
+	kernelShutdownTimeoutKey = "shutdownTimeoutInMs"
+)
+
+// The lifecycle interfaces. If a component is registered with AddComponent, the kernel checks
+// to see if it implements one of the start and one of the stop interfaces below. If more than one
+// is implemented, the context version is used first, then the kernel version and then the version
+// without params. Components registered with the AddComponentWith* methods use the method names
+// passed and these interfaces are ignored.
+type Starter interface { Start() error }
+
+type Stopper interface { Stop() error }
+
+type KernelStarter interface { Start(kernel *Kernel) error }
+
+type KernelStopper interface { Stop(kernel *Kernel) error }
+
+// The context passed is canceled if the kernel gives up waiting on the component.
+type ContextStarter interface { Start(ctx context.Context, kernel *Kernel) error }
+
+// The context passed is canceled if the kernel gives up waiting on the component.
+type ContextStopper interface { Stop(ctx context.Context, kernel *Kernel) error }
+
+// Components that implement this interface are called when the kernel is reloaded (e.g., when the
+// process receives a SIGHUP).
+type Reloadable interface { Reload(kernel *Kernel) error }
+
+type lifecycleFunc func(ctx context.Context, kernel *Kernel) error
+
+// Returns the start function and interface name if the component implements one of the start
+// interfaces. If not, nil and an empty string are returned.
+func lifecycleStartFunc(singleton interface{}) (lifecycleFunc, string) {
+	switch component := singleton.(type) {
+		case ContextStarter: return component.Start, "ContextStarter"
+		case KernelStarter: return func(ctx context.Context, kernel *Kernel) error { return component.Start(kernel) }, "KernelStarter"
+		case Starter: return func(ctx context.Context, kernel *Kernel) error { return component.Start() }, "Starter"
+	}
+	return nil, nadaStr
+}
+
+// Returns the stop function and interface name if the component implements one of the stop
+// interfaces. If not, nil and an empty string are returned.
+func lifecycleStopFunc(singleton interface{}) (lifecycleFunc, string) {
+	switch component := singleton.(type) {
+		case ContextStopper: return component.Stop, "ContextStopper"
+		case KernelStopper: return func(ctx context.Context, kernel *Kernel) error { return component.Stop(kernel) }, "KernelStopper"
+		case Stopper: return func(ctx context.Context, kernel *Kernel) error { return component.Stop() }, "Stopper"
+	}
+	return nil, nadaStr
+}
+
+// Returns a lifecycle function that calls the method by name (the AddComponentWith* methods). If
+// the method name is empty, nil is returned.
+func lifecycleMethodFunc(methodTypeName, methodName string, singleton interface{}) lifecycleFunc {
+	if len(methodName) == 0 { return nil }
+	return func(ctx context.Context, kernel *Kernel) error { return callStartStopMethod(methodTypeName, methodName, singleton, kernel) }
+}
+
+// Returns the kernel level timeout for the key passed. Zero means no limit.
+func (self *Kernel) kernelTimeout(key string) time.Duration {
+	return time.Duration(self.Configuration.IntWithPath(kernelConfigPath, key, 0)) * time.Millisecond
+}
+
+// Returns the component timeout for the key passed. If the component does not have a timeout
+// configured, the kernel level timeout is returned.
+func (self *Kernel) componentTimeout(componentId, key string) time.Duration {
+	componentPath := fmt.Sprintf(confPathKeyPattern, kernelComponentsConfigPath, componentId)
+	return time.Duration(self.Configuration.IntWithPath(componentPath, key, int(self.kernelTimeout(key) / time.Millisecond))) * time.Millisecond
+}
+
+// Call a lifecycle function. If the timeout is greater than zero or the deadline is set, the function
+// is called in a separate goroutine and this method returns an error if it does not complete in time. The
+// earliest of the timeout and the deadline is used. A panic in the lifecycle function is returned as an error.
+func (self *Kernel) callLifecycle(component Component, methodTypeName string, fn lifecycleFunc, timeout time.Duration, deadline time.Time) error {
+
+	if timeout > 0 && (deadline.IsZero() || time.Now().Add(timeout).Before(deadline)) { deadline = time.Now().Add(timeout) }
+
+	if deadline.IsZero() { return callLifecycleFunc(context.Background(), self, component, methodTypeName, fn) }
+
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	if remaining := deadline.Sub(time.Now()); remaining <= 0 {
+		// The shutdown budget is used up. The method is still called so the component is signaled, but
+		// the kernel does not wait.
+		go callLifecycleFunc(ctx, self, component, methodTypeName, fn)
+		self.Logf(Error, "Component: %s - %s method not waited on - the kernel shutdown budget is used up", component.componentId, methodTypeName)
+		return NewStackError("Component: %s - %s method not waited on - shutdown budget used up", component.componentId, methodTypeName)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- callLifecycleFunc(ctx, self, component, methodTypeName, fn) }()
+
+	startTime := time.Now()
+
+	select {
+		case err := <- done: return err
+		case <- ctx.Done(): {
+			elapsed := time.Since(startTime)
+			self.Logf(Error, "Component: %s - %s method exceeded its deadline - waited: %d ms - moving on", component.componentId, methodTypeName, DurationToMillis(&elapsed))
+			return NewStackError("Component: %s - %s method exceeded its deadline - waited: %d ms", component.componentId, methodTypeName, DurationToMillis(&elapsed))
+		}
+	}
+}
+
+// Call the lifecycle function and return a panic as an error.
+func callLifecycleFunc(ctx context.Context, kernel *Kernel, component Component, methodTypeName string, fn lifecycleFunc) (err error) {
+
+	// This can be called in a new goroutine (see callLifecycle), so the creating ids are set here.
+	defer kernel.enterCreating(component.creating)()
+
+	defer func() {
+		if r := recover(); r != nil { err = NewStackError("Component: %s - %s method panicked - problem: %v", component.componentId, methodTypeName, r) }
+	}()
+
+	return fn(ctx, kernel)
+}
+
+// Reload reopens the log files (see Reopenable), loads the configuration files again (see Configuration.Reload)
+// and then calls the Reload method on all of the components that implement the Reloadable interface. The
+// components are called in the order they were started. This does not stop at the first error, all of the
+// components are called and the errors are returned in an AggregateError.
+func (self *Kernel) Reload() error {
+
+	components := self.startedComponents()
+
+	var errs []error
+
+	for _, appender := range self.Logger.Appenders {
+		if err := reopenAppender(appender); err != nil { errs = append(errs, NewStackError("Unable to reopen log appender: %T - err: %v", appender, err)) }
+	}
+
+	if len(self.Configuration.FileNames) > 0 {
+		if err := self.Configuration.Reload(); err != nil {
+			errs = append(errs, NewStackError("Unable to reload configuration: %s - err: %v", strings.Join(self.Configuration.FileNames, ", "), err))
+		} else {
+			self.Logf(Info, "Reloaded configuration: %s", strings.Join(self.Configuration.FileNames, ", "))
+		}
+	}
+
+	for _, component := range components {
+		reloadable, ok := component.singleton.(Reloadable)
+		if !ok { continue }
+
+		reload := func(ctx context.Context, kernel *Kernel) error { return reloadable.Reload(kernel) }
+
+		if err := callLifecycleFunc(context.Background(), self, component, "reload", reload); err != nil {
+			errs = append(errs, NewStackError("Unable to reload component: %s - err: %v", component.componentId, err))
+		}
+	}
+
+	return NewAggregateError(fmt.Sprintf("Unable to reload: %s", self.Id), errs)
+}
+
+// If the watch interval is set, the kernel checks the configuration files for changes and reloads when
+// a file changes (the same as a SIGHUP). This is disabled by default.
+//
+//    "kernel": {
+//        "configurationWatchIntervalInMs": 5000
+//    }
+const kernelConfigurationWatchIntervalKey = "configurationWatchIntervalInMs"
+
+func (self *Kernel) startConfigurationWatcher() {
+
+	interval := self.kernelTimeout(kernelConfigurationWatchIntervalKey)
+	if interval <= 0 || len(self.Configuration.FileNames) == 0 { return }
+
+	self.StartWorker("kernel.configurationWatcher", func(ctx context.Context) error {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+				case <- ctx.Done(): return nil
+				case <- ticker.C: {
+					if !self.Configuration.FilesModified() { continue }
+					self.Logf(Info, "Configuration modified - reloading: %s", self.Id)
+					if err := self.Reload(); err != nil { self.Logf(Error, "Unable to reload: %s - err: %v", self.Id, err) }
+				}
+			}
+		}
+	})
+}
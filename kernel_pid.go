@@ -0,0 +1,135 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"os"
+	"strings"
+	"strconv"
+	"syscall"
+	"io/ioutil"
+)
+
+// The number of times to try and lock the pid file if it is replaced while we are locking it.
+const pidFileLockAttempts = 3
+
+// Write the pid file. The kernel takes an exclusive lock (flock) on the pid file and holds it until the
+// kernel is stopped. If another process holds the lock, an error is returned with the pid of the process.
+// If the file is left over from a process that is no longer running (a stale file), it is replaced.
+func writePidFile(kernel *Kernel) error {
+
+	kernel.Pid = os.Getpid()
+
+	fileName := kernel.Configuration.PidFile
+
+	for attempt := 0; attempt < pidFileLockAttempts; attempt++ {
+
+		pidFile, err := lockPidFile(fileName)
+		if err != nil { return err }
+
+		// The file was removed/replaced by the owner before we locked it. Try again with the new file.
+		if pidFile == nil { continue }
+
+		// We hold the lock so a pid in the file is from a process that did not clean up.
+		if pid, err := readPid(pidFile); err == nil && pid > 0 && pid != kernel.Pid {
+			if processRunning(pid) {
+				kernel.Logf(Warn, "Pid file %s references running process: %d - the process does not hold the lock - replacing", fileName, pid)
+			} else {
+				kernel.Logf(Info, "Replacing stale pid file %s - process: %d is not running", fileName, pid)
+			}
+		}
+
+		if err := writePid(pidFile, kernel.Pid); err != nil {
+			pidFile.Close()
+			return NewStackError("Unable to start kernel - problem writing pid file %s - error: %v", fileName, err)
+		}
+
+		kernel.pidFile = pidFile
+		kernel.pidFileName = fileName
+
+		return nil
+	}
+
+	return NewStackError("Unable to start kernel - unable to lock pid file %s - the file keeps changing", fileName)
+}
+
+// Open and lock the pid file. If the file was replaced before the lock was acquired, nil is returned
+// (without an error) and the caller should try again.
+func lockPidFile(fileName string) (*os.File, error) {
+
+	pidFile, err := os.OpenFile(fileName, os.O_RDWR | os.O_CREATE, 0644)
+	if err != nil { return nil, NewStackError("Unable to start kernel - problem opening pid file %s - error: %v", fileName, err) }
+
+	if err := syscall.Flock(int(pidFile.Fd()), syscall.LOCK_EX | syscall.LOCK_NB); err != nil {
+		defer pidFile.Close()
+
+		if err == syscall.EWOULDBLOCK {
+			pid, _ := readPid(pidFile)
+			return nil, NewStackError("Unable to start kernel - pid file %s is locked by another process - pid: %d - running: %t", fileName, pid, processRunning(pid))
+		}
+
+		return nil, NewStackError("Unable to start kernel - problem locking pid file %s - error: %v", fileName, err)
+	}
+
+	// Make sure the file we locked is still the file at the path.
+	lockedInfo, err := pidFile.Stat()
+	if err != nil { pidFile.Close(); return nil, NewStackError("Unable to start kernel - problem reading pid file %s - error: %v", fileName, err) }
+
+	if pathInfo, err := os.Stat(fileName); err != nil || !os.SameFile(lockedInfo, pathInfo) { pidFile.Close(); return nil, nil }
+
+	return pidFile, nil
+}
+
+// Remove the pid file if it was written by this kernel. The file is removed before the lock is released.
+func removePidFile(kernel *Kernel) error {
+	if len(kernel.pidFileName) == 0 { return nil }
+
+	var err error
+	if removeErr := os.Remove(kernel.pidFileName); removeErr != nil && !os.IsNotExist(removeErr) {
+		err = NewStackError("Unable to remove pid file %s - error: %v", kernel.pidFileName, removeErr)
+	}
+
+	if kernel.pidFile != nil { kernel.pidFile.Close() }
+
+	kernel.pidFile = nil
+	kernel.pidFileName = nadaStr
+	return err
+}
+
+func readPid(pidFile *os.File) (int, error) {
+
+	if _, err := pidFile.Seek(0, 0); err != nil { return 0, err }
+
+	data, err := ioutil.ReadAll(pidFile)
+	if err != nil { return 0, err }
+
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+func writePid(pidFile *os.File, pid int) error {
+	if err := pidFile.Truncate(0); err != nil { return err }
+	if _, err := pidFile.WriteAt([]byte(strconv.Itoa(pid)), 0); err != nil { return err }
+	return pidFile.Sync()
+}
+
+// Returns true if the process is running. A permission error means the process exists, but
+// is owned by another user.
+func processRunning(pid int) bool {
+	if pid <= 0 { return false }
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -18,7 +18,18 @@ package dlshared
 
 
 import (
+	"os"
+	"fmt"
+	"time"
+	"bytes"
+	"syscall"
+	"context"
+	"strings"
+	"strconv"
 	"testing"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"labix.org/v2/mgo/bson"
 )
 
@@ -68,3 +79,513 @@ func TestKernelInject(t *testing.T) {
 	if err := kernel.Stop(); err != nil { t.Errorf("TestKernelInject stop kernel is broken:", err) }
 }
 
+
+type testKernelOrderComponent struct {
+	id string
+	calls *[]string
+}
+
+func (self *testKernelOrderComponent) Start() error { *self.calls = append(*self.calls, "start:" + self.id); return nil }
+func (self *testKernelOrderComponent) Stop() error { *self.calls = append(*self.calls, "stop:" + self.id); return nil }
+
+type testKernelOrderInjected struct {
+	testKernelOrderComponent
+	Dependency *testKernelOrderComponent `dlinject:"testKernelOrderA"`
+}
+
+type testKernelOrderDependent struct { testKernelOrderComponent }
+
+func (self *testKernelOrderDependent) DependsOn(kernel *Kernel) []string { return []string{ "testKernelOrderB" } }
+
+func TestKernelDependencyOrder(t *testing.T) {
+
+	kernel, err := newKernel("kernelDependencyOrder", testConfigFileName)
+	if err != nil { t.Errorf("TestKernelDependencyOrder new kernel is broken: %v", err); return }
+
+	var calls []string
+
+	// Registered in the reverse of the order they need to start.
+	kernel.AddComponentWithStartStopMethods("testKernelOrderC", &testKernelOrderDependent{ testKernelOrderComponent{ id: "C", calls: &calls } }, "Start", "Stop")
+	kernel.AddComponentWithStartStopMethods("testKernelOrderB", &testKernelOrderInjected{ testKernelOrderComponent: testKernelOrderComponent{ id: "B", calls: &calls } }, "Start", "Stop")
+	kernel.AddComponentWithStartStopMethods("testKernelOrderA", &testKernelOrderComponent{ id: "A", calls: &calls }, "Start", "Stop")
+
+	if err := kernel.Start(); err != nil { t.Errorf("TestKernelDependencyOrder start is broken: %v", err); return }
+	if err := kernel.Stop(); err != nil { t.Errorf("TestKernelDependencyOrder stop is broken: %v", err); return }
+
+	expected := []string{ "start:A", "start:B", "start:C", "stop:C", "stop:B", "stop:A" }
+	if strings.Join(calls, ",") != strings.Join(expected, ",") { t.Errorf("TestKernelDependencyOrder is broken - expected: %v - received: %v", expected, calls) }
+}
+
+func TestKernelDependencyErrors(t *testing.T) {
+
+	kernel, err := newKernel("kernelDependencyErrors", testConfigFileName)
+	if err != nil { t.Errorf("TestKernelDependencyErrors new kernel is broken: %v", err); return }
+
+	kernel.AddComponent("testKernelOrderB", &testKernelOrderInjected{})
+
+	err = kernel.Start()
+	if err == nil || !strings.Contains(err.Error(), "testKernelOrderB depends on: testKernelOrderA") {
+		t.Errorf("TestKernelDependencyErrors is broken - expected missing dependency error - received: %v", err)
+	}
+
+	kernel, err = newKernel("kernelDependencyErrors", testConfigFileName)
+	if err != nil { t.Errorf("TestKernelDependencyErrors new kernel is broken: %v", err); return }
+
+	kernel.AddComponent("testKernelOrderA", &testKernelOrderComponent{})
+	kernel.AddComponent("testKernelOrderB", &testKernelOrderComponent{})
+	kernel.AddDependency("testKernelOrderA", "testKernelOrderB")
+	kernel.AddDependency("testKernelOrderB", "testKernelOrderA")
+
+	err = kernel.Start()
+	if err == nil || !strings.Contains(err.Error(), "testKernelOrderA -> testKernelOrderB -> testKernelOrderA") {
+		t.Errorf("TestKernelDependencyErrors is broken - expected cycle error - received: %v", err)
+	}
+}
+
+type testKernelFailingComponent struct { testKernelOrderComponent }
+
+func (self *testKernelFailingComponent) Start() error { *self.calls = append(*self.calls, "start:" + self.id); return fmt.Errorf("start failed") }
+
+type testKernelFailingStopComponent struct { testKernelOrderComponent }
+
+func (self *testKernelFailingStopComponent) Stop() error { *self.calls = append(*self.calls, "stop:" + self.id); return fmt.Errorf("stop failed") }
+
+func TestKernelStartRollback(t *testing.T) {
+
+	kernel, err := newKernel("kernelStartRollback", testConfigFileName)
+	if err != nil { t.Errorf("TestKernelStartRollback new kernel is broken: %v", err); return }
+
+	var calls []string
+
+	kernel.AddComponentWithStartStopMethods("testKernelRollbackA", &testKernelOrderComponent{ id: "A", calls: &calls }, "Start", "Stop")
+	kernel.AddComponentWithStartStopMethods("testKernelRollbackB", &testKernelFailingStopComponent{ testKernelOrderComponent{ id: "B", calls: &calls } }, "Start", "Stop")
+	kernel.AddComponentWithStartStopMethods("testKernelRollbackC", &testKernelFailingComponent{ testKernelOrderComponent{ id: "C", calls: &calls } }, "Start", "Stop")
+	kernel.AddComponentWithStartStopMethods("testKernelRollbackD", &testKernelOrderComponent{ id: "D", calls: &calls }, "Start", "Stop")
+
+	kernel.StartWorker("rollback", func(ctx context.Context) error { <- ctx.Done(); return nil })
+
+	err = kernel.Start()
+	if err == nil { t.Errorf("TestKernelStartRollback is broken - start did not fail"); return }
+
+	aggregateErr, ok := err.(*AggregateError)
+	if !ok { t.Errorf("TestKernelStartRollback is broken - expected an aggregate error - received: %T", err); return }
+	if len(aggregateErr.Errors) != 2 { t.Errorf("TestKernelStartRollback is broken - expected the start and stop errors - received: %v", err) }
+
+	expected := []string{ "start:A", "start:B", "start:C", "stop:B", "stop:A" }
+	if strings.Join(calls, ",") != strings.Join(expected, ",") { t.Errorf("TestKernelStartRollback is broken - expected: %v - received: %v", expected, calls) }
+
+	if exists, _ := FileOrDirExists(kernel.Configuration.PidFile); exists { t.Errorf("TestKernelStartRollback is broken - pid file was not removed") }
+
+	if workers := kernel.Workers(); len(workers) != 0 { t.Errorf("TestKernelStartRollback is broken - workers not stopped: %v", workers) }
+
+	// Everything was stopped when the start failed.
+	if err := kernel.Stop(); err != nil || strings.Join(calls, ",") != strings.Join(expected, ",") { t.Errorf("TestKernelStartRollback is broken - stop after a failed start: %v - calls: %v", err, calls) }
+}
+
+type testKernelKernelLifecycle struct { started, stopped bool }
+
+func (self *testKernelKernelLifecycle) Start(kernel *Kernel) error { self.started = kernel != nil; return nil }
+func (self *testKernelKernelLifecycle) Stop(kernel *Kernel) error { self.stopped = kernel != nil; return nil }
+
+type testKernelContextLifecycle struct { started, stopped bool }
+
+func (self *testKernelContextLifecycle) Start(ctx context.Context, kernel *Kernel) error { self.started = ctx != nil && kernel != nil; return nil }
+func (self *testKernelContextLifecycle) Stop(ctx context.Context, kernel *Kernel) error { self.stopped = ctx != nil && kernel != nil; return nil }
+
+func TestKernelLifecycleInterfaces(t *testing.T) {
+
+	kernel, err := newKernel("kernelLifecycleInterfaces", testConfigFileName)
+	if err != nil { t.Errorf("TestKernelLifecycleInterfaces new kernel is broken: %v", err); return }
+
+	var calls []string
+
+	noParams := &testKernelOrderComponent{ id: "A", calls: &calls }
+	kernelParam := &testKernelKernelLifecycle{}
+	contextParam := &testKernelContextLifecycle{}
+
+	kernel.AddComponent("testKernelNoParams", noParams)
+	kernel.AddComponent("testKernelKernelParam", kernelParam)
+	kernel.AddComponent("testKernelContextParam", contextParam)
+
+	// The method names override the interfaces.
+	kernel.AddComponentWithStopMethod("testKernelMethodNames", &testKernelOrderComponent{ id: "B", calls: &calls }, "Stop")
+
+	if err := kernel.Start(); err != nil { t.Errorf("TestKernelLifecycleInterfaces start is broken: %v", err); return }
+
+	if !kernelParam.started || !contextParam.started { t.Errorf("TestKernelLifecycleInterfaces is broken - start not called") }
+
+	if err := kernel.Stop(); err != nil { t.Errorf("TestKernelLifecycleInterfaces stop is broken: %v", err); return }
+
+	if !kernelParam.stopped || !contextParam.stopped { t.Errorf("TestKernelLifecycleInterfaces is broken - stop not called") }
+
+	expected := []string{ "start:A", "stop:B", "stop:A" }
+	if strings.Join(calls, ",") != strings.Join(expected, ",") { t.Errorf("TestKernelLifecycleInterfaces is broken - expected: %v - received: %v", expected, calls) }
+}
+
+type testKernelSlowStopComponent struct { testKernelOrderComponent; ctxDone chan bool }
+
+func (self *testKernelSlowStopComponent) Stop(ctx context.Context, kernel *Kernel) error {
+	<- ctx.Done()
+	self.ctxDone <- true
+	select {} // Never returns
+}
+
+func TestKernelStopTimeout(t *testing.T) {
+
+	kernel, err := newKernel("kernelStopTimeout", testConfigFileName)
+	if err != nil { t.Errorf("TestKernelStopTimeout new kernel is broken: %v", err); return }
+
+	var calls []string
+
+	slow := &testKernelSlowStopComponent{ testKernelOrderComponent{ id: "B", calls: &calls }, make(chan bool, 1) }
+
+	kernel.AddComponent("testKernelStopTimeoutA", &testKernelOrderComponent{ id: "A", calls: &calls })
+	kernel.AddComponent("testKernelSlowStop", slow)
+
+	if err := kernel.Start(); err != nil { t.Errorf("TestKernelStopTimeout start is broken: %v", err); return }
+
+	startTime := time.Now()
+	err = kernel.Stop()
+	elapsed := time.Since(startTime)
+
+	if err == nil || !strings.Contains(err.Error(), "exceeded its deadline") { t.Errorf("TestKernelStopTimeout is broken - expected a deadline error - received: %v", err) }
+	if elapsed > 5 * time.Second { t.Errorf("TestKernelStopTimeout is broken - stop took: %v", elapsed) }
+
+	select {
+		case <- slow.ctxDone:
+		case <- time.After(time.Second): t.Errorf("TestKernelStopTimeout is broken - the context was not canceled")
+	}
+
+	// The kernel must move on and stop the other component.
+	expected := []string{ "start:A", "start:B", "stop:A" }
+	if strings.Join(calls, ",") != strings.Join(expected, ",") { t.Errorf("TestKernelStopTimeout is broken - expected: %v - received: %v", expected, calls) }
+}
+
+type testKernelSleepStopComponent struct { testKernelOrderComponent }
+
+func (self *testKernelSleepStopComponent) Stop(kernel *Kernel) error { time.Sleep(800 * time.Millisecond); return nil }
+
+func TestKernelShutdownBudget(t *testing.T) {
+
+	kernel, err := NewTestKernel("kernelShutdownBudget", map[string]interface{} { "kernel": map[string]interface{} { "shutdownTimeoutInMs": 1000 } })
+	if err != nil { t.Errorf("TestKernelShutdownBudget new kernel is broken: %v", err); return }
+
+	var calls []string
+	kernel.AddComponent("testKernelSleepStop", &testKernelSleepStopComponent{ testKernelOrderComponent{ id: "A", calls: &calls } })
+
+	if err := kernel.Start(); err != nil { t.Errorf("TestKernelShutdownBudget start is broken: %v", err); return }
+
+	release := make(chan bool)
+	defer close(release)
+
+	kernel.StartWorker("stuck", func(ctx context.Context) error { <- release; return nil })
+
+	startTime := time.Now()
+	err = kernel.Stop()
+	elapsed := time.Since(startTime)
+
+	// The workers get what is left of the budget after the components are stopped (without the shared
+	// budget, this would take 1.8 seconds).
+	if err == nil || !strings.Contains(err.Error(), "Workers did not stop in time: stuck") { t.Errorf("TestKernelShutdownBudget is broken - expected a worker error - received: %v", err) }
+	if elapsed > 1500 * time.Millisecond { t.Errorf("TestKernelShutdownBudget is broken - stop took: %v", elapsed) }
+}
+
+type testKernelReloadable struct { testKernelOrderComponent; reloaded int }
+
+func (self *testKernelReloadable) Reload(kernel *Kernel) error { self.reloaded++; return nil }
+
+func TestKernelSignals(t *testing.T) {
+
+	kernel, err := newKernel("kernelSignals", testConfigFileName)
+	if err != nil { t.Errorf("TestKernelSignals new kernel is broken: %v", err); return }
+
+	var buffer bytes.Buffer
+	kernel.Logger = Logger{ Prefix: "kernelSignals", Appenders: []Appender{ NewStringAppender(&buffer) } }
+
+	var calls []string
+	reloadable := &testKernelReloadable{ testKernelOrderComponent: testKernelOrderComponent{ id: "A", calls: &calls } }
+	kernel.AddComponent("testKernelReloadable", reloadable)
+
+	if err := kernel.Start(); err != nil { t.Errorf("TestKernelSignals start is broken: %v", err); return }
+
+	signalChannel := make(chan os.Signal, 3)
+	signalChannel <- syscall.SIGHUP
+	signalChannel <- syscall.SIGUSR1
+	signalChannel <- syscall.SIGTERM
+
+	if err := kernel.listenForSignals(signalChannel); err != nil { t.Errorf("TestKernelSignals stop is broken: %v", err) }
+
+	if reloadable.reloaded != 1 { t.Errorf("TestKernelSignals is broken - expected one reload - received: %d", reloadable.reloaded) }
+
+	expected := []string{ "start:A", "stop:A" }
+	if strings.Join(calls, ",") != strings.Join(expected, ",") { t.Errorf("TestKernelSignals is broken - expected: %v - received: %v", expected, calls) }
+
+	output := buffer.String()
+	if !strings.Contains(output, "testKernelReloadable - type: *dlshared.testKernelReloadable - start: Start (Starter)") { t.Errorf("TestKernelSignals is broken - components not in diagnostics: %s", output) }
+	if !strings.Contains(output, "goroutine stacks") || !strings.Contains(output, "TestKernelSignals") { t.Errorf("TestKernelSignals is broken - stacks not in diagnostics") }
+}
+
+type testKernelHealthComponent struct { detail string; err error; delay time.Duration }
+
+func (self *testKernelHealthComponent) CheckHealth(ctx context.Context) (string, error) {
+	select {
+		case <- time.After(self.delay): return self.detail, self.err
+		case <- ctx.Done(): return nadaStr, ctx.Err()
+	}
+}
+
+func TestKernelHealth(t *testing.T) {
+
+	kernel, err := newKernel("kernelHealth", testConfigFileName)
+	if err != nil { t.Errorf("TestKernelHealth new kernel is broken: %v", err); return }
+
+	healthy := &testKernelHealthComponent{ detail: "all good" }
+	kernel.AddComponent("testKernelHealthy", healthy)
+
+	slow := &testKernelHealthComponent{ detail: "fast" }
+	kernel.AddComponent("testKernelSlowHealth", slow)
+
+	if report := kernel.Health(); !report.Healthy || report.Ready || len(report.Components) != 2 { t.Errorf("TestKernelHealth is broken - not ready before start: %+v", report) }
+
+	if err := kernel.Start(); err != nil { t.Errorf("TestKernelHealth start is broken: %v", err); return }
+
+	report := kernel.Health()
+	if !report.Healthy || !report.Ready { t.Errorf("TestKernelHealth is broken - expected ready: %+v", report) }
+	if report.Components[0].ComponentId != "testKernelHealthy" || report.Components[0].Detail != "all good" { t.Errorf("TestKernelHealth is broken - component: %+v", report.Components[0]) }
+
+	response := httptest.NewRecorder()
+	kernel.ReadinessHandler(response, nil)
+	if response.Code != http.StatusOK || !strings.Contains(response.Body.String(), `"ready":true`) { t.Errorf("TestKernelHealth is broken - readiness: %d - %s", response.Code, response.Body.String()) }
+
+	// An unhealthy and a slow component make the kernel not ready.
+	healthy.err = fmt.Errorf("not good")
+	slow.delay = 5 * time.Second
+
+	startTime := time.Now()
+	report = kernel.Health()
+	if time.Since(startTime) > 2 * time.Second { t.Errorf("TestKernelHealth is broken - the timeout was not used") }
+
+	if report.Healthy || report.Ready || len(report.Components) != 2 { t.Errorf("TestKernelHealth is broken - expected not ready: %+v", report) }
+	if report.Components[0].Error != "not good" { t.Errorf("TestKernelHealth is broken - error: %+v", report.Components[0]) }
+	if !report.Components[1].TimedOut { t.Errorf("TestKernelHealth is broken - expected a time out: %+v", report.Components[1]) }
+
+	response = httptest.NewRecorder()
+	kernel.ReadinessHandler(response, nil)
+	if response.Code != http.StatusServiceUnavailable { t.Errorf("TestKernelHealth is broken - readiness: %d", response.Code) }
+
+	response = httptest.NewRecorder()
+	kernel.LivenessHandler(response, nil)
+	if response.Code != http.StatusOK { t.Errorf("TestKernelHealth is broken - liveness: %d", response.Code) }
+
+	if err := kernel.Stop(); err != nil { t.Errorf("TestKernelHealth stop is broken: %v", err) }
+
+	response = httptest.NewRecorder()
+	kernel.LivenessHandler(response, nil)
+	if response.Code != http.StatusServiceUnavailable { t.Errorf("TestKernelHealth is broken - liveness after stop: %d", response.Code) }
+}
+
+type testKernelAutowireLock interface { LockId() string }
+
+type testKernelAutowireLockImpl struct { testKernelOrderComponent }
+
+func (self *testKernelAutowireLockImpl) LockId() string { return self.id }
+
+type testKernelAutowireUser struct {
+	testKernelOrderComponent
+	Lock testKernelAutowireLock `dlinject:""`
+	Optional *testKernelHealthComponent `dlinject:",optional"`
+	OptionalById *testKernelOrderComponent `dlinject:"testKernelAutowireMissing,optional"`
+}
+
+func TestKernelAutowire(t *testing.T) {
+
+	var calls []string
+
+	kernel, err := NewTestKernel("kernelAutowire", nil)
+	if err != nil { t.Errorf("TestKernelAutowire new kernel is broken: %v", err); return }
+
+	// The lock is registered after the user, but it must be started first.
+	user := &testKernelAutowireUser{ testKernelOrderComponent: testKernelOrderComponent{ id: "user", calls: &calls } }
+	kernel.AddComponent("testKernelAutowireUser", user)
+	kernel.AddComponent("testKernelAutowireLock", &testKernelAutowireLockImpl{ testKernelOrderComponent{ id: "lock", calls: &calls } })
+
+	if err := kernel.Start(); err != nil { t.Errorf("TestKernelAutowire start is broken: %v", err); return }
+
+	if user.Lock == nil || user.Lock.LockId() != "lock" { t.Errorf("TestKernelAutowire is broken - the lock was not injected") }
+	if user.Optional != nil || user.OptionalById != nil { t.Errorf("TestKernelAutowire is broken - the optional fields must be nil") }
+
+	if strings.Join(calls, ",") != "start:lock,start:user" { t.Errorf("TestKernelAutowire is broken - start order: %v", calls) }
+
+	kernel.Stop()
+
+	// More than one match.
+	kernel, _ = NewTestKernel("kernelAutowire", nil)
+	kernel.AddComponent("testKernelAutowireUser", &testKernelAutowireUser{ testKernelOrderComponent: testKernelOrderComponent{ id: "user", calls: &calls } })
+	kernel.AddComponent("testKernelAutowireLock1", &testKernelAutowireLockImpl{ testKernelOrderComponent{ id: "lock1", calls: &calls } })
+	kernel.AddComponent("testKernelAutowireLock2", &testKernelAutowireLockImpl{ testKernelOrderComponent{ id: "lock2", calls: &calls } })
+
+	if err := kernel.Start(); err == nil || !strings.Contains(err.Error(), "more than one component") || !strings.Contains(err.Error(), "testKernelAutowireLock1, testKernelAutowireLock2") {
+		t.Errorf("TestKernelAutowire is broken - expected an ambiguous error - received: %v", err)
+	}
+
+	// No match.
+	kernel, _ = NewTestKernel("kernelAutowire", nil)
+	kernel.AddComponent("testKernelAutowireUser", &testKernelAutowireUser{ testKernelOrderComponent: testKernelOrderComponent{ id: "user", calls: &calls } })
+
+	if err := kernel.Start(); err == nil || !strings.Contains(err.Error(), "no component is assignable") { t.Errorf("TestKernelAutowire is broken - expected a missing error - received: %v", err) }
+}
+
+func TestKernelPidFile(t *testing.T) {
+
+	pidFileName := fmt.Sprintf("%s/dlshared_test_pid_file_%d.pid", os.TempDir(), os.Getpid())
+	defer os.Remove(pidFileName)
+
+	// A stale pid file is replaced.
+	if err := ioutil.WriteFile(pidFileName, []byte("2147483000"), 0644); err != nil { t.Errorf("TestKernelPidFile write is broken: %v", err); return }
+
+	kernel, err := NewTestKernel("kernelPidFile", map[string]interface{} { "pidFile": pidFileName })
+	if err != nil { t.Errorf("TestKernelPidFile new kernel is broken: %v", err); return }
+
+	if err := writePidFile(kernel.Kernel); err != nil { t.Errorf("TestKernelPidFile is broken - unable to replace stale file: %v", err); return }
+
+	if !strings.Contains(kernel.Logs(), "Replacing stale pid file") { t.Errorf("TestKernelPidFile is broken - stale file not reported: %s", kernel.Logs()) }
+
+	if data, _ := ioutil.ReadFile(pidFileName); string(data) != strconv.Itoa(os.Getpid()) { t.Errorf("TestKernelPidFile is broken - pid not written: %s", string(data)) }
+
+	// A second kernel can not use the same pid file.
+	other, _ := NewTestKernel("kernelPidFileOther", map[string]interface{} { "pidFile": pidFileName })
+
+	err = writePidFile(other.Kernel)
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("locked by another process - pid: %d - running: true", os.Getpid())) { t.Errorf("TestKernelPidFile is broken - expected a lock error - received: %v", err) }
+
+	// Stop removes the file and releases the lock.
+	if err := kernel.Stop(); err != nil { t.Errorf("TestKernelPidFile stop is broken: %v", err) }
+
+	if exists, _ := FileOrDirExists(pidFileName); exists { t.Errorf("TestKernelPidFile is broken - the pid file was not removed") }
+
+	if err := writePidFile(other.Kernel); err != nil { t.Errorf("TestKernelPidFile is broken - unable to write after stop: %v", err) }
+
+	if err := removePidFile(other.Kernel); err != nil { t.Errorf("TestKernelPidFile remove is broken: %v", err) }
+}
+
+func TestKernelWorkers(t *testing.T) {
+
+	kernel, err := NewTestKernel("kernelWorkers", map[string]interface{} {
+		"kernel": map[string]interface{} { "workers": map[string]interface{} { "initialBackoffInMs": 1, "maxBackoffInMs": 5, "maxRestarts": 3 } },
+	})
+
+	if err != nil { t.Errorf("TestKernelWorkers new kernel is broken: %v", err); return }
+
+	if err := kernel.Start(); err != nil { t.Errorf("TestKernelWorkers start is broken: %v", err); return }
+
+	// This panics twice and then runs until it is stopped.
+	calls := 0
+	recovered := kernel.StartWorker("recovered", func(ctx context.Context) error {
+		if calls++; calls <= 2 { panic("test panic") }
+		<- ctx.Done()
+		return nil
+	})
+
+	failed := kernel.StartWorker("failed", func(ctx context.Context) error { return fmt.Errorf("always fails") })
+
+	completed := kernel.StartWorker("completed", func(ctx context.Context) error { return nil })
+
+	waitForState := func(worker *Worker, state WorkerState) {
+		for i := 0; i < 200 && worker.Status().State != state; i++ { time.Sleep(5 * time.Millisecond) }
+		if worker.Status().State != state { t.Errorf("TestKernelWorkers is broken - worker: %s - expected: %s - received: %v", worker.Name(), state, worker.Status()) }
+	}
+
+	waitForState(failed, WorkerFailed)
+	waitForState(completed, WorkerCompleted)
+
+	for i := 0; i < 200 && recovered.Status().Restarts < 2; i++ { time.Sleep(5 * time.Millisecond) }
+	waitForState(recovered, WorkerRunning)
+
+	if status := recovered.Status(); status.Restarts != 2 || !strings.Contains(status.LastError, "test panic") { t.Errorf("TestKernelWorkers is broken - recovered status: %v", status) }
+	if status := failed.Status(); status.Restarts != 3 || status.LastError != "always fails" { t.Errorf("TestKernelWorkers is broken - failed status: %v", status) }
+
+	// The completed worker is removed.
+	if report := kernel.Health(); report.Healthy || len(report.Workers) != 2 { t.Errorf("TestKernelWorkers is broken - a failed worker must fail the health check: %+v", report) }
+
+	if err := kernel.Stop(); err != nil { t.Errorf("TestKernelWorkers stop is broken: %v", err) }
+
+	waitForState(recovered, WorkerStopped)
+
+	if workers := kernel.Workers(); len(workers) != 1 || workers[0].Name != "failed" { t.Errorf("TestKernelWorkers is broken - workers: %v", workers) }
+
+	// Stop can be called more than once.
+	recovered.Stop()
+}
+
+func TestKernelEventListeners(t *testing.T) {
+
+	kernel, err := NewTestKernel("kernelEvents", nil)
+	if err != nil { t.Errorf("TestKernelEventListeners new kernel is broken: %v", err); return }
+
+	var events []string
+	var readyElapsed time.Duration
+
+	kernel.AddEventListener(func(event *KernelEvent) {
+		events = append(events, strings.TrimSpace(fmt.Sprintf("%s %s", event.Type, event.ComponentId)))
+		if event.Type == KernelReadyEvent { readyElapsed = event.Elapsed }
+	})
+
+	// A panic in a listener must not stop the kernel.
+	kernel.AddEventListener(func(event *KernelEvent) { panic("listener panic") })
+
+	var calls []string
+	kernel.AddComponent("A", &testKernelOrderComponent{ id: "A", calls: &calls })
+	kernel.AddComponent("B", &testKernelOrderComponent{ id: "B", calls: &calls })
+
+	if err := kernel.Start(); err != nil { t.Errorf("TestKernelEventListeners start is broken: %v", err); return }
+	if err := kernel.Stop(); err != nil { t.Errorf("TestKernelEventListeners stop is broken: %v", err); return }
+
+	expected := []string{
+		"componentRegistered A", "componentRegistered B",
+		"kernelStarting",
+		"componentInjected A", "componentInjected B",
+		"componentStarting A", "componentStarted A", "componentStarting B", "componentStarted B",
+		"kernelReady",
+		"kernelStopping",
+		"componentStopping B", "componentStopped B", "componentStopping A", "componentStopped A",
+		"kernelStopped",
+	}
+
+	if strings.Join(events, ",") != strings.Join(expected, ",") { t.Errorf("TestKernelEventListeners is broken - expected: %v - received: %v", expected, events) }
+	if readyElapsed <= 0 { t.Errorf("TestKernelEventListeners is broken - the ready event has no elapsed time") }
+	if !strings.Contains(kernel.Logs(), "Kernel event listener panicked") { t.Errorf("TestKernelEventListeners is broken - listener panic not logged") }
+
+	// The failed events.
+	kernel, _ = NewTestKernel("kernelEvents", nil)
+
+	var failures []*KernelEvent
+	kernel.AddEventListener(func(event *KernelEvent) { if event.Err != nil { failures = append(failures, event) } })
+
+	kernel.AddComponent("A", &testKernelFailingComponent{ testKernelOrderComponent{ id: "A", calls: &calls } })
+
+	if err := kernel.Start(); err == nil { t.Errorf("TestKernelEventListeners is broken - expected a start error"); return }
+
+	if len(failures) != 2 || failures[0].Type != ComponentStartFailedEvent || failures[0].ComponentId != "A" || failures[1].Type != KernelStartFailedEvent {
+		t.Errorf("TestKernelEventListeners is broken - failed events: %v", failures)
+	}
+}
+
+func TestKernelLoggerFormat(t *testing.T) {
+
+	for format, expected := range map[string]string { "": "*dlshared.FileAppender", "text": "*dlshared.FileAppender", "json": "*dlshared.JsonAppender" } {
+
+		values := map[string]interface{} { "version": "1.0.0", "environment": "test" }
+		if len(format) > 0 { values["logger"] = map[string]interface{} { "format": format } }
+
+		configuration, err := NewConfigurationFromMap(values)
+		if err != nil { t.Errorf("TestKernelLoggerFormat is broken: %v", err); return }
+
+		appenders, err := configureLogger("kernelLoggerFormat", configuration)
+		if err != nil || len(appenders) != 1 { t.Errorf("TestKernelLoggerFormat is broken - format: %s - %v", format, err); continue }
+
+		if appender := fmt.Sprintf("%T", appenders[0].(*LevelFilterAppender).Appender); appender != expected { t.Errorf("TestKernelLoggerFormat is broken - format: %s - appender: %s", format, appender) }
+	}
+
+	configuration, _ := NewConfigurationFromMap(map[string]interface{} { "version": "1.0.0", "environment": "test", "logger": map[string]interface{} { "format": "xml" } })
+	if _, err := configureLogger("kernelLoggerFormat", configuration); err == nil { t.Errorf("TestKernelLoggerFormat is broken - expected an invalid format error") }
+}
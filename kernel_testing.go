@@ -0,0 +1,115 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"fmt"
+	"sync"
+	"bytes"
+)
+
+const (
+	testKernelVersion = "0.0.0"
+	testKernelEnvironment = "test"
+)
+
+// The test kernel is a kernel that is created from an in-memory configuration map. It does not write
+// a pid file and the logs are captured in memory (see Logs). Use this to unit test components without
+// a configuration file or running infrastructure. Registered components can be replaced with fakes
+// (see ReplaceComponent).
+type TestKernel struct {
+	*Kernel
+	logs *memoryAppender
+}
+
+// Create a test kernel. The values are in the same format as the configuration file. If the version
+// or environment are not set, "0.0.0" and "test" are used. The values map passed is not modified.
+func NewTestKernel(id string, values map[string]interface{}) (*TestKernel, error) {
+
+	confValues := make(map[string]interface{}, len(values) + 2)
+	for key, value := range values { confValues[key] = value }
+
+	if _, found := confValues["version"]; !found { confValues["version"] = testKernelVersion }
+	if _, found := confValues["environment"]; !found { confValues["environment"] = testKernelEnvironment }
+
+	conf, err := NewConfigurationFromMap(confValues)
+	if err != nil { return nil, err }
+
+	logs := &memoryAppender{}
+
+	return &TestKernel{ Kernel: newKernelWithConfiguration(id, conf, Logger{ Prefix: id, Appenders: []Appender{ logs } }), logs: logs }, nil
+}
+
+// Create and start a test kernel. The fakes replace the components in the configuration and the components
+// registered by the add components function (the key is the component id). See ReplaceComponent.
+func StartTestKernel(id string, values map[string]interface{}, addComponentsFunction func(kernel *Kernel), fakes map[string]interface{}) (*TestKernel, error) {
+
+	kernel, err := NewTestKernel(id, values)
+	if err != nil { return nil, err }
+
+	if err = kernel.AddComponentsFromConfiguration(); err != nil { return nil, err }
+
+	if addComponentsFunction != nil { addComponentsFunction(kernel.Kernel) }
+
+	for componentId, fake := range fakes {
+		if !kernel.HasComponent(componentId) { return nil, NewStackError("Unable to replace component: %s - reason: not registered", componentId) }
+		kernel.ReplaceComponent(componentId, fake)
+	}
+
+	if err = kernel.Start(); err != nil { return nil, err }
+
+	return kernel, nil
+}
+
+// Replace a registered component with a fake. The lifecycle interfaces are detected on the fake (the
+// start/stop methods of the replaced component are not used). The fake must be assignable to the fields
+// the component is injected into. This must be called before Start. This method will panic if the
+// component is not registered.
+func (self *TestKernel) ReplaceComponent(componentId string, fake interface{}) {
+
+	if !self.HasComponent(componentId) { panic(fmt.Sprintf("TestKernel.ReplaceComponent called with an invalid component id: %s", componentId)) }
+
+	component := newComponent(componentId, fake)
+
+	for i := range self.components {
+		if self.components[i].componentId == componentId { self.components[i] = component }
+	}
+
+	self.Components[componentId] = component
+}
+
+// Returns the logs written by the kernel and the components that use the kernel logger.
+func (self *TestKernel) Logs() string { return self.logs.String() }
+
+// An appender that stores the formatted logs in memory. This is safe to use from multiple goroutines.
+type memoryAppender struct {
+	lock sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (self *memoryAppender) Append(log *Log) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	_, err := self.buffer.WriteString(FormatLog(log))
+	return err
+}
+
+func (self *memoryAppender) String() string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.buffer.String()
+}
@@ -0,0 +1,68 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"strings"
+	"testing"
+)
+
+type testKernelTestingUser struct {
+	Logger
+	Lock DistributedLock `dlinject:"testKernelTestingLock"`
+	started bool
+}
+
+func (self *testKernelTestingUser) Start() error { self.Logf(Info, "Test kernel user started - lock: %s", self.Lock.LockId()); self.started = true; return nil }
+
+type testKernelTestingFakeLock struct { started, stopped bool }
+
+func (self *testKernelTestingFakeLock) Start(kernel *Kernel) error { self.started = true; return nil }
+func (self *testKernelTestingFakeLock) Stop(kernel *Kernel) error { self.stopped = true; return nil }
+func (self *testKernelTestingFakeLock) Lock() { }
+func (self *testKernelTestingFakeLock) TryLock() bool { return true }
+func (self *testKernelTestingFakeLock) Unlock() { }
+func (self *testKernelTestingFakeLock) HasLock() bool { return true }
+func (self *testKernelTestingFakeLock) LockId() string { return "fakeLock" }
+
+func TestStartTestKernel(t *testing.T) {
+
+	user := &testKernelTestingUser{}
+	fake := &testKernelTestingFakeLock{}
+
+	// The real lock needs mongo - the fake does not.
+	kernel, err := StartTestKernel("testKernel", map[string]interface{} { "pidFile": "/tmp/dlshared_test_kernel_should_not_exist.pid" }, func(kernel *Kernel) {
+		kernel.AddComponent("testKernelTestingLock", NewMongoDistributedLock("testLockId", "MongoTestDb", "test", "locks", 1, 1, 2, 86400))
+		kernel.AddComponent("testKernelTestingUser", user)
+	}, map[string]interface{} { "testKernelTestingLock": fake })
+
+	if err != nil { t.Errorf("StartTestKernel is broken: %v", err); return }
+
+	if !user.started || !fake.started { t.Errorf("StartTestKernel is broken - components not started") }
+	if kernel.GetComponent("testKernelTestingLock") != fake { t.Errorf("StartTestKernel is broken - the fake was not registered") }
+
+	if kernel.Configuration.Version != "0.0.0" || !kernel.Configuration.EnvironmentIs("test") { t.Errorf("StartTestKernel is broken - default version/environment not set") }
+
+	if exists, _ := FileOrDirExists("/tmp/dlshared_test_kernel_should_not_exist.pid"); exists || len(kernel.pidFileName) > 0 { t.Errorf("StartTestKernel is broken - the pid file was written") }
+
+	if err := kernel.Stop(); err != nil { t.Errorf("StartTestKernel stop is broken: %v", err) }
+	if !fake.stopped { t.Errorf("StartTestKernel is broken - the fake was not stopped") }
+
+	if !strings.Contains(kernel.Logs(), "Test kernel user started - lock: fakeLock") { t.Errorf("StartTestKernel is broken - logs not captured: %s", kernel.Logs()) }
+
+	if _, err := StartTestKernel("testKernel", nil, nil, map[string]interface{} { "missing": fake }); err == nil { t.Errorf("StartTestKernel is broken - expected an error for a missing fake id") }
+}
@@ -0,0 +1,275 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"fmt"
+	"time"
+	"sync"
+	"context"
+	"strings"
+)
+
+// A worker function. The function should run until the context is canceled. If the function returns
+// an error or panics, the kernel restarts it (see StartWorker). If the function returns nil, the worker
+// is complete and it is not restarted.
+type WorkerFunc func(ctx context.Context) error
+
+type WorkerState string
+
+const (
+	WorkerRunning = WorkerState("running")
+	WorkerRestarting = WorkerState("restarting") // Waiting on the backoff before restarting.
+	WorkerCompleted = WorkerState("completed") // The function returned nil.
+	WorkerStopped = WorkerState("stopped") // The worker was stopped.
+	WorkerFailed = WorkerState("failed") // The restart intensity limit was exceeded.
+)
+
+// The worker restart settings are set in the configuration file. These are the defaults:
+//
+//    "kernel": {
+//        "workers": {
+//            "initialBackoffInMs": 100,
+//            "maxBackoffInMs": 30000,
+//            "maxRestarts": 5,
+//            "restartPeriodInMs": 60000
+//        }
+//    }
+//
+// The backoff doubles after each restart, up to the max. If a worker is restarted more than maxRestarts
+// times in the restart period, the kernel gives up and the worker is marked as failed (and the kernel
+// health check fails).
+const (
+	kernelWorkersConfigPath = "kernel.workers"
+	defaultWorkerInitialBackoffInMs = 100
+	defaultWorkerMaxBackoffInMs = 30000
+	defaultWorkerMaxRestarts = 5
+	defaultWorkerRestartPeriodInMs = 60000
+)
+
+// A kernel supervised goroutine. Create with kernel.StartWorker.
+type Worker struct {
+	name string
+	fn WorkerFunc
+	kernel *Kernel
+
+	ctx context.Context
+	cancel context.CancelFunc
+	done chan bool
+
+	initialBackoff time.Duration
+	maxBackoff time.Duration
+	maxRestarts int
+	restartPeriod time.Duration
+
+	lock sync.Mutex
+	state WorkerState
+	restarts int
+	lastError error
+	started time.Time
+}
+
+// The worker status.
+type WorkerStatus struct {
+	Name string `json:"name"`
+	State WorkerState `json:"state"`
+	Restarts int `json:"restarts"`
+	LastError string `json:"lastError,omitempty"`
+	Started time.Time `json:"started"`
+}
+
+// Start a named worker goroutine. If the function panics or returns an error, it is restarted with an
+// exponential backoff. The workers are stopped (the context is canceled) and joined when the kernel is
+// stopped. Components should stop their own workers in their Stop method if they must be stopped first.
+func (self *Kernel) StartWorker(name string, fn WorkerFunc) *Worker {
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	worker := &Worker{
+		name: name,
+		fn: fn,
+		kernel: self,
+		ctx: ctx,
+		cancel: cancel,
+		done: make(chan bool),
+		initialBackoff: time.Duration(self.Configuration.IntWithPath(kernelWorkersConfigPath, "initialBackoffInMs", defaultWorkerInitialBackoffInMs)) * time.Millisecond,
+		maxBackoff: time.Duration(self.Configuration.IntWithPath(kernelWorkersConfigPath, "maxBackoffInMs", defaultWorkerMaxBackoffInMs)) * time.Millisecond,
+		maxRestarts: self.Configuration.IntWithPath(kernelWorkersConfigPath, "maxRestarts", defaultWorkerMaxRestarts),
+		restartPeriod: time.Duration(self.Configuration.IntWithPath(kernelWorkersConfigPath, "restartPeriodInMs", defaultWorkerRestartPeriodInMs)) * time.Millisecond,
+		state: WorkerRunning,
+		started: time.Now(),
+	}
+
+	self.workerLock.Lock()
+	self.workers = append(self.workers, worker)
+	self.workerLock.Unlock()
+
+	go worker.run()
+
+	return worker
+}
+
+// Returns the status of the workers. The workers that completed or were stopped are removed, the failed
+// workers are kept (they fail the health check).
+func (self *Kernel) Workers() []WorkerStatus {
+	self.workerLock.Lock()
+	defer self.workerLock.Unlock()
+
+	statuses := make([]WorkerStatus, len(self.workers))
+	for i := range self.workers { statuses[i] = self.workers[i].Status() }
+	return statuses
+}
+
+// Stop all of the workers and wait for them to return. If the deadline is set (see shutdownDeadline),
+// this does not wait past the deadline.
+func (self *Kernel) stopWorkers(deadline time.Time) []error {
+
+	self.workerLock.Lock()
+	workers := make([]*Worker, len(self.workers))
+	copy(workers, self.workers)
+	self.workerLock.Unlock()
+
+	for _, worker := range workers { worker.cancel() }
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() { timeout = time.After(time.Until(deadline)) }
+
+	var waiting []string
+	for _, worker := range workers {
+
+		// A worker that is done is not reported if the budget is used up.
+		select {
+			case <- worker.done: continue
+			default:
+		}
+
+		select {
+			case <- worker.done:
+			case <- timeout: waiting = append(waiting, worker.name)
+		}
+	}
+
+	if len(waiting) > 0 {
+		self.Logf(Error, "Workers did not stop in time - moving on - workers: %s", strings.Join(waiting, ", "))
+		return []error{ NewStackError("Workers did not stop in time: %s", strings.Join(waiting, ", ")) }
+	}
+
+	return nil
+}
+
+func (self *Kernel) removeWorker(worker *Worker) {
+	self.workerLock.Lock()
+	defer self.workerLock.Unlock()
+
+	for i := range self.workers {
+		if self.workers[i] == worker { self.workers = append(self.workers[:i], self.workers[i+1:]...); return }
+	}
+}
+
+// Stop the worker and wait for it to return. This can be called more than once.
+func (self *Worker) Stop() {
+	self.cancel()
+	<- self.done
+}
+
+func (self *Worker) Name() string { return self.name }
+
+func (self *Worker) Status() WorkerStatus {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	status := WorkerStatus{ Name: self.name, State: self.state, Restarts: self.restarts, Started: self.started }
+	if self.lastError != nil { status.LastError = self.lastError.Error() }
+	return status
+}
+
+func (self *Worker) setState(state WorkerState, err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.state = state
+	if err != nil { self.lastError = err }
+}
+
+func (self *Worker) run() {
+
+	defer close(self.done)
+
+	// The worker is removed before it is done, so the kernel does not list it after Stop returns.
+	defer func() { if self.Status().State != WorkerFailed { self.kernel.removeWorker(self) } }()
+
+	backoff := self.initialBackoff
+
+	var restartTimes []time.Time
+
+	for {
+		startTime := time.Now()
+
+		err := self.call()
+
+		if self.ctx.Err() != nil { self.setState(WorkerStopped, err); return }
+
+		if err == nil { self.setState(WorkerCompleted, nil); return }
+
+		self.kernel.Logf(Error, "Worker: %s - failed - err: %v", self.name, err)
+
+		// Check the restart intensity. Only the restarts in the period are counted.
+		now := time.Now()
+		recent := restartTimes[:0]
+		for _, restartTime := range restartTimes { if now.Sub(restartTime) < self.restartPeriod { recent = append(recent, restartTime) } }
+		restartTimes = recent
+
+		if len(restartTimes) >= self.maxRestarts {
+			self.setState(WorkerFailed, err)
+			self.kernel.Logf(Error, "Worker: %s - restarted %d times in %v - giving up", self.name, len(restartTimes), self.restartPeriod)
+			return
+		}
+
+		// If the worker ran for a while, start the backoff over.
+		if now.Sub(startTime) > self.restartPeriod { backoff = self.initialBackoff }
+
+		self.setState(WorkerRestarting, err)
+
+		select {
+			case <- time.After(backoff):
+			case <- self.ctx.Done(): self.setState(WorkerStopped, nil); return
+		}
+
+		if backoff *= 2; backoff > self.maxBackoff { backoff = self.maxBackoff }
+
+		restartTimes = append(restartTimes, time.Now())
+
+		self.lock.Lock()
+		self.restarts++
+		self.state = WorkerRunning
+		self.started = time.Now()
+		self.lock.Unlock()
+
+		self.kernel.Logf(Warn, "Worker: %s - restarted - restarts: %d", self.name, len(restartTimes))
+	}
+}
+
+// Call the worker function and return a panic as an error.
+func (self *Worker) call() (err error) {
+	defer func() {
+		if r := recover(); r != nil { err = NewStackError("Worker: %s - panicked - problem: %v", self.name, r) }
+	}()
+	return self.fn(self.ctx)
+}
+
+func (self WorkerStatus) String() string {
+	return fmt.Sprintf("%s - state: %s - restarts: %d - last error: %s", self.Name, self.State, self.Restarts, self.LastError)
+}
@@ -17,10 +17,18 @@
 package dlshared
 
 import (
+	"io"
 	"os"
 	"fmt"
+	"math"
+	"sync"
+	"time"
 	"bytes"
+	"strings"
+	"strconv"
+	"sync/atomic"
 	"log/syslog"
+	"encoding/json"
 )
 
 // -------------------------------------
@@ -34,12 +42,35 @@ func FormatLog(log *Log) string {
 	year, month, day := log.Timestamp.Date()
 	hour, min, sec := log.Timestamp.Clock()
 
-	return fmt.Sprintf("[%.4d/%.2d/%.2d %.2d:%.2d:%.2d] [%v.%v] [%v:%d] %v\n",
+	return fmt.Sprintf("[%.4d/%.2d/%.2d %.2d:%.2d:%.2d] [%v.%v] [%v:%d] %v%v\n",
 		year, month, day,
 		hour, min, sec,
 		log.Prefix, log.Level.Type(),
 		log.Filename, log.Line,
-		log.Message())
+		log.Message(), FormatLogFields(log.Fields))
+}
+
+// Returns the fields as key=value pairs with a leading space (e.g., ` jobId=a user="Jane Doe"`)
+// or an empty string if there are no fields. The values are quoted if they contain a space,
+// a quote or an equal sign.
+func FormatLogFields(fields []LogField) string {
+	if len(fields) == 0 { return "" }
+
+	var buffer bytes.Buffer
+
+	for _, field := range fields {
+		value := fmt.Sprint(field.Value)
+		if err, ok := field.Value.(error); ok { value = err.Error() }
+
+		if len(value) == 0 || strings.ContainsAny(value, " =\"\t\r\n") { value = strconv.Quote(value) }
+
+		buffer.WriteString(" ")
+		buffer.WriteString(field.Key)
+		buffer.WriteString("=")
+		buffer.WriteString(value)
+	}
+
+	return buffer.String()
 }
 
 // -------------------------------------
@@ -74,11 +105,91 @@ func formatSyslogLog(log *Log) string {
 	year, month, day := log.Timestamp.Date()
 	hour, min, sec := log.Timestamp.Clock()
 
-	return fmt.Sprintf("[%.4d/%.2d/%.2d %.2d:%.2d:%.2d] [%v] [%v:%d] %v\n",
+	return fmt.Sprintf("[%.4d/%.2d/%.2d %.2d:%.2d:%.2d] [%v] [%v:%d] %v%v\n",
 		year, month, day, hour, min, sec,
 		log.Level.Type(),
 		log.Filename, log.Line,
-		log.Message())
+		log.Message(), FormatLogFields(log.Fields))
+}
+
+// -------------------------------------
+// The json appender
+
+// Writes each log as a json object on one line (see FormatJsonLog). The appender can be
+// used by concurrent loggers.
+type JsonAppender struct {
+	writer io.Writer
+	lock sync.Mutex
+}
+
+func NewJsonAppender(writer io.Writer) *JsonAppender { return &JsonAppender{ writer: writer } }
+
+func (self *JsonAppender) Append(log *Log) error {
+
+	line, err := FormatJsonLog(log)
+	if err != nil { return err }
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	_, err = self.writer.Write(line)
+	return err
+}
+
+// The properties set for every log. A field with one of these keys is renamed (e.g., "fields.level").
+var jsonLogKeys = map[string]bool { "time": true, "level": true, "prefix": true, "file": true, "line": true, "message": true }
+
+// Returns the log as a json object followed by a newline. The fields are properties of the object:
+//
+//    {"file":"dlshared/cron.go","jobId":"a","level":"info","line":42,"message":"Cron job finished","prefix":"app","time":"2014-06-01T12:00:00.5Z"}
+//
+// The errors are written as the error message and the values that cannot be encoded as json are
+// written as strings.
+func FormatJsonLog(log *Log) ([]byte, error) {
+
+	doc := make(map[string]interface{}, len(log.Fields) + len(jsonLogKeys))
+
+	for _, field := range log.Fields {
+		key := field.Key
+		if jsonLogKeys[key] { key = "fields." + key }
+		doc[key] = jsonLogValue(field.Value)
+	}
+
+	doc["time"] = log.Timestamp.Format(time.RFC3339Nano)
+	doc["level"] = log.Level.Type()
+	doc["prefix"] = log.Prefix
+	doc["file"] = log.Filename
+	doc["line"] = log.Line
+	doc["message"] = log.Message()
+
+	var buffer bytes.Buffer
+
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+
+	// The encoder adds the newline.
+	if err := encoder.Encode(doc); err != nil { return nil, err }
+
+	return buffer.Bytes(), nil
+}
+
+func jsonLogValue(value interface{}) interface{} {
+	switch v := value.(type) {
+		case nil, string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64: return v
+		case float32: return jsonLogValue(float64(v))
+		case float64: {
+			// NaN and infinity are not valid json numbers.
+			if math.IsNaN(v) || math.IsInf(v, 0) { return fmt.Sprint(v) }
+			return v
+		}
+		case error: return v.Error()
+		case json.Marshaler: return v
+		case fmt.Stringer: return v.String()
+	}
+
+	if _, err := json.Marshal(value); err != nil { return fmt.Sprintf("%+v", value) }
+
+	return value
 }
 
 // -------------------------------------
@@ -139,9 +250,30 @@ func (self *FilterAppender) Append(log *Log) error {
 	return self.Appender.Append(log)
 }
 
+// A level filter with a threshold that can be changed while logging (e.g., when the
+// configuration is reloaded).
+type LevelFilterAppender struct {
+	Appender Appender
+	threshold int32
+}
+
+func NewLevelFilterAppender(threshold Level, appender Appender) *LevelFilterAppender {
+	return &LevelFilterAppender{ Appender: appender, threshold: int32(threshold) }
+}
+
+// A threshold of Off drops every log (Off is the lowest level, so it would pass everything).
+func (self *LevelFilterAppender) Append(log *Log) error {
+	if threshold := self.Level(); threshold == Off || log.Level < threshold { return nil }
+	return self.Appender.Append(log)
+}
+
+func (self *LevelFilterAppender) Level() Level { return Level(atomic.LoadInt32(&self.threshold)) }
+
+func (self *LevelFilterAppender) SetLevel(threshold Level) { atomic.StoreInt32(&self.threshold, int32(threshold)) }
+
 func LevelFilter(threshold Level, appender Appender) *FilterAppender {
 	filterFunc := func(log *Log) bool {
-		return log.Level >= threshold
+		return threshold != Off && log.Level >= threshold
 	}
 
 	return &FilterAppender{
@@ -59,6 +59,10 @@ func (self *LogCache) Add(log *Log) {
 }
 
 func (self *LogCache) Len() int {
+	if len(self.items) == 0 {
+		return 0
+	}
+
 	if self.items[self.idx] == nil {
 		return self.idx
 	}
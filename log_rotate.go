@@ -0,0 +1,357 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"io"
+	"os"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"strings"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// Appenders (and writers) that write to files implement this interface so the files can be reopened
+// after they are moved (e.g., by logrotate). The kernel reopens the appenders when it is reloaded (see
+// Kernel.Reload), so a SIGHUP reopens the files.
+type Reopenable interface { Reopen() error }
+
+// The configuration for a RotatingFileAppender. The file is rotated when a write would make it larger
+// than the max size and/or when the rotate interval has passed. The intervals start at the zero time in
+// UTC (e.g., "24h" rotates at midnight UTC). Zero disables the size or time rotation. The rotated files are
+// gzipped if compress is set. If max files is set, only the newest rotated files are kept. If max age in
+// days is set, the rotated files that were last written before then are removed.
+type RotatingFileAppenderConfig struct {
+	FileName string `dlconfig:"fileName,required"`
+	MaxSize ByteSize `dlconfig:"maxSize,default=0"`
+	RotateInterval time.Duration `dlconfig:"rotateInterval,default=0"`
+	Compress bool `dlconfig:"compress,default=true"`
+	MaxFiles int `dlconfig:"maxFiles,default=0"`
+	MaxAgeInDays int `dlconfig:"maxAgeInDays,default=0"`
+}
+
+// The rotated files are named with the time they were rotated (e.g., "app.log.20140601-120000.000.gz").
+const (
+	rotatedLogTimeFormat = "20060102-150405.000"
+	gzipExtension = ".gz"
+	tmpExtension = ".tmp"
+)
+
+// A file appender that rotates the file. The logs are formatted with FormatLog. The appender is also an
+// io.Writer, so it can be used with a JsonAppender. The appender can be used by concurrent loggers. The
+// rotated files are compressed and removed in the background.
+type RotatingFileAppender struct {
+	config RotatingFileAppenderConfig
+	lock sync.Mutex
+	file *os.File
+	size int64
+	nextRotation time.Time
+	cleanupLock sync.Mutex
+	cleanupWaitGroup sync.WaitGroup
+	now func() time.Time
+}
+
+func NewRotatingFileAppender(config *RotatingFileAppenderConfig) (*RotatingFileAppender, error) {
+
+	if config == nil || len(config.FileName) == 0 { return nil, NewStackError("Unable to create rotating file appender - the file name is not set") }
+
+	if config.MaxSize < 0 || config.RotateInterval < 0 || config.MaxFiles < 0 || config.MaxAgeInDays < 0 {
+		return nil, NewStackError("Unable to create rotating file appender: %s - the limits must not be negative", config.FileName)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(config.FileName), 0750); err != nil { return nil, NewStackError("Unable to create log dir for: %s - err: %v", config.FileName, err) }
+
+	appender := &RotatingFileAppender{ config: *config, now: time.Now }
+
+	if err := appender.open(); err != nil { return nil, err }
+
+	// Remove the files that expired while the process was not running.
+	appender.cleanup()
+
+	return appender, nil
+}
+
+func (self *RotatingFileAppender) Append(log *Log) error {
+	_, err := self.Write([]byte(FormatLog(log)))
+	return err
+}
+
+// Write to the file. The file is rotated first if required. If the rotation fails, the bytes are written
+// to the current file and the rotation error is returned (the rotation is tried again on the next write).
+// If the appender is closed, the file is opened again (e.g., a log after the kernel is stopped).
+func (self *RotatingFileAppender) Write(p []byte) (int, error) {
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.file == nil { if err := self.open(); err != nil { return 0, err } }
+
+	var rotateErr error
+
+	switch {
+		case self.rotateRequired(len(p)): rotateErr = self.rotate()
+
+		// An empty file is not rotated, the interval is moved instead.
+		case self.size == 0 && self.config.RotateInterval > 0 && !self.now().Before(self.nextRotation): {
+			self.nextRotation = self.now().Truncate(self.config.RotateInterval).Add(self.config.RotateInterval)
+		}
+	}
+
+	written, err := self.file.Write(p)
+	self.size += int64(written)
+
+	if err != nil { return written, err }
+
+	return written, rotateErr
+}
+
+// Rotate the file now. An empty file is not rotated.
+func (self *RotatingFileAppender) Rotate() error {
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if self.file == nil { return NewStackError("Unable to rotate log file: %s - the appender is closed", self.config.FileName) }
+	if self.size == 0 { return nil }
+
+	return self.rotate()
+}
+
+// Close the file and open it again. This is used when the file was moved by another process (e.g., logrotate).
+func (self *RotatingFileAppender) Reopen() error {
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.open()
+}
+
+// Close the file and wait for the rotated files to be compressed. The kernel closes the appender when it is
+// stopped (see Kernel.Stop). If the appender is used after it is closed, the file is opened again.
+func (self *RotatingFileAppender) Close() error {
+
+	self.lock.Lock()
+
+	var err error
+	if self.file != nil {
+		err = self.file.Close()
+		self.file = nil
+	}
+
+	self.lock.Unlock()
+
+	self.cleanupWaitGroup.Wait()
+
+	return err
+}
+
+func (self *RotatingFileAppender) rotateRequired(length int) bool {
+
+	if self.size == 0 { return false }
+
+	if self.config.MaxSize > 0 && self.size + int64(length) > int64(self.config.MaxSize) { return true }
+
+	return self.config.RotateInterval > 0 && !self.now().Before(self.nextRotation)
+}
+
+// Must be called while holding the lock. The file is renamed while it is open, so the logs are written to
+// the current file until the new file is open.
+func (self *RotatingFileAppender) rotate() error {
+
+	rotatedFileName := self.rotatedFileName()
+
+	if err := os.Rename(self.config.FileName, rotatedFileName); err != nil && !os.IsNotExist(err) {
+		return NewStackError("Unable to rotate log file: %s - err: %v", self.config.FileName, err)
+	}
+
+	if err := self.open(); err != nil { return err }
+
+	self.cleanupWaitGroup.Add(1)
+
+	go func() {
+		defer self.cleanupWaitGroup.Done()
+		self.cleanup()
+	}()
+
+	return nil
+}
+
+// Open (or reopen) the file. The current file is closed after the new file is open. Must be called while
+// holding the lock (or before the appender is returned).
+func (self *RotatingFileAppender) open() error {
+
+	file, err := os.OpenFile(self.config.FileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil { return NewStackError("Unable to open log file: %s - err: %v", self.config.FileName, err) }
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return NewStackError("Unable to stat log file: %s - err: %v", self.config.FileName, err)
+	}
+
+	if self.file != nil { self.file.Close() }
+
+	self.file = file
+	self.size = info.Size()
+
+	// A file that was written before it was opened is rotated at the end of the interval it was last written in.
+	if interval := self.config.RotateInterval; interval > 0 {
+		start := self.now()
+		if self.size > 0 { start = info.ModTime() }
+		self.nextRotation = start.Truncate(interval).Add(interval)
+	}
+
+	return nil
+}
+
+// Returns a rotated file name that is not used. If more than one file is rotated in a millisecond, a
+// counter is added (e.g., "app.log.20140601-120000.000-1").
+func (self *RotatingFileAppender) rotatedFileName() string {
+
+	base := fmt.Sprintf("%s.%s", self.config.FileName, self.now().Format(rotatedLogTimeFormat))
+
+	fileName := base
+
+	for i := 1; ; i++ {
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			if _, err := os.Stat(fileName + gzipExtension); os.IsNotExist(err) { return fileName }
+		}
+		fileName = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// Compress the rotated files and remove the files that are not kept. The errors are written to stderr,
+// because the appender cannot log them.
+func (self *RotatingFileAppender) cleanup() {
+
+	self.cleanupLock.Lock()
+	defer self.cleanupLock.Unlock()
+
+	self.removeTmpFiles()
+
+	if !self.config.Compress && self.config.MaxFiles == 0 && self.config.MaxAgeInDays == 0 { return }
+
+	rotated, err := self.rotatedFiles()
+	if err != nil { fmt.Fprintf(os.Stderr, "Unable to list rotated log files: %s - err: %v\n", self.config.FileName, err); return }
+
+	if self.config.Compress {
+		for i, fileName := range rotated {
+			if strings.HasSuffix(fileName, gzipExtension) { continue }
+
+			if err := GzipFile(fileName, fileName + gzipExtension); err != nil { fmt.Fprintf(os.Stderr, "Unable to compress rotated log file: %s - err: %v\n", fileName, err); continue }
+
+			os.Remove(fileName)
+			rotated[i] = fileName + gzipExtension
+		}
+	}
+
+	maxAge := self.now().Add(-time.Duration(self.config.MaxAgeInDays) * 24 * time.Hour)
+
+	for i, fileName := range rotated {
+
+		remove := self.config.MaxFiles > 0 && i < len(rotated) - self.config.MaxFiles
+
+		if !remove && self.config.MaxAgeInDays > 0 {
+			info, err := os.Stat(fileName)
+			remove = err == nil && info.ModTime().Before(maxAge)
+		}
+
+		if !remove { continue }
+
+		if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) { fmt.Fprintf(os.Stderr, "Unable to remove rotated log file: %s - err: %v\n", fileName, err) }
+	}
+}
+
+// Remove the temporary files left when the process exited while a rotated file was compressed (see
+// GzipFile). Must be called while holding the cleanup lock, so the files are not being written.
+func (self *RotatingFileAppender) removeTmpFiles() {
+
+	dir, base := filepath.Split(self.config.FileName)
+	if len(dir) == 0 { dir = "." }
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil { fmt.Fprintf(os.Stderr, "Unable to list rotated log files: %s - err: %v\n", self.config.FileName, err); return }
+
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasPrefix(name, base + ".") || !strings.HasSuffix(name, gzipExtension + tmpExtension) { continue }
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) { fmt.Fprintf(os.Stderr, "Unable to remove log file: %s - err: %v\n", name, err) }
+	}
+}
+
+// Returns the rotated files, oldest first.
+func (self *RotatingFileAppender) rotatedFiles() ([]string, error) {
+
+	dir, base := filepath.Split(self.config.FileName)
+	if len(dir) == 0 { dir = "." }
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil { return nil, err }
+
+	prefix := base + "."
+
+	var rotated []string
+
+	for _, info := range infos {
+		name := info.Name()
+
+		if info.IsDir() || !strings.HasPrefix(name, prefix) || strings.HasSuffix(name, tmpExtension) { continue }
+
+		stamp := strings.TrimPrefix(name, prefix)
+		if len(stamp) < len(rotatedLogTimeFormat) { continue }
+		if _, err := time.Parse(rotatedLogTimeFormat, stamp[:len(rotatedLogTimeFormat)]); err != nil { continue }
+
+		rotated = append(rotated, filepath.Join(dir, name))
+	}
+
+	// The compressed and uncompressed files are sorted by the rotated time.
+	sort.Slice(rotated, func(i, j int) bool { return strings.TrimSuffix(rotated[i], gzipExtension) < strings.TrimSuffix(rotated[j], gzipExtension) })
+
+	return rotated, nil
+}
+
+// The file appenders that the kernel closes when it is stopped. The standard appenders (e.g., stderr) are
+// not closed.
+type reopenableCloser interface {
+	Reopenable
+	io.Closer
+}
+
+// Close the file appenders in the appender and the appenders it wraps.
+func closeAppender(appender Appender) error {
+	switch a := appender.(type) {
+		case reopenableCloser: return a.Close()
+		case *LevelFilterAppender: return closeAppender(a.Appender)
+		case *FilterAppender: return closeAppender(a.Appender)
+		case *JsonAppender: if closer, ok := a.writer.(reopenableCloser); ok { return closer.Close() }
+	}
+	return nil
+}
+
+// Reopen the appender and the appenders it wraps.
+func reopenAppender(appender Appender) error {
+	switch a := appender.(type) {
+		case Reopenable: return a.Reopen()
+		case *LevelFilterAppender: return reopenAppender(a.Appender)
+		case *FilterAppender: return reopenAppender(a.Appender)
+		case *JsonAppender: if reopenable, ok := a.writer.(Reopenable); ok { return reopenable.Reopen() }
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+/**
+ * (C) Copyright 2014, Deft Labs
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dlshared
+
+import (
+	"os"
+	"fmt"
+	"sync"
+	"time"
+	"regexp"
+	"strings"
+	"testing"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// Returns the lines in the file and the rotated files (oldest first).
+func readTestLogFiles(t *testing.T, appender *RotatingFileAppender) ([]string, []string) {
+
+	rotated, err := appender.rotatedFiles()
+	if err != nil { t.Errorf("readTestLogFiles is broken: %v", err); return nil, nil }
+
+	var lines []string
+
+	for _, fileName := range append(rotated, appender.config.FileName) {
+		content, err := ioutil.ReadFile(fileName)
+		if err != nil { t.Errorf("readTestLogFiles is broken: %v", err); continue }
+
+		if strings.HasSuffix(fileName, gzipExtension) {
+			if content, err = GunzipBytes(content); err != nil { t.Errorf("readTestLogFiles is broken - file: %s - %v", fileName, err); continue }
+		}
+
+		if int64(len(content)) > int64(appender.config.MaxSize) && appender.config.MaxSize > 0 { t.Errorf("readTestLogFiles is broken - file: %s - size: %d", fileName, len(content)) }
+
+		for _, line := range strings.Split(string(content), "\n") { if len(line) > 0 { lines = append(lines, line) } }
+	}
+
+	return rotated, lines
+}
+
+func TestRotatingFileAppenderSize(t *testing.T) {
+
+	dir, err := ioutil.TempDir(nadaStr, "dlshared_test_log")
+	if err != nil { t.Errorf("TestRotatingFileAppenderSize is broken: %v", err); return }
+	defer os.RemoveAll(dir)
+
+	appender, err := NewRotatingFileAppender(&RotatingFileAppenderConfig{ FileName: filepath.Join(dir, "logs", "test.log"), MaxSize: 2 * KB, Compress: true })
+	if err != nil { t.Errorf("TestRotatingFileAppenderSize is broken: %v", err); return }
+
+	logger := &Logger{ Prefix: "rotate", Appenders: []Appender{ appender } }
+
+	var waitGroup sync.WaitGroup
+
+	for i := 0; i < 20; i++ {
+		waitGroup.Add(1)
+		go func(i int) {
+			defer waitGroup.Done()
+			for j := 0; j < 50; j++ {
+				if _, errs := logger.Infow("Rotating file appender test", "goroutine", i, "write", j); len(errs) != 0 { t.Errorf("TestRotatingFileAppenderSize is broken: %v", errs) }
+			}
+		}(i)
+	}
+
+	waitGroup.Wait()
+
+	if err := appender.Close(); err != nil { t.Errorf("TestRotatingFileAppenderSize is broken - close: %v", err) }
+
+	rotated, lines := readTestLogFiles(t, appender)
+
+	if len(rotated) < 10 { t.Errorf("TestRotatingFileAppenderSize is broken - rotated files: %v", rotated) }
+
+	for _, fileName := range rotated {
+		if !strings.HasSuffix(fileName, gzipExtension) { t.Errorf("TestRotatingFileAppenderSize is broken - not compressed: %s", fileName) }
+	}
+
+	// Every log is written once and the concurrent writes are not interleaved.
+	pattern := regexp.MustCompile(`Rotating file appender test goroutine=(\d+) write=(\d+)$`)
+	seen := make(map[string]bool)
+
+	for _, line := range lines {
+		match := pattern.FindStringSubmatch(line)
+		if match == nil || seen[match[0]] { t.Errorf("TestRotatingFileAppenderSize is broken - line: %s", line); continue }
+		seen[match[0]] = true
+	}
+
+	if len(seen) != 1000 { t.Errorf("TestRotatingFileAppenderSize is broken - logs: %d", len(seen)) }
+
+	// The file is opened again if the appender is used after it is closed.
+	if _, err := appender.Write([]byte("closed\n")); err != nil { t.Errorf("TestRotatingFileAppenderSize is broken - write after close: %v", err) }
+
+	appender.Close()
+}
+
+func TestRotatingFileAppenderTime(t *testing.T) {
+
+	dir, err := ioutil.TempDir(nadaStr, "dlshared_test_log")
+	if err != nil { t.Errorf("TestRotatingFileAppenderTime is broken: %v", err); return }
+	defer os.RemoveAll(dir)
+
+	appender, err := NewRotatingFileAppender(&RotatingFileAppenderConfig{ FileName: filepath.Join(dir, "test.log"), RotateInterval: time.Hour })
+	if err != nil { t.Errorf("TestRotatingFileAppenderTime is broken: %v", err); return }
+	defer appender.Close()
+
+	now := time.Date(2014, 6, 1, 12, 30, 0, 0, time.UTC)
+	appender.now = func() time.Time { return now }
+	appender.Reopen()
+
+	appender.Write([]byte("a\n"))
+
+	// The file is not rotated until the end of the interval.
+	now = now.Add(20 * time.Minute)
+	appender.Write([]byte("b\n"))
+
+	if rotated, _ := appender.rotatedFiles(); len(rotated) != 0 { t.Errorf("TestRotatingFileAppenderTime is broken - rotated: %v", rotated) }
+
+	now = now.Add(20 * time.Minute)
+	appender.Write([]byte("c\n"))
+
+	if rotated, _ := appender.rotatedFiles(); len(rotated) != 1 || !strings.HasSuffix(rotated[0], "test.log.20140601-131000.000") { t.Errorf("TestRotatingFileAppenderTime is broken - rotated: %v", rotated) }
+
+	now = now.Add(50 * time.Minute)
+	appender.Write([]byte("d\n"))
+
+	rotated, lines := readTestLogFiles(t, appender)
+
+	if len(rotated) != 2 || strings.Join(lines, ",") != "a,b,c,d" { t.Errorf("TestRotatingFileAppenderTime is broken - rotated: %v - lines: %v", rotated, lines); return }
+
+	// The rotated files are not compressed.
+	if content, _ := ioutil.ReadFile(rotated[1]); string(content) != "c\n" { t.Errorf("TestRotatingFileAppenderTime is broken - content: %q", content) }
+}
+
+func TestRotatingFileAppenderRetention(t *testing.T) {
+
+	dir, err := ioutil.TempDir(nadaStr, "dlshared_test_log")
+	if err != nil { t.Errorf("TestRotatingFileAppenderRetention is broken: %v", err); return }
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "test.log")
+	old := time.Now().Add(-10 * 24 * time.Hour)
+
+	// The old rotated files, a file left when the process exited while compressing and a file that is not a rotated file.
+	for i, name := range []string { "test.log.20140101-000000.000", "test.log.20140102-000000.000.gz", "test.log.20140103-000000.000-1", "test.log.20140104-000000.000.gz.tmp", "test.log.bak" } {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(fmt.Sprintf("%d\n", i)), 0640)
+		os.Chtimes(filepath.Join(dir, name), old, old)
+	}
+
+	appender, err := NewRotatingFileAppender(&RotatingFileAppenderConfig{ FileName: fileName, MaxAgeInDays: 7, MaxFiles: 2 })
+	if err != nil { t.Errorf("TestRotatingFileAppenderRetention is broken: %v", err); return }
+
+	// The expired files are removed when the appender is created.
+	if rotated, _ := appender.rotatedFiles(); len(rotated) != 0 { t.Errorf("TestRotatingFileAppenderRetention is broken - expired: %v", rotated) }
+	if _, err := os.Stat(filepath.Join(dir, "test.log.bak")); err != nil { t.Errorf("TestRotatingFileAppenderRetention is broken - removed a file that is not rotated: %v", err) }
+	if _, err := os.Stat(filepath.Join(dir, "test.log.20140104-000000.000.gz.tmp")); !os.IsNotExist(err) { t.Errorf("TestRotatingFileAppenderRetention is broken - tmp file not removed: %v", err) }
+
+	for i := 0; i < 4; i++ {
+		appender.Write([]byte(fmt.Sprintf("%d\n", i)))
+		if err := appender.Rotate(); err != nil { t.Errorf("TestRotatingFileAppenderRetention is broken - rotate: %v", err) }
+	}
+
+	// An empty file is not rotated.
+	appender.Rotate()
+
+	appender.Close()
+
+	if rotated, lines := readTestLogFiles(t, appender); len(rotated) != 2 || strings.Join(lines, ",") != "2,3" { t.Errorf("TestRotatingFileAppenderRetention is broken - rotated: %v - lines: %v", rotated, lines) }
+
+	if _, err := NewRotatingFileAppender(&RotatingFileAppenderConfig{ FileName: fileName, MaxFiles: -1 }); err == nil { t.Errorf("TestRotatingFileAppenderRetention is broken - expected a negative limit error") }
+}
+
+func TestRotatingFileAppenderReopen(t *testing.T) {
+
+	dir, err := ioutil.TempDir(nadaStr, "dlshared_test_log")
+	if err != nil { t.Errorf("TestRotatingFileAppenderReopen is broken: %v", err); return }
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "test.log")
+
+	configuration, err := NewConfigurationFromMap(map[string]interface{} {
+		"version": "1.0.0",
+		"environment": "test",
+		"logger": map[string]interface{} { "level": "info", "format": "json", "file": map[string]interface{} { "fileName": fileName, "maxSize": "1MB" } },
+	})
+
+	if err != nil { t.Errorf("TestRotatingFileAppenderReopen is broken: %v", err); return }
+
+	appenders, err := configureLogger("rotatingFileAppenderReopen", configuration)
+	if err != nil || len(appenders) != 2 { t.Errorf("TestRotatingFileAppenderReopen is broken - appenders: %v - %v", appenders, err); return }
+
+	// Only log to the file.
+	kernel := newKernelWithConfiguration("rotatingFileAppenderReopen", configuration, Logger{ Prefix: "rotatingFileAppenderReopen", Appenders: appenders[1:] })
+
+	kernel.Infow("Before the file is moved")
+
+	// Move the file (e.g., logrotate) and reload the kernel (e.g., SIGHUP).
+	if err := os.Rename(fileName, fileName + ".1"); err != nil { t.Errorf("TestRotatingFileAppenderReopen is broken: %v", err); return }
+
+	kernel.Infow("After the file is moved")
+
+	if err := kernel.Reload(); err != nil { t.Errorf("TestRotatingFileAppenderReopen is broken - reload: %v", err) }
+
+	kernel.Infow("After the reload")
+
+	moved, _ := ioutil.ReadFile(fileName + ".1")
+	current, _ := ioutil.ReadFile(fileName)
+
+	if !strings.Contains(string(moved), `"message":"After the file is moved"`) || strings.Contains(string(moved), "After the reload") { t.Errorf("TestRotatingFileAppenderReopen is broken - moved file: %s", moved) }
+	if strings.Count(string(current), "\n") != 1 || !strings.Contains(string(current), `"message":"After the reload"`) { t.Errorf("TestRotatingFileAppenderReopen is broken - file: %s", current) }
+
+	// The file is closed when the kernel is stopped.
+	if err := kernel.Stop(); err != nil { t.Errorf("TestRotatingFileAppenderReopen is broken - stop: %v", err) }
+
+	appender := appenders[1].(*LevelFilterAppender).Appender.(*JsonAppender).writer.(*RotatingFileAppender)
+
+	if appender.file != nil { t.Errorf("TestRotatingFileAppenderReopen is broken - the file is not closed") }
+
+	current, _ = ioutil.ReadFile(fileName)
+	if !strings.Contains(string(current), `"message":"Stopped: rotatingFileAppenderReopen`) { t.Errorf("TestRotatingFileAppenderReopen is broken - file: %s", current) }
+
+	// The standard appenders are not closed.
+	if err := closeAppender(StdErrAppender()); err != nil || os.Stderr.Fd() == ^uintptr(0) { t.Errorf("TestRotatingFileAppenderReopen is broken - closed stderr: %v", err) }
+}
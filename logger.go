@@ -30,10 +30,22 @@ type Log struct {
 	Filename   string
 	Line       int
 	Timestamp  time.Time
+	Fields     []LogField
 	messageFmt string
 	args       []interface{}
 }
 
+// A key/value pair that is added to a log by Logger.With or the structured
+// methods (e.g., Infow). The appenders render the fields after the message
+// (see FormatLog) or as json properties (see JsonAppender).
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+// The key used when a value in a key/value list is not preceded by a string key.
+const badLogFieldKey = "!BADKEY"
+
 func (self *Log) Message() string {
 	return fmt.Sprintf(self.messageFmt, self.args...)
 }
@@ -41,6 +53,46 @@ func (self *Log) Message() string {
 type Logger struct {
 	Prefix    string
 	Appenders []Appender
+	Fields    []LogField
+}
+
+// Returns a copy of the logger that adds the fields to every log. The
+// arguments are alternating keys and values.
+// Example:
+//
+// self.Logger = kernel.Logger.With("component", "CronSvc", "configPath", configPath)
+//
+func (self *Logger) With(keyValues ...interface{}) Logger {
+	fields := make([]LogField, 0, len(self.Fields)+len(keyValues)/2)
+	fields = append(fields, self.Fields...)
+	fields = append(fields, logFields(keyValues)...)
+
+	return Logger{Prefix: self.Prefix, Appenders: self.Appenders, Fields: fields}
+}
+
+// Log a message with fields. The arguments are alternating keys and values.
+// Example:
+//
+// self.Logw(Info, "Cron job finished", "jobId", jobId, "elapsed", elapsed)
+//
+func (self *Logger) Logw(level Level, message string, keyValues ...interface{}) (*Log, []error) {
+	return self.logw(level, message, keyValues)
+}
+
+func (self *Logger) Debugw(message string, keyValues ...interface{}) (*Log, []error) {
+	return self.logw(Debug, message, keyValues)
+}
+
+func (self *Logger) Infow(message string, keyValues ...interface{}) (*Log, []error) {
+	return self.logw(Info, message, keyValues)
+}
+
+func (self *Logger) Warnw(message string, keyValues ...interface{}) (*Log, []error) {
+	return self.logw(Warn, message, keyValues)
+}
+
+func (self *Logger) Errorw(message string, keyValues ...interface{}) (*Log, []error) {
+	return self.logw(Error, message, keyValues)
 }
 
 // Log a message and a level to a logger instance. This returns a
@@ -72,10 +124,27 @@ func (self *Logger) Stackf(level Level, stackErr error, messageFmt string, args
 	return self.logf(level, messageFmt, args...)
 }
 
+// The public methods must call logf or logw directly - the caller of the
+// public method is two frames up.
 func (self *Logger) logf(level Level, messageFmt string, args ...interface{}) (*Log, []error) {
+	return self.log(level, self.Fields, messageFmt, args)
+}
+
+func (self *Logger) logw(level Level, message string, keyValues []interface{}) (*Log, []error) {
+	fields := self.Fields
+	if len(keyValues) > 0 {
+		fields = make([]LogField, 0, len(self.Fields)+len(keyValues)/2)
+		fields = append(fields, self.Fields...)
+		fields = append(fields, logFields(keyValues)...)
+	}
+
+	return self.log(level, fields, "%s", []interface{}{message})
+}
+
+func (self *Logger) log(level Level, fields []LogField, messageFmt string, args []interface{}) (*Log, []error) {
 	var errors []error
 
-	_, file, line, ok := runtime.Caller(2)
+	_, file, line, ok := runtime.Caller(3)
 	if ok == false {
 		return nil, []error{fmt.Errorf("Failed to find the calling method.")}
 	}
@@ -88,6 +157,7 @@ func (self *Logger) logf(level Level, messageFmt string, args ...interface{}) (*
 		Filename:   file,
 		Line:       line,
 		Timestamp:  time.Now(),
+		Fields:     fields,
 		messageFmt: messageFmt,
 		args:       args,
 	}
@@ -104,6 +174,32 @@ func (self *Logger) logf(level Level, messageFmt string, args ...interface{}) (*
 	return log, errors
 }
 
+// Convert alternating keys and values to fields. A value without a string
+// key (including a trailing key without a value) is added with the key
+// "!BADKEY", so nothing that was passed is lost. LogField values are added
+// as they are.
+func logFields(keyValues []interface{}) []LogField {
+	fields := make([]LogField, 0, len(keyValues)/2)
+
+	for i := 0; i < len(keyValues); i++ {
+		if field, ok := keyValues[i].(LogField); ok {
+			fields = append(fields, field)
+			continue
+		}
+
+		key, ok := keyValues[i].(string)
+		if !ok || i == len(keyValues)-1 {
+			fields = append(fields, LogField{Key: badLogFieldKey, Value: keyValues[i]})
+			continue
+		}
+
+		fields = append(fields, LogField{Key: key, Value: keyValues[i+1]})
+		i++
+	}
+
+	return fields
+}
+
 type Level uint8
 
 // The level is in an order such that the expressions
@@ -120,6 +216,19 @@ const (
 	Error
 )
 
+// Returns the level for the name returned by Type (e.g., "debug") or "off".
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+		case "error": return Error, nil
+		case "warn": return Warn, nil
+		case "info": return Info, nil
+		case "debug": return Debug, nil
+		case "off": return Off, nil
+	}
+
+	return Off, NewStackError("Invalid log level: %s - expected debug, info, warn, error or off", name)
+}
+
 func (self Level) Type() string {
 	switch self {
 		case Error: return "error"
@@ -18,12 +18,16 @@ package dlshared
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestLevels(test *testing.T) {
@@ -49,6 +53,119 @@ func TestFormat(test *testing.T) {
 	}
 }
 
+func TestFormatFields(test *testing.T) {
+	log := Log{
+		Prefix:     "agent.OplogTail",
+		Level:      Info,
+		Filename:   "oplog.go",
+		Line:       88,
+		Fields:     []LogField{{"rsId", "backup_test"}, {"user", "Jane Doe"}, {"query", "a=b"}, {"empty", ""}, {"err", errors.New("failed")}},
+		messageFmt: "Tail started",
+	}
+
+	expected := "[0001/01/01 00:00:00] [agent.OplogTail.info] [oplog.go:88] Tail started rsId=backup_test user=\"Jane Doe\" query=\"a=b\" empty=\"\" err=failed\n"
+	received := FormatLog(&log)
+	if received != expected {
+		test.Errorf("Improperly formatted log. Received: `%v`", received)
+	}
+
+	if received := formatSyslogLog(&log); !strings.HasSuffix(received, "Tail started rsId=backup_test user=\"Jane Doe\" query=\"a=b\" empty=\"\" err=failed\n") {
+		test.Errorf("Improperly formatted syslog log. Received: `%v`", received)
+	}
+}
+
+func TestStructuredLog(test *testing.T) {
+	logBuffer := new(bytes.Buffer)
+	logger := &Logger{
+		Prefix:    "agent.OplogTail",
+		Appenders: []Appender{NewStringAppender(logBuffer)},
+	}
+
+	componentLogger := logger.With("component", "oplog", "rsId", "backup_test")
+
+	log, errs := componentLogger.Infow("Tail started", "ts", 42, "rsId", "override", "trailing")
+	if len(errs) != 0 {
+		test.Errorf("Unexpected append errors: %v", errs)
+	}
+
+	expectedFields := []LogField{{"component", "oplog"}, {"rsId", "backup_test"}, {"ts", 42}, {"rsId", "override"}, {badLogFieldKey, "trailing"}}
+	if fmt.Sprint(log.Fields) != fmt.Sprint(expectedFields) {
+		test.Errorf("Unexpected fields. Received: %v", log.Fields)
+	}
+
+	// The file is the caller, not the logger.
+	if log.Filename != "dlshared/logger_test.go" && !strings.HasSuffix(log.Filename, "/logger_test.go") {
+		test.Errorf("Incorrect filename. Received: %v", log.Filename)
+	}
+
+	// The parent logger does not have the fields.
+	if len(logger.Fields) != 0 {
+		test.Errorf("With changed the parent logger. Fields: %v", logger.Fields)
+	}
+
+	// The fields are added to the printf-style logs.
+	log, _ = componentLogger.Logf(Warn, "Lag: %d", 5)
+	if log.Message() != "Lag: 5" || len(log.Fields) != 2 || !strings.HasSuffix(log.Filename, "logger_test.go") {
+		test.Errorf("Unexpected log: %+v", log)
+	}
+
+	// A message with a percent sign is not formatted.
+	log, _ = logger.Errorw("100% failed", LogField{"code", 7})
+	if log.Message() != "100% failed" || log.Level != Error || len(log.Fields) != 1 {
+		test.Errorf("Unexpected log: %+v", log)
+	}
+
+	if output := logBuffer.String(); !strings.Contains(output, "Tail started component=oplog rsId=backup_test ts=42 rsId=override !BADKEY=trailing") {
+		test.Errorf("Unexpected output: %v", output)
+	}
+}
+
+func TestJsonAppender(test *testing.T) {
+	logBuffer := new(bytes.Buffer)
+	logger := &Logger{
+		Prefix:    "agent.OplogTail",
+		Appenders: []Appender{NewJsonAppender(logBuffer)},
+	}
+
+	logger.Debugw("Tail <started>", "ts", 42, "level", "custom", "err", errors.New("failed"), "elapsed", 1500*time.Millisecond, "ratio", math.NaN(), "ch", make(chan int))
+	logger.Logf(Info, "Lag: %d", 5)
+
+	lines := strings.Split(strings.TrimSuffix(logBuffer.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		test.Fatalf("Expected one json object per line. Received: %v", logBuffer.String())
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &doc); err != nil {
+		test.Fatalf("Invalid json: %v - %v", lines[0], err)
+	}
+
+	expected := map[string]interface{}{
+		"prefix":       "agent.OplogTail",
+		"level":        "debug",
+		"message":      "Tail <started>",
+		"ts":           42.0,
+		"fields.level": "custom",
+		"err":          "failed",
+		"elapsed":      "1.5s",
+		"ratio":        "NaN",
+	}
+
+	for key, value := range expected {
+		if doc[key] != value {
+			test.Errorf("Unexpected json property: %s - expected: %v - received: %v", key, value, doc[key])
+		}
+	}
+
+	if _, err := time.Parse(time.RFC3339Nano, fmt.Sprint(doc["time"])); err != nil || !strings.HasSuffix(fmt.Sprint(doc["file"]), "logger_test.go") || doc["line"] == nil || doc["ch"] == nil {
+		test.Errorf("Unexpected json log: %v", lines[0])
+	}
+
+	if err := json.Unmarshal([]byte(lines[1]), &doc); err != nil || doc["message"] != "Lag: 5" || doc["level"] != "info" {
+		test.Errorf("Unexpected json log: %v - %v", lines[1], err)
+	}
+}
+
 func TestLog(test *testing.T) {
 	const logFilename = "logger_test.output"
 	logfile, err := os.Create(logFilename)
@@ -157,6 +274,45 @@ func TestFilter(test *testing.T) {
 	}
 }
 
+func TestLevelFilterAppender(test *testing.T) {
+	counter := &countingAppender{}
+	filter := NewLevelFilterAppender(Warn, counter)
+	logger := &Logger{
+		Prefix:    "agent.OplogTail",
+		Appenders: []Appender{filter},
+	}
+
+	logger.Logf(Info, "%d", 0)
+	logger.Logf(Warn, "%d", 1)
+
+	level, err := ParseLevel(" Debug ")
+	if err != nil || level != Debug {
+		test.Errorf("ParseLevel is broken - level: %v - error: %v", level, err)
+	}
+
+	if _, err := ParseLevel("verbose"); err == nil {
+		test.Errorf("ParseLevel is broken - invalid level accepted")
+	}
+
+	filter.SetLevel(level)
+	logger.Logf(Debug, "%d", 2)
+
+	if counter.count != 2 {
+		test.Errorf("Expected two logs to pass through the filter to the appender. Received: %d",
+			counter.count)
+	}
+
+	// Off drops every log.
+	level, _ = ParseLevel("off")
+	filter.SetLevel(level)
+	logger.Logf(Debug, "%d", 3)
+	logger.Logf(Error, "%d", 4)
+
+	if counter.count != 2 {
+		test.Errorf("Expected the off level to drop every log. Received: %d", counter.count)
+	}
+}
+
 func TestStacktrace(test *testing.T) {
 	// slogger/logger_test.go:129
 	// testing/testing.go:346
@@ -16,7 +16,11 @@
 
 package dlshared
 
-import "time"
+import (
+	"fmt"
+	"time"
+	"context"
+)
 
 type MetricsRelayFunction func(string, []Metric)
 
@@ -38,11 +42,10 @@ type Metric struct {
 
 type Metrics struct {
 	sourceName string
-	quitChannel chan bool
 	relayFuncs []MetricsRelayFunction
 	relayPeriodInSecs int
 	metricChannel chan *Metric
-	ticker *time.Ticker
+	worker *Worker
 }
 
 // The relay function is only called if there are metrics to relay.
@@ -55,7 +58,6 @@ func NewMetrics(	sourceName string,
 		sourceName: sourceName,
 		relayFuncs: relayFuncs,
 		relayPeriodInSecs: relayPeriodInSecs,
-		quitChannel: make(chan bool),
 		metricChannel: make(chan *Metric, metricQueueLength),
 	}
 }
@@ -87,7 +89,11 @@ func (self *Metrics) CountWithValue(metricName string, value float64) {
 	}
 }
 
-func (self *Metrics) listenForEvents() {
+// This runs as a kernel worker. If it panics, it is restarted and the metrics that were not relayed are lost.
+func (self *Metrics) listenForEvents(ctx context.Context) error {
+
+	ticker := time.NewTicker(time.Duration(self.relayPeriodInSecs) * time.Second)
+	defer ticker.Stop()
 
 	metrics := make(map[string]*Metric)
 
@@ -100,7 +106,7 @@ func (self *Metrics) listenForEvents() {
 				if metric.Type == Counter { current.Value = current.Value + metric.Value
 				} else {  current.Value = metric.Value }
 
-			case <- self.ticker.C:
+			case <- ticker.C:
 
 				var toRelay []Metric
 
@@ -110,25 +116,21 @@ func (self *Metrics) listenForEvents() {
 
 				for _, relayFunc := range self.relayFuncs { go relayFunc(self.sourceName, toRelay) }
 
-			case <- self.quitChannel:
-				self.ticker.Stop()
-				return
+			case <- ctx.Done(): return nil
         }
     }
 }
 
+// The metrics are collected by a kernel worker (see Kernel.StartWorker).
+func (self *Metrics) Start(kernel *Kernel) error {
 
-func (self *Metrics) Start() error {
-
-	self.ticker = time.NewTicker(time.Duration(self.relayPeriodInSecs) * time.Second)
-
-	go self.listenForEvents()
+	self.worker = kernel.StartWorker(fmt.Sprintf("Metrics.listenForEvents - source: %s", self.sourceName), self.listenForEvents)
 
 	return nil
 }
 
 func (self *Metrics) Stop() error {
-	self.quitChannel <- true
+	if self.worker != nil { self.worker.Stop() }
 	return nil
 }
 
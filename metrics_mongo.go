@@ -151,6 +151,8 @@ func (self *MetricsMongo) StoreMetricsInMongo(sourceName string, metrics []Metri
 	}
 }
 
+func (self *MetricsMongo) DependsOn(kernel *Kernel) []string { return []string{ self.mongoComponentName } }
+
 func (self *MetricsMongo) Start(kernel *Kernel) error {
 
 	self.Logger = kernel.Logger
@@ -29,9 +29,12 @@ func TestMetrics(t *testing.T) {
 		relayFuncCallCount++
 	}
 
+	kernel, err := NewTestKernel("metrics", nil)
+	if err != nil { t.Errorf("TestMetrics is broken: %v", err); return }
+
 	metrics := NewMetrics("TestSource", []MetricsRelayFunction { relayFunc }, 1, 100)
 
-	if err := metrics.Start(); err != nil {
+	if err := metrics.Start(kernel.Kernel); err != nil {
 		t.Errorf("TestMetrics Start is broken: %v", err)
 	}
 
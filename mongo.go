@@ -19,6 +19,7 @@ package dlshared
 import (
 	"fmt"
 	"time"
+	"context"
 	"labix.org/v2/mgo"
 )
 
@@ -46,11 +47,11 @@ const (
 //
 //
 // The configPath for this component would be "mongodb.configDb". The path can be any arbitrary set of nested
-// json documents (json path). If the path is incorrect, the Start() method will panic when called by the kernel.
+// json documents (json path). The kernel validates the configuration (see ConfigurationSchema) before any
+// component is started and returns all of the problems in one error.
 //
-// All of the params above must be present or
-// the Start method will panic. If the componentId or configPath param is nil or empty,
-// this method will panic.
+// All of the params above must be present. The mode must be "strong", "eventual" or "monotonic". If the
+// componentId or configPath param is nil or empty, this method will panic.
 func NewMongoFromConfigPath(componentId, configPath string) *Mongo {
 
 	if len(componentId) == 0 {
@@ -133,6 +134,19 @@ func (self *Mongo) SessionClone() *mgo.Session { return self.session.Clone() }
 // Returns a copy of the session struct.
 func (self *Mongo) SessionCopy() *mgo.Session { return self.session.Clone() }
 
+// Ping the server with a copy of the session. This implements the HealthChecker interface.
+func (self *Mongo) CheckHealth(ctx context.Context) (string, error) {
+
+	if self.session == nil { return nadaStr, NewStackError("Mongo not started - componentId: %s", self.componentId) }
+
+	session := self.session.Copy()
+	defer session.Close()
+
+	if err := session.Ping(); err != nil { return nadaStr, NewStackError("Unable to ping mongo - componentId: %s - err: %v", self.componentId, err) }
+
+	return fmt.Sprintf("type: %s - mode: %s", self.connectionType, self.mode), nil
+}
+
 // The mongo cursor is a wrapper around an mgo.Iter and session object.
 // This alows you to close both the iter and the session in the same call.
 // The cursor supports all of the methods in the iter struct.
@@ -189,22 +203,8 @@ func (self *Mongo) Start(kernel *Kernel) error {
 		self.cursorTimeoutInMs = kernel.Configuration.IntWithPath(self.configPath, "cursorTimeoutInMs", -1)
 	}
 
-	// Validate the params
-	if len(self.mongoUrl) == 0 { panic(fmt.Sprintf("In Mongo - mongoUrl is not set - componentId: %s", self.componentId)) }
-
-	if len(self.connectionType) == 0 { panic(fmt.Sprintf("In Mongo - type is not set - componentId: %s", self.componentId)) }
-
-	if self.dialTimeoutInMs < 0 { panic(fmt.Sprintf("In Mongo - dialTimeoutInMs is invalid - value: %d - componentId: %s", self.dialTimeoutInMs, self.componentId)) }
-
-	if self.socketTimeoutInMs < 0 { panic(fmt.Sprintf("In Mongo - socketTimeoutInMs is invalid - value: %d - componentId: %s", self.socketTimeoutInMs, self.componentId)) }
-
-	if self.syncTimeoutInMs < 0 { panic(fmt.Sprintf("In Mongo - syncTimeoutInMs is invalid - value: %d - componentId: %s", self.syncTimeoutInMs, self.componentId)) }
-
-	if self.cursorTimeoutInMs < 0 { panic(fmt.Sprintf("In Mongo - cursorTimeoutInMs is invalid - value: %d - componentId: %s", self.cursorTimeoutInMs, self.componentId)) }
-
-	if len(self.mode) == 0 { panic(fmt.Sprintf("In Mongo - mode is invalid - value: %s - componentId: %s", self.mode, self.componentId)) }
-
-	if self.mode != "strong" && self.mode != "eventual" && self.mode != "montonic" { panic(fmt.Sprintf("In Mongo - mode is invalid - value: %s - componentId: %s", self.mode, self.componentId)) }
+	// Validate the params. All of the problems are returned in one error.
+	if err := self.validate(); err != nil { return err }
 
 	// Create the session.
 	if self.session, err = mgo.DialWithTimeout(self.mongoUrl, time.Duration(self.dialTimeoutInMs) * time.Millisecond); err != nil {
@@ -227,6 +227,62 @@ func (self *Mongo) Start(kernel *Kernel) error {
 	return nil
 }
 
+// The params are set by the constructor or loaded from the configuration path.
+func (self *Mongo) validate() error {
+
+	var errs []error
+
+	if len(self.mongoUrl) == 0 { errs = append(errs, NewStackError("In Mongo - mongoUrl is not set - componentId: %s", self.componentId)) }
+
+	switch self.connectionType {
+		case MongosConnectionType, StandaloneConnectionType, ReplicaSetConnectionType:
+		case "": errs = append(errs, NewStackError("In Mongo - type is not set - componentId: %s", self.componentId))
+		default: errs = append(errs, NewStackError("In Mongo - type is invalid - value: %s - componentId: %s", self.connectionType, self.componentId))
+	}
+
+	if self.dialTimeoutInMs < 0 { errs = append(errs, NewStackError("In Mongo - dialTimeoutInMs is invalid - value: %d - componentId: %s", self.dialTimeoutInMs, self.componentId)) }
+
+	if self.socketTimeoutInMs < 0 { errs = append(errs, NewStackError("In Mongo - socketTimeoutInMs is invalid - value: %d - componentId: %s", self.socketTimeoutInMs, self.componentId)) }
+
+	if self.syncTimeoutInMs < 0 { errs = append(errs, NewStackError("In Mongo - syncTimeoutInMs is invalid - value: %d - componentId: %s", self.syncTimeoutInMs, self.componentId)) }
+
+	if self.cursorTimeoutInMs < 0 { errs = append(errs, NewStackError("In Mongo - cursorTimeoutInMs is invalid - value: %d - componentId: %s", self.cursorTimeoutInMs, self.componentId)) }
+
+	if self.mode != "strong" && self.mode != "eventual" && self.mode != "monotonic" { errs = append(errs, NewStackError("In Mongo - mode is invalid - value: %s - componentId: %s", self.mode, self.componentId)) }
+
+	return NewAggregateError(fmt.Sprintf("Invalid Mongo params - componentId: %s", self.componentId), errs)
+}
+
+// The schema for the configuration path. This implements the ConfigurationSchemaProvider interface. If the
+// component was not created from a configuration path, nil is returned.
+func (self *Mongo) ConfigurationSchema() *ConfigurationSchema {
+
+	if len(self.configPath) == 0 { return nil }
+
+	schema := NewConfigurationSchema(self.configPath)
+	schema.Key("mongoUrl", StringConfigurationType).Require()
+	schema.Key("type", StringConfigurationType).Require().OneOf(string(MongosConnectionType), string(StandaloneConnectionType), string(ReplicaSetConnectionType))
+	schema.Key("mode", StringConfigurationType).Require().OneOf("strong", "eventual", "monotonic")
+	schema.Key("dialTimeoutInMs", IntConfigurationType).Require().AtLeast(0)
+	schema.Key("socketTimeoutInMs", IntConfigurationType).Require().AtLeast(0)
+	schema.Key("syncTimeoutInMs", IntConfigurationType).Require().AtLeast(0)
+	schema.Key("cursorTimeoutInMs", IntConfigurationType).Require().AtLeast(0)
+
+	return schema
+}
+
+// Returns the Mongo component with the id. An error is returned if the component is not registered or if
+// it is not a *Mongo.
+func mongoComponent(kernel *Kernel, componentId string) (*Mongo, error) {
+
+	if len(componentId) == 0 || !kernel.HasComponent(componentId) { return nil, NewStackError("Invalid mongo component id: %s", componentId) }
+
+	mongo, ok := kernel.GetComponent(componentId).(*Mongo)
+	if !ok { return nil, NewStackError("Mongo component: %s - is not a *Mongo", componentId) }
+
+	return mongo, nil
+}
+
 // Stop the component. This will close the base session.
 func (self *Mongo) Stop(kernel *Kernel) error {
 
@@ -18,9 +18,12 @@ package dlshared
 
 import(
 	"io"
+	"fmt"
 	"net"
 	"time"
+	"context"
 	"strings"
+	"sync/atomic"
 	"crypto/tls"
 )
 
@@ -31,7 +34,7 @@ const(
 
 // The TCP socket processor is a wrapper around a TCP socket that reconnects
 // if there is a failure. It also spawns a reading and a writing goroutine to
-// handle data in/out. After the struct is created, you must call Start. When
+// handle data in/out (as kernel workers). After the struct is created, you must call Start. When
 // you are done using, call the Stop method. Make sure you check the error returned
 // when calling Start. If this is a tls connection, the component will not run
 // if either either the certificate or key file is not accessible. The component
@@ -39,7 +42,8 @@ const(
 type TcpSocketProcessor struct {
 	Logger
 
-	shutdownChannel chan bool
+	kernel *Kernel
+	worker *Worker
 
 	address string
 	certificateFile string
@@ -55,6 +59,8 @@ type TcpSocketProcessor struct {
 	writeChannel chan TcpSocketProcessorWrite
 
 	tlsConf *tls.Config
+
+	connected int32 // Set to one while the socket is connected.
 }
 
 type TcpSocketProcessorRead struct {
@@ -90,7 +96,6 @@ func NewTcpSocketProcessor(	address string,
 		readBufferSize: readBufferSize,
 		readChannel: readChannel,
 		writeChannel: writeChannel,
-		shutdownChannel: make(chan bool),
 	}
 }
 
@@ -121,8 +126,8 @@ func NewTlsTcpSocketProcessor(	address string,
 }
 
 // This method should block and then return when the socket is closed or if the remote server
-// directs it to do so.
-func (self *TcpSocketProcessor) networkReader(connection net.Conn, readerLostConnectionChannel chan bool) {
+// directs it to do so. This runs as a kernel worker that is stopped when the connection is closed.
+func (self *TcpSocketProcessor) networkReader(ctx context.Context, connection net.Conn, readerLostConnectionChannel chan bool) error {
 
 	read := &TcpSocketProcessorRead { Data: make([]byte, self.readBufferSize, self.readBufferSize) }
 
@@ -131,17 +136,25 @@ func (self *TcpSocketProcessor) networkReader(connection net.Conn, readerLostCon
 
 		read.BytesRead, read.Error = connection.Read(read.Data)
 
-		// The socket was closed.
-		if read.BytesRead == 0 || read.Error == io.EOF { readerLostConnectionChannel <- true; return }
+		// The socket was closed. The channel is buffered, so this does not block if the writer is gone.
+		if read.BytesRead == 0 || read.Error == io.EOF {
+			select {
+				case readerLostConnectionChannel <- true:
+				default:
+			}
+			return nil
+		}
 
-		self.readChannel <- *read
+		select {
+			case self.readChannel <- *read:
+			case <- ctx.Done(): return nil
+		}
 	}
 }
 
-// This returns true if the connection was lost through normal reasons. If false is returned it received
-// a shutdown message on the writer shutdown channel. This method blocks until it is shutdown or it
-// loses the connection.
-func (self *TcpSocketProcessor) networkWriter(connection net.Conn, readerLostConnectionChannel, writerShutdownChannel chan bool) bool {
+// This returns true if the connection was lost through normal reasons. If false is returned the
+// processor was stopped. This method blocks until it is stopped or it loses the connection.
+func (self *TcpSocketProcessor) networkWriter(ctx context.Context, connection net.Conn, readerLostConnectionChannel chan bool) bool {
 	for {
 		select {
 			case write := <- self.writeChannel:
@@ -157,51 +170,49 @@ func (self *TcpSocketProcessor) networkWriter(connection net.Conn, readerLostCon
 
 			case <- readerLostConnectionChannel: return true
 
-			case <- writerShutdownChannel: return false
+			case <- ctx.Done(): return false
 		}
 	}
 
 	return true
 }
 
-func (self *TcpSocketProcessor) connectAndProcess(connectionLostChannel, writerShutdownChannel chan bool) {
-
-	readerLostConnectionChannel := make(chan bool)
+// Open the connection and process it until it is lost. This returns false if the processor was stopped.
+// The reader is stopped (and joined) before this returns, so nothing is sent on the read channel after
+// the processor is stopped.
+func (self *TcpSocketProcessor) connectAndProcess(ctx context.Context) bool {
 
 	connection, err := self.connect()
 
 	if err != nil {
 		self.Logf(Error, "Unable to connect to socket - err: %v", err)
-		time.Sleep(2 * time.Second)
-		connectionLostChannel <- true
-		return
+		select {
+			case <- time.After(2 * time.Second): return true
+			case <- ctx.Done(): return false
+		}
 	}
 
-	defer connection.Close()
+	atomic.StoreInt32(&self.connected, 1)
+	defer atomic.StoreInt32(&self.connected, 0)
 
-	go self.networkReader(connection, readerLostConnectionChannel)
+	readerLostConnectionChannel := make(chan bool, 1)
 
-	// If we lost the channel because of an error, we are going to trigger
-	// another connection to be opened.
-	if self.networkWriter(connection, readerLostConnectionChannel, writerShutdownChannel) { connectionLostChannel <- true }
-}
+	reader := self.kernel.StartWorker(fmt.Sprintf("TcpSocketProcessor.networkReader - address: %s", self.address), func(ctx context.Context) error {
+		return self.networkReader(ctx, connection, readerLostConnectionChannel)
+	})
 
-// Manages the socket and the send/receive goroutines. If a socket connection
-// is lost, a new one is opened.
-func (self *TcpSocketProcessor) process() {
+	// Closing the connection unblocks the reader.
+	defer func() { connection.Close(); reader.Stop() }()
 
-	writerShutdownChannel := make(chan bool)
-	connectionLostChannel := make(chan bool)
-
-	// Trigger the connection to opened.
-	go func() { connectionLostChannel <- true }()
+	// If we lost the channel because of an error, we are going to open another connection.
+	return self.networkWriter(ctx, connection, readerLostConnectionChannel)
+}
 
-	for {
-		select {
-			case <- connectionLostChannel: go self.connectAndProcess(connectionLostChannel, writerShutdownChannel)
-			case <- self.shutdownChannel: writerShutdownChannel <- true; return
-		}
-	}
+// Manages the socket and the send/receive goroutines. If a socket connection is lost, a new one is
+// opened. This runs as a kernel worker (see Start).
+func (self *TcpSocketProcessor) process(ctx context.Context) error {
+	for self.connectAndProcess(ctx) { }
+	return nil
 }
 
 // Open a connection. You must close this connection when you are done ;)
@@ -218,7 +229,18 @@ func (self *TcpSocketProcessor) connect() (net.Conn, error) {
 	return tlsConnection, nil
 }
 
-func (self *TcpSocketProcessor) Start() error {
+// Returns true if the socket is currently connected.
+func (self *TcpSocketProcessor) Connected() bool { return atomic.LoadInt32(&self.connected) == 1 }
+
+// The processor is healthy if the socket is connected. This implements the HealthChecker interface.
+func (self *TcpSocketProcessor) CheckHealth(ctx context.Context) (string, error) {
+	detail := fmt.Sprintf("address: %s - connected: %t", self.address, self.Connected())
+	if !self.Connected() { return detail, NewStackError("Socket not connected - address: %s", self.address) }
+	return detail, nil
+}
+
+// The socket is processed by kernel workers (see Kernel.StartWorker), so a panic reconnects.
+func (self *TcpSocketProcessor) Start(kernel *Kernel) error {
 
 	if self.readBufferSize <= 0 { return NewStackError("The read buffer size must be at least one - received : %d", self.readBufferSize) }
 
@@ -240,14 +262,17 @@ func (self *TcpSocketProcessor) Start() error {
 		}
 	}
 
-	go self.process()
+	self.kernel = kernel
+
+	self.worker = kernel.StartWorker(fmt.Sprintf("TcpSocketProcessor.process - address: %s", self.address), self.process)
 
 	return nil
 }
 
+// The read channel is closed once the workers are stopped.
 func (self *TcpSocketProcessor) Stop() error {
+	if self.worker != nil { self.worker.Stop() }
 	close(self.readChannel)
-	self.shutdownChannel <- true
 	return nil
 }
 
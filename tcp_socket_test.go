@@ -82,8 +82,11 @@ func TestTcpSocketProcessor(t *testing.T) {
 	writeChannel := make(chan TcpSocketProcessorWrite)
 	readChannel := make (chan TcpSocketProcessorRead)
 
+	kernel, err := NewTestKernel("tcpSocketProcessor", nil)
+	if err != nil { t.Errorf("TestTcpSocketProcessor is broken: %v", err); return }
+
 	processor := NewTcpSocketProcessor("127.0.0.1:9999", 1000, 0, 1000, 10, writeChannel, readChannel, logger)
-	processor.Start()
+	if err := processor.Start(kernel.Kernel); err != nil { t.Errorf("TestTcpSocketProcessor is broken - start: %v", err); return }
 
 	responseChannel := make(chan TcpSocketProcessorWrite)
 